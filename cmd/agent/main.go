@@ -5,7 +5,9 @@ import (
 	"crypto/rsa"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -16,14 +18,21 @@ import (
 	"github.com/go-resty/resty/v2"
 
 	"github.com/dsft54/rt-metrics/config/agent/settings"
+	"github.com/dsft54/rt-metrics/internal/agent/collector"
+	"github.com/dsft54/rt-metrics/internal/agent/grpcclient"
+	"github.com/dsft54/rt-metrics/internal/agent/retry"
 	"github.com/dsft54/rt-metrics/internal/agent/scheduller"
+	"github.com/dsft54/rt-metrics/internal/agent/spool"
 	"github.com/dsft54/rt-metrics/internal/agent/storage"
 	"github.com/dsft54/rt-metrics/internal/cryptokey"
 )
 
 // sendData собирает json в массив байт, и отправляет его при помощи resty.Client на
-// url в теле POST запроса.
-func sendData(url string, keyPath string, m interface{}, client *resty.Client) error {
+// url в теле POST запроса. Ответ сервера с кодом ошибки также считается неудачной отправкой.
+// Сетевые ошибки и ответы 429 и 5xx повторяются по политике policy с учетом заголовка Retry-After,
+// повторы и выполняемый запрос прерываются отменой ctx. Остальные ответы 4xx помечаются retry.Permanent.
+func sendData(ctx context.Context, url string, keyPath string, m interface{}, client *resty.Client,
+	policy retry.Policy) error {
 	rawData, err := json.Marshal(m)
 	if err != nil {
 		return err
@@ -39,18 +48,59 @@ func sendData(url string, keyPath string, m interface{}, client *resty.Client) e
 			return err
 		}
 	}
-	_, err = client.R().
-		SetHeader("Content-Type", "application/json").
-		SetBody(rawData).
-		Post(url)
-	return err
+	return policy.Do(ctx, func() error {
+		resp, err := client.R().
+			SetContext(ctx).
+			SetHeader("Content-Type", "application/json").
+			SetBody(rawData).
+			Post(url)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			return retry.Temporary(err, 0)
+		}
+		if !resp.IsError() {
+			return nil
+		}
+		err = fmt.Errorf("server responded with status %d", resp.StatusCode())
+		if retry.RetryableStatus(resp.StatusCode()) {
+			return retry.Temporary(err, retry.ParseRetryAfter(resp.Header().Get("Retry-After"), time.Now()))
+		}
+		if resp.StatusCode() < http.StatusInternalServerError {
+			// Сервер отклонил сами данные (подпись, расшифровка, формат), повторная отправка не поможет.
+			return retry.Permanent(err)
+		}
+		return err
+	})
 }
 
 // reportMetrics ожидает либо выхода по контексту, либо бродкаста на переменную состояния. Во втором
-// случае отправляет метрики на сервер либо штучно, либо списком.
-func reportMetrics(ctx context.Context, sch *scheduller.Scheduller, cfg *settings.Config, s *storage.MemStorage, wg *sync.WaitGroup) {
+// случае отправляет метрики на сервер либо штучно, либо списком, по http или grpc в зависимости от настроек.
+// Успешно отправленные метрики подтверждаются в хранилище, чтобы counter не учитывались сервером повторно.
+// Если задана очередь sp, неотправленные метрики сохраняются в нее и переотправляются списком перед
+// следующими отправками.
+func reportMetrics(ctx context.Context, sch *scheduller.Scheduller, cfg *settings.Config, s *storage.MemStorage,
+	sp *spool.Spool, wg *sync.WaitGroup) {
 	defer wg.Done()
 	client := resty.New()
+	var grpcClient *grpcclient.Client
+	if cfg.Transport == "grpc" {
+		var err error
+		grpcClient, err = grpcclient.NewClient(cfg.Address)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer grpcClient.Close()
+		grpcClient.Retry = cfg.RetryPolicy()
+	}
+	sendBatch := func(metrics []storage.Metrics) error {
+		if grpcClient != nil {
+			return grpcClient.UpdateBatch(ctx, metrics)
+		}
+		return sendData(ctx, "http://"+cfg.Address+"/updates", cfg.CryptoKey, &metrics, client, cfg.RetryPolicy())
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -63,64 +113,90 @@ func reportMetrics(ctx context.Context, sch *scheduller.Scheduller, cfg *setting
 				return
 			}
 			metricsSlice := s.ConvertToMetricsJSON(cfg.HashKey)
+			if sp != nil {
+				// Пока очередь не разобрана, новые метрики встают в ее конец, чтобы сохранить порядок.
+				replayed, err := sp.Replay(sendBatch)
+				if replayed > 0 {
+					log.Println("Replayed spooled reports:", replayed)
+				}
+				if err != nil {
+					log.Println("Spool replay stopped:", err)
+					spoolMetrics(sp, s, metricsSlice)
+					continue
+				}
+			}
+			var failed []storage.Metrics
 			if !cfg.Batched {
 				for _, value := range metricsSlice {
 					select {
 					case <-ctx.Done():
 						return
 					default:
-						err := sendData("http://"+cfg.Address+"/update", cfg.CryptoKey, &value, client)
+						var err error
+						if grpcClient != nil {
+							err = grpcClient.Update(ctx, value)
+						} else {
+							err = sendData(ctx, "http://"+cfg.Address+"/update", cfg.CryptoKey, &value, client, cfg.RetryPolicy())
+						}
 						if err != nil {
 							log.Println(err)
+							failed = append(failed, value)
 							continue
 						}
+						s.Acknowledge([]storage.Metrics{value})
 					}
 				}
 			} else {
-				err := sendData("http://"+cfg.Address+"/updates", cfg.CryptoKey, &metricsSlice, client)
+				err := sendBatch(metricsSlice)
 				if err != nil {
 					log.Println(err)
+					failed = metricsSlice
+				} else {
+					s.Acknowledge(metricsSlice)
 				}
 			}
+			if sp != nil && len(failed) != 0 {
+				spoolMetrics(sp, s, failed)
+			}
 			log.Println("Atempted to report all metrics. Interval", cfg.ReportInterval)
 		}
 	}
 }
 
-// pollRuntimeMetrics ожидает либо выхода по контексту, либо бродкаста на переменную состояния. Во втором случае
-// собирает в хранилище в памяти Runtime Metrics.
-func pollRuntimeMetrics(ctx context.Context, c *sync.Cond, s *storage.MemStorage, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			s.CollectRuntimeMetrics()
-			log.Println("All runtime memory stats collected")
-			c.L.Lock()
-			c.Wait()
-			c.L.Unlock()
-		}
+// spoolMetrics ставит неотправленные метрики в очередь. После записи на диск прирост counter считается
+// подтвержденным, так как дальше за его доставку отвечает очередь.
+func spoolMetrics(sp *spool.Spool, s *storage.MemStorage, metrics []storage.Metrics) {
+	if len(metrics) == 0 {
+		return
+	}
+	err := sp.Enqueue(metrics)
+	if err != nil {
+		log.Println("Spool enqueue failed:", err)
+		return
 	}
+	s.Acknowledge(metrics)
 }
 
-// pollPSUtilMetrics ожидает либо выхода по контексту, либо бродкаста на переменную состояния. Во втором случае
-// собирает в хранилище в памяти метрики процессора и памяти.
-func pollPSUtilMetrics(ctx context.Context, c *sync.Cond, s *storage.MemStorage, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			s.CollectPSUtilMetrics()
-			log.Println("All psutil memory stats collected")
-			c.L.Lock()
-			c.Wait()
-			c.L.Unlock()
+// startCollectors запускает все включенные в конфигурации сборщики метрик, каждый в своей горутине
+// со своим интервалом. Возвращает количество запущенных сборщиков, каждый из которых вычтет wg при выходе.
+func startCollectors(ctx context.Context, cfg *settings.Config, s *storage.MemStorage, wg *sync.WaitGroup) int {
+	started := 0
+	for _, name := range collector.Names() {
+		enabled, interval := cfg.Collector(name)
+		if !enabled {
+			log.Println("Collector disabled:", name)
+			continue
+		}
+		c, err := collector.New(name)
+		if err != nil {
+			log.Println(err)
+			continue
 		}
+		wg.Add(1)
+		go collector.Run(ctx, c, interval, s, wg)
+		started++
 	}
+	return started
 }
 
 func init() {
@@ -131,6 +207,19 @@ func init() {
 	flag.StringVar(&config.HashKey, "k", "", "SHA256 signing key")
 	flag.StringVar(&config.CryptoKey, "crypto-key", "", "Path to public rsa key")
 	flag.StringVar(&config.Config, "c", "", "Path to json config file")
+	flag.StringVar(&config.Transport, "transport", "", "Metrics transport: http or grpc, empty for http")
+	flag.IntVar(&config.RetryMaxAttempts, "retry-attempts", 0,
+		fmt.Sprintf("Report attempts including the first one, 0 for %d", retry.DefaultMaxAttempts))
+	flag.DurationVar(&config.RetryBaseDelay, "retry-base-delay", 0,
+		fmt.Sprintf("Delay before the first report retry, 0 for %v", retry.DefaultBaseDelay))
+	flag.DurationVar(&config.RetryMaxDelay, "retry-max-delay", 0,
+		fmt.Sprintf("Report retry delay limit, 0 for %v", retry.DefaultMaxDelay))
+	flag.StringVar(&config.Labels, "labels", "", "Labels added to all metrics, e.g. host=web1,region=eu")
+	flag.StringVar(&config.SpoolDir, "spool-dir", "", "Directory for failed reports queue, empty to disable")
+	flag.Int64Var(&config.SpoolMaxSize, "spool-max-size", 0,
+		fmt.Sprintf("Failed reports queue size limit in bytes, 0 for %d", spool.DefaultMaxSize))
+	flag.DurationVar(&config.SpoolMaxAge, "spool-max-age", 0,
+		fmt.Sprintf("Failed reports queue entry age limit, 0 for %v", spool.DefaultMaxAge))
 }
 
 var (
@@ -153,18 +242,48 @@ func main() {
 	if err != nil {
 		log.Println(err)
 	}
+	// Значения по умолчанию подставляются после файла настроек, иначе значения по умолчанию флагов
+	// не дали бы применить настройки из файла.
+	if config.Transport == "" {
+		config.Transport = "http"
+	}
+	if config.SpoolMaxSize == 0 {
+		config.SpoolMaxSize = spool.DefaultMaxSize
+	}
+	if config.SpoolMaxAge == 0 {
+		config.SpoolMaxAge = spool.DefaultMaxAge
+	}
+	if config.RetryMaxAttempts == 0 {
+		config.RetryMaxAttempts = retry.DefaultMaxAttempts
+	}
+	if config.RetryBaseDelay == 0 {
+		config.RetryBaseDelay = retry.DefaultBaseDelay
+	}
+	if config.RetryMaxDelay == 0 {
+		config.RetryMaxDelay = retry.DefaultMaxDelay
+	}
 	ms := storage.NewMemStorage()
+	ms.Labels, err = storage.ParseLabels(config.Labels)
+	if err != nil {
+		log.Println("Labels disabled:", err)
+	}
 	wg := new(sync.WaitGroup)
 	sch := scheduller.NewScheduller(&config)
 	syscallCancelChan := make(chan os.Signal, 1)
 	ctx, cancel := context.WithCancel(context.Background())
-	wg.Add(4)
+	wg.Add(2)
 
 	signal.Notify(syscallCancelChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	go sch.Start(ctx, wg)
-	go pollRuntimeMetrics(ctx, sch.Pc, ms, wg)
-	go pollPSUtilMetrics(ctx, sch.Pc, ms, wg)
-	go reportMetrics(ctx, sch, &config, ms, wg)
+	startCollectors(ctx, &config, ms, wg)
+	var sp *spool.Spool
+	if config.SpoolDir != "" {
+		sp, err = spool.NewSpool(config.SpoolDir, config.SpoolMaxSize, config.SpoolMaxAge)
+		if err != nil {
+			log.Println("Spool disabled:", err)
+		}
+	}
+	go reportMetrics(ctx, sch, &config, ms, sp, wg)
 	sig := <-syscallCancelChan
 	log.Printf("Caught syscall: %v", sig)
 	cancel()
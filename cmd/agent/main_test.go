@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/dsft54/rt-metrics/config/agent/settings"
+	"github.com/dsft54/rt-metrics/internal/agent/collector"
+	"github.com/dsft54/rt-metrics/internal/agent/retry"
 	"github.com/dsft54/rt-metrics/internal/agent/scheduller"
+	"github.com/dsft54/rt-metrics/internal/agent/spool"
 	"github.com/dsft54/rt-metrics/internal/agent/storage"
 	"github.com/go-resty/resty/v2"
 )
@@ -61,15 +68,134 @@ func Test_sendData(t *testing.T) {
 			wantErr: true,
 		},
 	}
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	tests = append(tests, []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name:    "server accepted",
+			args:    args{url: ok.URL, metrics: storage.Metrics{ID: "PollCount", MType: "counter"}},
+			wantErr: false,
+		},
+		{
+			name:    "server error status",
+			args:    args{url: failing.URL, metrics: storage.Metrics{ID: "PollCount", MType: "counter"}},
+			wantErr: true,
+		},
+	}...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := sendData(context.Background(), tt.args.url, tt.args.keyPath, &tt.args.metrics, client, retry.Policy{}); (err != nil) != tt.wantErr {
+				t.Errorf("sendData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_sendDataRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retryAfter   string
+		policy       retry.Policy
+		wantAttempts int
+		wantErr      bool
+		permanent    bool
+	}{
+		{
+			name:         "retry until success",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			policy:       retry.Policy{MaxAttempts: 5, BaseDelay: time.Millisecond},
+			wantAttempts: 3,
+		},
+		{
+			name:         "attempts exhausted",
+			statuses:     []int{http.StatusInternalServerError},
+			policy:       retry.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+			wantAttempts: 2,
+			wantErr:      true,
+		},
+		{
+			name:         "client error is not retried",
+			statuses:     []int{http.StatusBadRequest},
+			policy:       retry.Policy{MaxAttempts: 5, BaseDelay: time.Millisecond},
+			wantAttempts: 1,
+			wantErr:      true,
+			permanent:    true,
+		},
+		{
+			name:         "retry after header",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			retryAfter:   "1",
+			policy:       retry.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+			wantAttempts: 2,
+		},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := sendData(tt.args.url, tt.args.keyPath, &tt.args.metrics, client); (err != nil) != tt.wantErr {
+			var (
+				mutex    sync.Mutex
+				attempts int
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				status := tt.statuses[len(tt.statuses)-1]
+				if attempts < len(tt.statuses) {
+					status = tt.statuses[attempts]
+				}
+				attempts++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+			start := time.Now()
+			err := sendData(context.Background(), server.URL, "", storage.Metrics{ID: "Alloc", MType: "gauge"}, resty.New(), tt.policy)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("sendData() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if retry.IsPermanent(err) != tt.permanent {
+				t.Errorf("sendData() permanent = %v, want %v", retry.IsPermanent(err), tt.permanent)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if attempts != tt.wantAttempts {
+				t.Errorf("sendData() attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+			if tt.retryAfter != "" && time.Since(start) < time.Second {
+				t.Error("sendData() did not respect Retry-After")
+			}
 		})
 	}
 }
 
+func Test_sendDataCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := sendData(ctx, server.URL, "", storage.Metrics{ID: "Alloc", MType: "gauge"}, resty.New(),
+		retry.Policy{MaxAttempts: 10, BaseDelay: time.Minute})
+	if err == nil {
+		t.Error("sendData() expected error after cancel")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("sendData() retries did not stop on context cancel")
+	}
+}
+
 func Test_reportMetrics(t *testing.T) {
 	tests := []struct {
 		ctx  context.Context
@@ -126,7 +252,7 @@ func Test_reportMetrics(t *testing.T) {
 				var cancel context.CancelFunc
 				tt.wg.Add(1)
 				tt.ctx, cancel = context.WithCancel(context.Background())
-				go reportMetrics(tt.ctx, tt.sch, tt.cfg, tt.s, tt.wg)
+				go reportMetrics(tt.ctx, tt.sch, tt.cfg, tt.s, nil, tt.wg)
 				cancel()
 				select {
 				case <-time.NewTimer(500 * time.Millisecond).C:
@@ -138,7 +264,7 @@ func Test_reportMetrics(t *testing.T) {
 				tt.ctx = context.Background()
 				tt.wg.Add(1)
 				tt.sch.Update = false
-				go reportMetrics(tt.ctx, tt.sch, tt.cfg, tt.s, tt.wg)
+				go reportMetrics(tt.ctx, tt.sch, tt.cfg, tt.s, nil, tt.wg)
 				<-time.NewTimer(500 * time.Millisecond).C
 				tt.sch.Rc.Broadcast()
 				select {
@@ -152,7 +278,7 @@ func Test_reportMetrics(t *testing.T) {
 				var cancel context.CancelFunc
 				tt.wg.Add(1)
 				tt.ctx, cancel = context.WithCancel(context.Background())
-				go reportMetrics(tt.ctx, tt.sch, tt.cfg, tt.s, tt.wg)
+				go reportMetrics(tt.ctx, tt.sch, tt.cfg, tt.s, nil, tt.wg)
 				<-time.NewTimer(1000 * time.Millisecond).C
 				tt.sch.Rc.Broadcast()
 				cancel()
@@ -167,6 +293,87 @@ func Test_reportMetrics(t *testing.T) {
 	}
 }
 
+func Test_reportMetricsSpool(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		down     = true
+		received int64
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		metrics := []storage.Metrics{}
+		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, m := range metrics {
+			if m.MType == "counter" {
+				received += *m.Delta
+			}
+		}
+	}))
+	defer server.Close()
+	sp, err := spool.NewSpool(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &settings.Config{
+		Address:        strings.TrimPrefix(server.URL, "http://"),
+		Batched:        true,
+		PollInterval:   time.Hour,
+		ReportInterval: time.Hour,
+	}
+	sch := scheduller.NewScheduller(cfg)
+	s := storage.NewMemStorage()
+	wg := new(sync.WaitGroup)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go reportMetrics(ctx, sch, cfg, s, sp, wg)
+
+	// report повторяет бродкаст, пока горутина отправки не выполнит условие done.
+	report := func(done func() bool) {
+		for i := 0; i < 100 && !done(); i++ {
+			sch.Rc.Broadcast()
+			time.Sleep(20 * time.Millisecond)
+		}
+		if !done() {
+			t.Fatal("report was not processed")
+		}
+	}
+	s.AddCounter("PollCount", 2)
+	report(func() bool { n, _ := sp.Len(); return n > 0 })
+	s.AddCounter("PollCount", 3)
+	mutex.Lock()
+	down = false
+	mutex.Unlock()
+	report(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return received == 5
+	})
+	n, _ := sp.Len()
+	if n != 0 {
+		t.Errorf("spool length = %d after server recovery, want 0", n)
+	}
+	// Горутина завершается по контексту после очередного бродкаста.
+	cancel()
+	exited := wrapWait(wg)
+	for i := 0; i < 100; i++ {
+		select {
+		case <-exited:
+			return
+		case <-time.After(20 * time.Millisecond):
+			sch.Rc.Broadcast()
+		}
+	}
+	t.Error("Goroutine timeout error")
+}
+
 // helper function to allow using WaitGroup in a select
 func wrapWait(wg *sync.WaitGroup) <-chan struct{} {
 	out := make(chan struct{})
@@ -177,73 +384,51 @@ func wrapWait(wg *sync.WaitGroup) <-chan struct{} {
 	return out
 }
 
-func Test_pollRuntimeMetrics(t *testing.T) {
+func Test_startCollectors(t *testing.T) {
+	disabled := false
 	tests := []struct {
-		ctx  context.Context
-		c    *sync.Cond
-		s    *storage.MemStorage
-		wg   *sync.WaitGroup
 		name string
+		cfg  *settings.Config
+		want int
 	}{
 		{
-			name: "context exit, data collected",
-			c:    sync.NewCond(&sync.Mutex{}),
-			s:    storage.NewMemStorage(),
-			wg:   new(sync.WaitGroup),
+			name: "all collectors by default",
+			cfg:  &settings.Config{PollInterval: time.Second},
+			want: len(collector.Names()),
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			var cancel context.CancelFunc
-			tt.wg.Add(1)
-			tt.ctx, cancel = context.WithCancel(context.Background())
-			go pollRuntimeMetrics(tt.ctx, tt.c, tt.s, tt.wg)
-			<-time.NewTimer(100 * time.Millisecond).C
-			tt.c.Broadcast()
-			cancel()
-			select {
-			case <-time.NewTimer(500 * time.Millisecond).C:
-				t.Error("Goroutine timeout error")
-			case <-wrapWait(tt.wg):
-				if _, ok := tt.s.GaugeMetrics["Alloc"]; !ok {
-					t.Error("Failed to collect data")
-				}
-			}
-		})
-	}
-}
-
-func Test_pollPSUtilMetrics(t *testing.T) {
-	tests := []struct {
-		ctx  context.Context
-		c    *sync.Cond
-		s    *storage.MemStorage
-		wg   *sync.WaitGroup
-		name string
-	}{
 		{
-			name: "context exit, data collected",
-			c:    sync.NewCond(&sync.Mutex{}),
-			s:    storage.NewMemStorage(),
-			wg:   new(sync.WaitGroup),
+			name: "psutil disabled",
+			cfg: &settings.Config{
+				PollInterval: time.Second,
+				Collectors: map[string]settings.CollectorConfig{
+					collector.PSUtilName: {Enabled: &disabled},
+				},
+			},
+			want: len(collector.Names()) - 1,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var cancel context.CancelFunc
-			tt.wg.Add(1)
-			tt.ctx, cancel = context.WithCancel(context.Background())
-			go pollPSUtilMetrics(tt.ctx, tt.c, tt.s, tt.wg)
-			<-time.NewTimer(100 * time.Millisecond).C
-			tt.c.Broadcast()
+			s := storage.NewMemStorage()
+			wg := new(sync.WaitGroup)
+			ctx, cancel := context.WithCancel(context.Background())
+			got := startCollectors(ctx, tt.cfg, s, wg)
 			cancel()
 			select {
-			case <-time.NewTimer(500 * time.Millisecond).C:
+			case <-time.NewTimer(3000 * time.Millisecond).C:
 				t.Error("Goroutine timeout error")
-			case <-wrapWait(tt.wg):
-				if _, ok := tt.s.GaugeMetrics["TotalMemory"]; !ok {
-					t.Error("Failed to collect data")
-				}
+			case <-wrapWait(wg):
+			}
+			if got != tt.want {
+				t.Errorf("startCollectors() = %v, want %v", got, tt.want)
+			}
+			s.RLock()
+			defer s.RUnlock()
+			if _, ok := s.GaugeMetrics["Alloc"]; !ok {
+				t.Error("Failed to collect runtime data")
+			}
+			if _, ok := s.GaugeMetrics["TotalMemory"]; ok != (got == len(collector.Names())) {
+				t.Error("Psutil collector state mismatch")
 			}
 		})
 	}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/dsft54/rt-metrics/config/server/settings"
 	"github.com/dsft54/rt-metrics/internal/server/storage"
+	"github.com/dsft54/rt-metrics/internal/server/stream"
 )
 
 func Test_initStorages(t *testing.T) {
@@ -47,6 +51,28 @@ func Test_initStorages(t *testing.T) {
 	}
 }
 
+func Test_initStoragesSQLite(t *testing.T) {
+	tests := []struct {
+		name     string
+		dsn      string
+		wantType string
+	}{
+		{name: "sqlite", dsn: "sqlite://" + filepath.Join(t.TempDir(), "metrics.db"), wantType: "*storage.SQLiteStorage"},
+		{name: "sqlite fallback", dsn: "sqlite://", wantType: "*storage.MemoryStorage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := initStorages(context.Background(), settings.Config{DatabaseDSN: tt.dsn})
+			if gotType := fmt.Sprintf("%T", got); gotType != tt.wantType {
+				t.Errorf("initStorages() type = %s, want %s", gotType, tt.wantType)
+			}
+			if sq, ok := got.(*storage.SQLiteStorage); ok {
+				sq.Close()
+			}
+		})
+	}
+}
+
 func Test_setupGinRouter(t *testing.T) {
 	tests := []struct {
 		st   storage.IStorage
@@ -61,7 +87,7 @@ func Test_setupGinRouter(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := setupGinRouter(tt.st, tt.fs, "")
+			got := setupGinRouter(tt.st, tt.fs, stream.NewBroker(0), nil, nil, nil, "")
 			if len(got.Handlers) != 4 {
 				t.Error("Failed to build middleware chain, should be: ", len(got.Handlers))
 			}
@@ -72,3 +98,61 @@ func Test_setupGinRouter(t *testing.T) {
 		})
 	}
 }
+
+func Test_setupGRPCServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  settings.Config
+		wantErr bool
+	}{
+		{
+			name:   "normal grpc server",
+			config: settings.Config{TrustedSubnet: "192.168.1.0/24", HashKey: "test"},
+		},
+		{
+			name:    "bad subnet",
+			config:  settings.Config{TrustedSubnet: "192.168.1.0"},
+			wantErr: true,
+		},
+		{
+			name:    "bad trusted proxy",
+			config:  settings.Config{TrustedSubnet: "192.168.1.0/24", TrustedProxy: "10.0.0.1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setupGRPCServer(&storage.MemoryStorage{}, &storage.FileStorage{}, tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("setupGRPCServer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && len(got.GetServiceInfo()) != 1 {
+				t.Error("Metrics service is not registered")
+			}
+		})
+	}
+}
+
+func Test_runMigrate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config settings.Config
+		args   []string
+	}{
+		{name: "no command", args: nil},
+		{name: "unknown command", args: []string{"sideways"}},
+		{name: "up with arguments", args: []string{"up", "1"}},
+		{name: "bad down steps", args: []string{"down", "zero"}},
+		{name: "negative down steps", args: []string{"down", "-1"}},
+		{name: "no dsn", args: []string{"status"}},
+		{name: "bad dsn", config: settings.Config{DatabaseDSN: "255 0  0 1"}, args: []string{"up"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runMigrate(context.Background(), tt.config, tt.args, io.Discard); err == nil {
+				t.Error("runMigrate() expected error")
+			}
+		})
+	}
+}
@@ -5,6 +5,11 @@
 // ReportInterval - частота отправки метрик на сервер в секундах.
 // HashKey - ключ для подписи хеша.
 // Batched - отправлять метрики списком или штучно.
+// Transport - протокол отправки метрик: http или grpc.
+// RetryMaxAttempts, RetryBaseDelay и RetryMaxDelay - параметры повторов отправки метрик.
+// SpoolDir - каталог очереди неотправленных метрик, SpoolMaxSize и SpoolMaxAge - ее ограничения.
+// Labels - метки вида name=value,name=value, добавляемые ко всем метрикам агента.
+// Collectors - настройки сборщиков метрик по их именам, задаются только в файле конфигурации.
 package settings
 
 import (
@@ -12,24 +17,95 @@ import (
 	"fmt"
 	"io/ioutil"
 	"time"
+
+	"github.com/dsft54/rt-metrics/internal/agent/retry"
 )
 
 type Config struct {
-	Address        string        `env:"ADDRESS" json:"address"`
-	HashKey        string        `env:"ADDRESS" json:"hash_key"`
-	CryptoKey      string        `env:"CRYPTO_KEY" json:"crypto_key"`
-	Config         string        `env:"CONFIG"`
-	Batched        bool          `env:"BATCHED" json:"batched"`
-	PollInterval   time.Duration `env:"POLL_INTERVAL"`
-	ReportInterval time.Duration `env:"REPORT_INTERVAL"`
+	Address          string                     `env:"ADDRESS" json:"address"`
+	HashKey          string                     `env:"ADDRESS" json:"hash_key"`
+	CryptoKey        string                     `env:"CRYPTO_KEY" json:"crypto_key"`
+	Config           string                     `env:"CONFIG"`
+	Transport        string                     `env:"TRANSPORT" json:"transport"`
+	SpoolDir         string                     `env:"SPOOL_DIR" json:"spool_dir"`
+	Labels           string                     `env:"LABELS" json:"labels"`
+	SpoolMaxSize     int64                      `env:"SPOOL_MAX_SIZE" json:"spool_max_size"`
+	RetryMaxAttempts int                        `env:"RETRY_MAX_ATTEMPTS" json:"retry_max_attempts"`
+	Batched          bool                       `env:"BATCHED" json:"batched"`
+	PollInterval     time.Duration              `env:"POLL_INTERVAL"`
+	ReportInterval   time.Duration              `env:"REPORT_INTERVAL"`
+	SpoolMaxAge      time.Duration              `env:"SPOOL_MAX_AGE" json:"spool_max_age"`
+	RetryBaseDelay   time.Duration              `env:"RETRY_BASE_DELAY" json:"retry_base_delay"`
+	RetryMaxDelay    time.Duration              `env:"RETRY_MAX_DELAY" json:"retry_max_delay"`
+	Collectors       map[string]CollectorConfig `json:"collectors"`
+}
+
+// CollectorConfig настройки отдельного сборщика метрик. Сборщик без настроек включен и собирает метрики
+// с интервалом PollInterval, нулевой Interval также означает PollInterval.
+type CollectorConfig struct {
+	Enabled  *bool         `json:"enabled"`
+	Interval time.Duration `json:"-"`
+}
+
+func (cc *CollectorConfig) UnmarshalJSON(b []byte) error {
+	type CollectorConfigAlias CollectorConfig
+	aliasValue := &struct {
+		*CollectorConfigAlias
+		Interval interface{} `json:"interval"`
+	}{
+		CollectorConfigAlias: (*CollectorConfigAlias)(cc),
+	}
+	err := json.Unmarshal(b, &aliasValue)
+	if err != nil {
+		return err
+	}
+	switch value := aliasValue.Interval.(type) {
+	case nil:
+	case float64:
+		cc.Interval = time.Duration(value)
+	case string:
+		cc.Interval, err = time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid duration: %#v", aliasValue.Interval)
+	}
+	return nil
+}
+
+// RetryPolicy возвращает политику повторов отправки метрик по настройкам агента.
+func (c *Config) RetryPolicy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts: c.RetryMaxAttempts,
+		BaseDelay:   c.RetryBaseDelay,
+		MaxDelay:    c.RetryMaxDelay,
+		Jitter:      retry.DefaultJitter,
+	}
+}
+
+// Collector возвращает включен ли сборщик name и интервал его запуска.
+func (c *Config) Collector(name string) (bool, time.Duration) {
+	cc, ok := c.Collectors[name]
+	if !ok {
+		return true, c.PollInterval
+	}
+	interval := cc.Interval
+	if interval <= 0 {
+		interval = c.PollInterval
+	}
+	return cc.Enabled == nil || *cc.Enabled, interval
 }
 
 func (c *Config) UnmarshalJSON(b []byte) error {
 	type ConfigAlias Config
 	aliasValue := &struct {
 		*ConfigAlias
-		PollInt   interface{} `json:"poll_interval"`
-		ReportInt interface{} `json:"report_interval"`
+		PollInt        interface{} `json:"poll_interval"`
+		ReportInt      interface{} `json:"report_interval"`
+		SpoolMaxAge    interface{} `json:"spool_max_age"`
+		RetryBaseDelay interface{} `json:"retry_base_delay"`
+		RetryMaxDelay  interface{} `json:"retry_max_delay"`
 	}{
 		ConfigAlias: (*ConfigAlias)(c),
 	}
@@ -59,6 +135,33 @@ func (c *Config) UnmarshalJSON(b []byte) error {
 	default:
 		return fmt.Errorf("invalid duration: %#v", aliasValue.ReportInt)
 	}
+	err = optionalDuration(aliasValue.SpoolMaxAge, &c.SpoolMaxAge)
+	if err != nil {
+		return err
+	}
+	err = optionalDuration(aliasValue.RetryBaseDelay, &c.RetryBaseDelay)
+	if err != nil {
+		return err
+	}
+	return optionalDuration(aliasValue.RetryMaxDelay, &c.RetryMaxDelay)
+}
+
+// optionalDuration разбирает необязательную длительность из json: строку в формате time.Duration или
+// число наносекунд. Отсутствующее значение оставляет d без изменений.
+func optionalDuration(value interface{}, d *time.Duration) error {
+	var err error
+	switch value := value.(type) {
+	case nil:
+	case float64:
+		*d = time.Duration(value)
+	case string:
+		*d, err = time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid duration: %#v", value)
+	}
 	return nil
 }
 
@@ -84,11 +187,38 @@ func (c *Config) ParseFromFile() error {
 	if c.CryptoKey == "" && fC.CryptoKey != "" {
 		c.CryptoKey = fC.CryptoKey
 	}
+	if c.Transport == "" && fC.Transport != "" {
+		c.Transport = fC.Transport
+	}
+	if c.SpoolDir == "" && fC.SpoolDir != "" {
+		c.SpoolDir = fC.SpoolDir
+	}
+	if c.Labels == "" && fC.Labels != "" {
+		c.Labels = fC.Labels
+	}
+	if c.SpoolMaxSize == 0 && fC.SpoolMaxSize != 0 {
+		c.SpoolMaxSize = fC.SpoolMaxSize
+	}
+	if c.SpoolMaxAge == 0 && fC.SpoolMaxAge != 0 {
+		c.SpoolMaxAge = fC.SpoolMaxAge
+	}
+	if c.RetryMaxAttempts == 0 && fC.RetryMaxAttempts != 0 {
+		c.RetryMaxAttempts = fC.RetryMaxAttempts
+	}
+	if c.RetryBaseDelay == 0 && fC.RetryBaseDelay != 0 {
+		c.RetryBaseDelay = fC.RetryBaseDelay
+	}
+	if c.RetryMaxDelay == 0 && fC.RetryMaxDelay != 0 {
+		c.RetryMaxDelay = fC.RetryMaxDelay
+	}
 	if c.PollInterval == 0 && fC.PollInterval != 0 {
 		c.PollInterval = fC.PollInterval
 	}
 	if c.ReportInterval == 0 && fC.ReportInterval != 0 {
 		c.ReportInterval = fC.ReportInterval
 	}
+	if c.Collectors == nil && fC.Collectors != nil {
+		c.Collectors = fC.Collectors
+	}
 	return nil
 }
@@ -1,6 +1,9 @@
 package settings
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -110,6 +113,30 @@ func TestConfig_UnmarshalJSON(t *testing.T) {
 			}`,
 			wantErr: true,
 		},
+		{
+			name:    "Spool max age error test",
+			c:       &Config{},
+			json:    `{"poll_interval": "1s", "report_interval": "1s", "spool_max_age": "1g"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Retry delay error test",
+			c:       &Config{},
+			json:    `{"poll_interval": "1s", "report_interval": "1s", "retry_base_delay": "1g"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Retry max delay error test",
+			c:       &Config{},
+			json:    `{"poll_interval": "1s", "report_interval": "1s", "retry_max_delay": "1g"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Spool max age type error test",
+			c:       &Config{},
+			json:    `{"poll_interval": "1s", "report_interval": "1s", "spool_max_age": true}`,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -215,3 +242,90 @@ func TestConfig_ParseFromFile(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_ParseFromFileDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	err := os.WriteFile(path, []byte(`{
+		"poll_interval": "1s",
+		"report_interval": "1s",
+		"transport": "grpc",
+		"spool_max_size": 1024,
+		"spool_max_age": "1h",
+		"retry_max_attempts": 5,
+		"retry_base_delay": "200ms",
+		"retry_max_delay": 30000000000
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		c    Config
+		want Config
+	}{
+		{
+			name: "flags at defaults",
+			c:    Config{Config: path},
+			want: Config{Config: path, Transport: "grpc", PollInterval: time.Second, ReportInterval: time.Second,
+				SpoolMaxSize: 1024, SpoolMaxAge: time.Hour, RetryMaxAttempts: 5, RetryBaseDelay: 200 * time.Millisecond,
+				RetryMaxDelay: 30 * time.Second},
+		},
+		{
+			name: "flags set",
+			c: Config{Config: path, Transport: "http", SpoolMaxSize: 1, SpoolMaxAge: time.Minute, RetryMaxAttempts: 1,
+				RetryBaseDelay: time.Second, RetryMaxDelay: time.Second},
+			want: Config{Config: path, Transport: "http", PollInterval: time.Second, ReportInterval: time.Second,
+				SpoolMaxSize: 1, SpoolMaxAge: time.Minute, RetryMaxAttempts: 1, RetryBaseDelay: time.Second,
+				RetryMaxDelay: time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.ParseFromFile(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.c, tt.want) {
+				t.Errorf("Config.ParseFromFile() = %+v, want %+v", tt.c, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Collector(t *testing.T) {
+	c := &Config{}
+	err := c.UnmarshalJSON([]byte(`{
+		"poll_interval": "2s",
+		"report_interval": "10s",
+		"collectors": {
+			"psutil": {"enabled": false},
+			"runtime": {"interval": "500ms"},
+			"custom": {"enabled": true, "interval": 1000000000}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		wantEnabled  bool
+		wantInterval time.Duration
+	}{
+		{name: "psutil", wantEnabled: false, wantInterval: 2 * time.Second},
+		{name: "runtime", wantEnabled: true, wantInterval: 500 * time.Millisecond},
+		{name: "custom", wantEnabled: true, wantInterval: time.Second},
+		{name: "unknown", wantEnabled: true, wantInterval: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled, interval := c.Collector(tt.name)
+			if enabled != tt.wantEnabled || interval != tt.wantInterval {
+				t.Errorf("Config.Collector() = %v, %v, want %v, %v", enabled, interval, tt.wantEnabled, tt.wantInterval)
+			}
+		})
+	}
+	err = c.UnmarshalJSON([]byte(`{"poll_interval": "2s", "report_interval": "10s",
+		"collectors": {"runtime": {"interval": "soon"}}}`))
+	if err == nil {
+		t.Error("Config.UnmarshalJSON() expected collector interval error")
+	}
+}
@@ -15,9 +15,41 @@ type Config struct {
 	HashKey       string        `env:"KEY" json:"hash_key"`
 	DatabaseDSN   string        `env:"DATABASE_DSN" json:"database_dsn"`
 	CryptoKey     string        `env:"CRYPTO_KEY" json:"crypto_key"`
+	StatsdAddress string        `env:"STATSD_ADDRESS" json:"statsd_address"`
+	GRPCAddress   string        `env:"GRPC_ADDRESS" json:"grpc_address"`
+	TrustedSubnet string        `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	TrustedProxy  string        `env:"TRUSTED_PROXY" json:"trusted_proxy"`
+	AlertRules    string        `env:"ALERT_RULES" json:"alert_rules"`
+	AlertWebhook  string        `env:"ALERT_WEBHOOK" json:"alert_webhook"`
+	WALFile       string        `env:"WAL_FILE" json:"wal_file"`
 	Config        string        `env:"CONFIG"`
 	Restore       bool          `env:"RESTORE" json:"restore"`
+	// DatabaseMaxConns размер пула подключений к базе.
+	DatabaseMaxConns int `env:"DATABASE_MAX_CONNS" json:"database_max_conns"`
+	// MemoryShards количество сегментов хранилища в памяти, 1 - хранилище без сегментов, 0 - не задано
+	// (сервер использует storage.DefaultShards).
+	MemoryShards int `env:"MEMORY_SHARDS" json:"memory_shards"`
 	StoreInterval time.Duration `env:"STORE_INTERVAL"`
+	// HistoryRetention глубина хранения истории значений метрик в памяти и в базе, 0 - история в памяти
+	// отключена, а в базе хранится без ограничения.
+	HistoryRetention time.Duration `env:"HISTORY_RETENTION"`
+	// AlertInterval интервал проверки правил оповещения.
+	AlertInterval time.Duration `env:"ALERT_INTERVAL"`
+	// StoreKeep сколько последних снимков хранить в файлах с отметкой времени.
+	StoreKeep int `env:"STORE_KEEP" json:"store_keep"`
+	// WALSyncInterval интервал сброса журнала на диск, 0 - после каждого обновления.
+	WALSyncInterval time.Duration `env:"WAL_SYNC_INTERVAL"`
+	// StaleTTL через сколько после последнего обновления ряд метрики считается устаревшим, 0 - отключено.
+	StaleTTL time.Duration `env:"STALE_TTL"`
+	// StaleEvict удалять устаревшие ряды вместо того, чтобы помечать их.
+	StaleEvict bool `env:"STALE_EVICT" json:"stale_evict"`
+	// RollupInterval интервал построения агрегатов истории, 0 - агрегаты отключены.
+	RollupInterval time.Duration `env:"ROLLUP_INTERVAL"`
+	// RollupMinuteRetention, RollupHourRetention и RollupDayRetention глубина хранения агрегатов
+	// с разрешением 1 минута, 1 час и 1 день, 0 - без ограничения.
+	RollupMinuteRetention time.Duration `env:"ROLLUP_1M_RETENTION"`
+	RollupHourRetention   time.Duration `env:"ROLLUP_1H_RETENTION"`
+	RollupDayRetention    time.Duration `env:"ROLLUP_1D_RETENTION"`
 }
 
 func (c *Config) UnmarshalJSON(b []byte) error {
@@ -71,9 +103,42 @@ func (c *Config) ParseFromFile() error {
 	if c.DatabaseDSN == "" && fC.DatabaseDSN != "" {
 		c.DatabaseDSN = fC.DatabaseDSN
 	}
+	if c.DatabaseMaxConns == 0 && fC.DatabaseMaxConns != 0 {
+		c.DatabaseMaxConns = fC.DatabaseMaxConns
+	}
 	if c.CryptoKey == "" && fC.CryptoKey != "" {
 		c.CryptoKey = fC.CryptoKey
 	}
+	if c.StatsdAddress == "" && fC.StatsdAddress != "" {
+		c.StatsdAddress = fC.StatsdAddress
+	}
+	if c.GRPCAddress == "" && fC.GRPCAddress != "" {
+		c.GRPCAddress = fC.GRPCAddress
+	}
+	if c.TrustedSubnet == "" && fC.TrustedSubnet != "" {
+		c.TrustedSubnet = fC.TrustedSubnet
+	}
+	if c.TrustedProxy == "" && fC.TrustedProxy != "" {
+		c.TrustedProxy = fC.TrustedProxy
+	}
+	if c.AlertRules == "" && fC.AlertRules != "" {
+		c.AlertRules = fC.AlertRules
+	}
+	if c.MemoryShards == 0 && fC.MemoryShards != 0 {
+		c.MemoryShards = fC.MemoryShards
+	}
+	if c.StoreKeep == 0 && fC.StoreKeep != 0 {
+		c.StoreKeep = fC.StoreKeep
+	}
+	if c.WALFile == "" && fC.WALFile != "" {
+		c.WALFile = fC.WALFile
+	}
+	if c.AlertWebhook == "" && fC.AlertWebhook != "" {
+		c.AlertWebhook = fC.AlertWebhook
+	}
+	if !c.StaleEvict && fC.StaleEvict {
+		c.StaleEvict = fC.StaleEvict
+	}
 	if c.StoreInterval == 0 && fC.StoreInterval != 0 {
 		c.StoreInterval = fC.StoreInterval
 	}
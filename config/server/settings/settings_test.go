@@ -3,6 +3,8 @@
 package settings
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -128,6 +130,42 @@ func TestConfig_ParseFromFile(t *testing.T) {
 	}
 }
 
+func TestConfig_ParseFromFileMemoryShards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg.json")
+	err := ioutil.WriteFile(path, []byte(`{"memory_shards": 1, "store_interval": "1s"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		shards int
+		want   int
+	}{
+		{
+			name:   "from file",
+			shards: 0,
+			want:   1,
+		},
+		{
+			name:   "flag or env wins",
+			shards: 8,
+			want:   8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{MemoryShards: tt.shards, Config: path}
+			err := c.ParseFromFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.MemoryShards != tt.want {
+				t.Errorf("MemoryShards = %d, want %d", c.MemoryShards, tt.want)
+			}
+		})
+	}
+}
+
 func TestConfig_UnmarshalJSON(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -0,0 +1,91 @@
+// Package collector определяет интерфейс сборщиков метрик агента и их реестр. Сборщик регистрируется
+// в init своего файла через Register, после чего агент запускает его по имени со своим интервалом,
+// заданным в конфигурации. Для добавления нового сборщика main.go менять не нужно.
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+)
+
+// Collector сборщик метрик, который записывает собранные значения в хранилище агента.
+type Collector interface {
+	// Name возвращает имя сборщика, под которым он зарегистрирован и настраивается.
+	Name() string
+	// Collect собирает метрики в хранилище. Ошибка не останавливает дальнейшие запуски сборщика.
+	Collect(s *storage.MemStorage) error
+}
+
+// Factory функция, создающая новый экземпляр сборщика.
+type Factory func() Collector
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.RWMutex
+)
+
+// Register добавляет сборщик в реестр. Повторная регистрация имени считается ошибкой программы.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("collector: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Names возвращает отсортированный список имен зарегистрированных сборщиков.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New создает зарегистрированный сборщик по имени.
+func New(name string) (Collector, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("collector: unknown collector %q", name)
+	}
+	return factory(), nil
+}
+
+// Run запускает сборщик сразу и затем с интервалом interval до ctx.Done. Ошибки сборки пишутся в лог
+// с именем сборщика, а их количество учитывается в counter CollectErrors.<имя>.
+func Run(ctx context.Context, c Collector, interval time.Duration, s *storage.MemStorage, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		collect(c, s)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collect выполняет один запуск сборщика с учетом ошибки.
+func collect(c Collector, s *storage.MemStorage) {
+	err := c.Collect(s)
+	if err != nil {
+		log.Printf("Collector %s failed: %v", c.Name(), err)
+		s.AddCounter("CollectErrors."+c.Name(), 1)
+		return
+	}
+	log.Printf("Collector %s: metrics collected", c.Name())
+}
@@ -0,0 +1,126 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+)
+
+type failingCollector struct{}
+
+func (failingCollector) Name() string {
+	return "failing"
+}
+
+func (failingCollector) Collect(s *storage.MemStorage) error {
+	return errors.New("test error")
+}
+
+func TestRegistry(t *testing.T) {
+	assert.Equal(t, Names(), []string{GCPauseName, PSUtilName, RuntimeName})
+	c, err := New(RuntimeName)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, c.Name(), RuntimeName)
+	_, err = New("unknown")
+	assert.NotEqual(t, err, nil)
+
+	defer func() {
+		assert.NotEqual(t, recover(), nil)
+	}()
+	Register(RuntimeName, func() Collector { return Runtime{} })
+}
+
+func TestCollectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Collector
+		gauge   string
+		wantErr bool
+	}{
+		{
+			name:  "runtime",
+			c:     Runtime{},
+			gauge: "Alloc",
+		},
+		{
+			name:  "psutil",
+			c:     PSUtil{},
+			gauge: "TotalMemory",
+		},
+		{
+			name:    "failing",
+			c:       failingCollector{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storage.NewMemStorage()
+			err := tt.c.Collect(s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Collect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.gauge != "" && s.GaugeMetrics[tt.gauge] == 0 {
+				t.Errorf("Collect() did not set %s", tt.gauge)
+			}
+		})
+	}
+}
+
+func TestGCPause(t *testing.T) {
+	s := storage.NewMemStorage()
+	c := &GCPause{}
+	runtime.GC()
+	assert.Equal(t, c.Collect(s), nil)
+	s.RLock()
+	h := s.HistogramMetrics["GCPauseSeconds"]
+	assert.Equal(t, len(h.Counts), len(GCPauseBounds)+1)
+	var observed int64
+	for _, count := range h.Counts {
+		observed += count
+	}
+	s.RUnlock()
+	if observed == 0 {
+		t.Fatal("Collect() did not observe GC pauses")
+	}
+
+	runtime.GC()
+	assert.Equal(t, c.Collect(s), nil)
+	s.RLock()
+	defer s.RUnlock()
+	var total int64
+	for _, count := range h.Counts {
+		total += count
+	}
+	// Между запусками мог пройти и фоновый сборщик, поэтому новых пауз не меньше одной.
+	if total <= observed {
+		t.Errorf("Collect() observed %d pauses after GC, want more than %d", total, observed)
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := storage.NewMemStorage()
+	wg := new(sync.WaitGroup)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(2)
+	go Run(ctx, Runtime{}, 10*time.Millisecond, s, wg)
+	go Run(ctx, failingCollector{}, 10*time.Millisecond, s, wg)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	wg.Wait()
+	s.RLock()
+	defer s.RUnlock()
+	if s.CounterMetrics["PollCount"] < 2 {
+		t.Errorf("Run() collected %d times, want periodic collection", s.CounterMetrics["PollCount"])
+	}
+	if s.CounterMetrics["CollectErrors.failing"] < 2 {
+		t.Errorf("Run() counted %d errors, want periodic error reports", s.CounterMetrics["CollectErrors.failing"])
+	}
+}
@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"runtime"
+
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+)
+
+// GCPauseName имя сборщика распределения пауз сборщика мусора.
+const GCPauseName = "gcpause"
+
+// GCPauseBounds границы корзин гистограммы пауз сборщика мусора в секундах, от 10мкс до 100мс.
+var GCPauseBounds = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}
+
+func init() {
+	Register(GCPauseName, func() Collector { return &GCPause{} })
+}
+
+// GCPause добавляет в гистограмму GCPauseSeconds паузы сборок мусора, завершившихся с прошлого запуска.
+// runtime.MemStats хранит только последние 256 пауз, поэтому при редком запуске более ранние теряются.
+type GCPause struct {
+	lastNumGC uint32
+}
+
+// Name возвращает имя сборщика.
+func (*GCPause) Name() string {
+	return GCPauseName
+}
+
+// Collect собирает паузы новых сборок мусора в хранилище.
+func (g *GCPause) Collect(s *storage.MemStorage) error {
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+	from := g.lastNumGC
+	if memstats.NumGC-from > uint32(len(memstats.PauseNs)) {
+		from = memstats.NumGC - uint32(len(memstats.PauseNs))
+	}
+	pauses := make([]float64, 0, memstats.NumGC-from)
+	for i := from; i < memstats.NumGC; i++ {
+		pauses = append(pauses, float64(memstats.PauseNs[i%uint32(len(memstats.PauseNs))])/1e9)
+	}
+	g.lastNumGC = memstats.NumGC
+	s.ObserveHistogram("GCPauseSeconds", GCPauseBounds, pauses...)
+	return nil
+}
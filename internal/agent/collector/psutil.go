@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+)
+
+// PSUtilName имя сборщика загрузки процессора и утилизации памяти.
+const PSUtilName = "psutil"
+
+func init() {
+	Register(PSUtilName, func() Collector { return PSUtil{} })
+}
+
+// PSUtil собирает общий и свободный объем памяти и загрузку каждого ядра процессора.
+type PSUtil struct{}
+
+// Name возвращает имя сборщика.
+func (PSUtil) Name() string {
+	return PSUtilName
+}
+
+// Collect собирает метрики процессора и памяти в хранилище.
+func (PSUtil) Collect(s *storage.MemStorage) error {
+	v, err := mem.VirtualMemory()
+	if err != nil {
+		return err
+	}
+	c, err := cpu.Percent(0, true)
+	if err != nil {
+		return err
+	}
+	values := map[string]float64{
+		"TotalMemory": float64(v.Total),
+		"FreeMemory":  float64(v.Free),
+	}
+	for i, value := range c {
+		values["CPUutilization"+strconv.Itoa(i+1)] = value
+	}
+	s.SetGauges(values)
+	return nil
+}
@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"math/rand"
+	"runtime"
+
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+)
+
+// RuntimeName имя сборщика статистики рантайма Go.
+const RuntimeName = "runtime"
+
+func init() {
+	Register(RuntimeName, func() Collector { return Runtime{} })
+}
+
+// Runtime собирает основные метрики runtime.ReadMemStats (27 из 33), случайное значение RandomValue
+// и увеличивает counter PollCount.
+type Runtime struct{}
+
+// Name возвращает имя сборщика.
+func (Runtime) Name() string {
+	return RuntimeName
+}
+
+// Collect собирает метрики рантайма в хранилище.
+func (Runtime) Collect(s *storage.MemStorage) error {
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+	s.SetGauges(map[string]float64{
+		"Alloc":         float64(memstats.Alloc),
+		"BuckHashSys":   float64(memstats.BuckHashSys),
+		"Frees":         float64(memstats.Frees),
+		"GCCPUFraction": memstats.GCCPUFraction,
+		"GCSys":         float64(memstats.GCSys),
+		"HeapAlloc":     float64(memstats.HeapAlloc),
+		"HeapIdle":      float64(memstats.HeapIdle),
+		"HeapInuse":     float64(memstats.HeapInuse),
+		"HeapObjects":   float64(memstats.HeapObjects),
+		"HeapReleased":  float64(memstats.HeapReleased),
+		"HeapSys":       float64(memstats.HeapSys),
+		"LastGC":        float64(memstats.LastGC),
+		"Lookups":       float64(memstats.Lookups),
+		"MCacheInuse":   float64(memstats.MCacheInuse),
+		"MCacheSys":     float64(memstats.MCacheSys),
+		"MSpanInuse":    float64(memstats.MSpanInuse),
+		"MSpanSys":      float64(memstats.MSpanSys),
+		"Mallocs":       float64(memstats.Mallocs),
+		"NextGC":        float64(memstats.NextGC),
+		"NumForcedGC":   float64(memstats.NumForcedGC),
+		"NumGC":         float64(memstats.NumGC),
+		"OtherSys":      float64(memstats.OtherSys),
+		"PauseTotalNs":  float64(memstats.PauseTotalNs),
+		"StackInuse":    float64(memstats.StackInuse),
+		"StackSys":      float64(memstats.StackSys),
+		"Sys":           float64(memstats.Sys),
+		"TotalAlloc":    float64(memstats.TotalAlloc),
+		"RandomValue":   rand.Float64(),
+	})
+	s.AddCounter("PollCount", 1)
+	return nil
+}
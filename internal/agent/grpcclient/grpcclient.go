@@ -0,0 +1,134 @@
+// Package grpcclient определяет клиента gRPC сервиса Metrics, которым агент может отправлять метрики
+// вместо http запросов.
+package grpcclient
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dsft54/rt-metrics/internal/agent/retry"
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+	pb "github.com/dsft54/rt-metrics/internal/proto"
+)
+
+// realIPKey ключ метаданных, в котором сервер ожидает ip адрес агента.
+const realIPKey = "x-real-ip"
+
+// Client соединение с gRPC сервером и ip адрес агента, с которого оно устанавливается. Retry - политика
+// повторов запросов, по умолчанию запрос выполняется один раз.
+type Client struct {
+	Retry  retry.Policy
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+	realIP string
+}
+
+// NewClient функция-конструктор, создающая соединение с сервером по адресу address. Соединение
+// устанавливается лениво, при первом запросе.
+func NewClient(address string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Retry:  retry.Policy{MaxAttempts: 1},
+		conn:   conn,
+		client: pb.NewMetricsClient(conn),
+		realIP: localIP(address),
+	}, nil
+}
+
+// localIP определяет ip адрес интерфейса, через который идет маршрут до сервера.
+func localIP(address string) string {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// outgoing добавляет в контекст запроса метаданные с ip адресом агента.
+func (c *Client) outgoing(ctx context.Context) context.Context {
+	if c.realIP == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, realIPKey, c.realIP)
+}
+
+// Update отправляет на сервер одну метрику с повторами по политике Retry.
+func (c *Client) Update(ctx context.Context, m storage.Metrics) error {
+	return c.Retry.Do(ctx, func() error {
+		_, err := c.client.Update(c.outgoing(ctx), &pb.UpdateRequest{Metric: toProto(m)})
+		return temporary(err)
+	})
+}
+
+// UpdateBatch отправляет на сервер список метрик потоком с повторами по политике Retry.
+func (c *Client) UpdateBatch(ctx context.Context, metrics []storage.Metrics) error {
+	return c.Retry.Do(ctx, func() error {
+		return temporary(c.updateBatch(ctx, metrics))
+	})
+}
+
+// temporary помечает как временные ошибки недоступности и перегрузки сервера, а как постоянные - отказ
+// сервера принять сами метрики из-за неверной подписи или значения.
+func temporary(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return retry.Temporary(err, 0)
+	case codes.InvalidArgument:
+		return retry.Permanent(err)
+	}
+	return err
+}
+
+// updateBatch выполняет одну попытку потоковой отправки списка метрик.
+func (c *Client) updateBatch(ctx context.Context, metrics []storage.Metrics) error {
+	stream, err := c.client.UpdateBatch(c.outgoing(ctx))
+	if err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		err = stream.Send(toProto(m))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// Close закрывает соединение с сервером.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func toProto(m storage.Metrics) *pb.Metric {
+	metric := &pb.Metric{
+		Id:     m.ID,
+		Type:   m.MType,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Hash:   m.Hash,
+		Labels: m.Labels,
+	}
+	if m.Histogram != nil {
+		metric.Histogram = &pb.Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+		}
+	}
+	return metric
+}
@@ -0,0 +1,92 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/go-playground/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/dsft54/rt-metrics/internal/agent/retry"
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+	pb "github.com/dsft54/rt-metrics/internal/proto"
+	"github.com/dsft54/rt-metrics/internal/server/grpcserver"
+	serverstorage "github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestClient(t *testing.T) {
+	var (
+		v float64 = 3.14
+		d int64   = 3
+	)
+	st := &serverstorage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	pb.RegisterMetricsServer(server, grpcserver.NewMetricsServer(st, &serverstorage.FileStorage{}))
+	go server.Serve(listener)
+	defer server.Stop()
+
+	client, err := NewClient("localhost:3200", grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.NotEqual(t, client.realIP, "")
+
+	err = client.Update(context.Background(), storage.Metrics{ID: "Alloc", MType: "gauge", Value: &v})
+	if err != nil {
+		t.Error(err)
+	}
+	err = client.UpdateBatch(context.Background(), []storage.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &d},
+		{ID: "PollCount", MType: "counter", Delta: &d},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	err = client.UpdateBatch(context.Background(), []storage.Metrics{
+		{ID: "Latency", MType: "histogram", Histogram: &storage.Histogram{Bounds: []float64{1}, Counts: []int64{2, 1}, Sum: 3}},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	err = client.Update(context.Background(), storage.Metrics{ID: "Alloc", MType: "gauge"})
+	assert.NotEqual(t, err, nil)
+	err = client.Update(context.Background(), storage.Metrics{ID: "Latency", MType: "histogram"})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, st.GaugeMetrics["Alloc"], v)
+	assert.Equal(t, st.CounterMetrics["PollCount"], int64(6))
+	assert.Equal(t, st.HistogramMetrics["Latency"].Counts, []int64{2, 1})
+}
+
+func Test_temporary(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      bool
+		permanent bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "down"), want: true},
+		{name: "resource exhausted", err: status.Error(codes.ResourceExhausted, "busy"), want: true},
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "denied"), want: false},
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "wrong metric hash"), permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var temp *retry.Error
+			assert.Equal(t, errors.As(temporary(tt.err), &temp), tt.want)
+			assert.Equal(t, retry.IsPermanent(temporary(tt.err)), tt.permanent)
+		})
+	}
+}
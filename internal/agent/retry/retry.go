@@ -0,0 +1,169 @@
+// Package retry реализует политику повторных отправок агента: экспоненциальную задержку со случайным
+// разбросом, учет заголовка Retry-After и остановку по отмене контекста. Повторяются только ошибки,
+// помеченные как временные через Temporary, остальные возвращаются сразу. Ошибки, помеченные Permanent,
+// означают, что сервер отклонил сами данные и их не стоит отправлять снова.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Значения политики по умолчанию.
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelay   = time.Second
+	DefaultMaxDelay    = 10 * time.Second
+	DefaultJitter      = 0.5
+)
+
+// Error временная ошибка, после которой отправку можно повторить. RetryAfter - минимальная задержка
+// перед повтором, если ее указал сервер.
+type Error struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// Error реализует интерфейс error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap возвращает исходную ошибку.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary помечает ошибку как временную. Для nil возвращает nil.
+func Temporary(err error, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, RetryAfter: retryAfter}
+}
+
+// PermanentError ошибка, которую повтор той же отправки не исправит: сервер отклонил данные, например
+// из-за неверной подписи или невозможности расшифровать сообщение.
+type PermanentError struct {
+	Err error
+}
+
+// Error реализует интерфейс error.
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap возвращает исходную ошибку.
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent помечает ошибку как постоянную. Для nil возвращает nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent сообщает, помечена ли ошибка как постоянная.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// RetryableStatus сообщает, стоит ли повторять запрос, получивший ответ с кодом status: 429 и 5xx.
+func RetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// ParseRetryAfter разбирает значение заголовка Retry-After в секундах или в формате даты HTTP.
+// Для пустого или некорректного значения возвращает 0.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
+
+// Policy политика повторов. MaxAttempts - общее количество попыток, включая первую. Задержка перед
+// n-м повтором равна BaseDelay*2^n, но не больше MaxDelay, и уменьшается на случайную долю до Jitter.
+// MaxDelay ограничивает и задержку, запрошенную сервером в Retry-After.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// DefaultPolicy возвращает политику со значениями по умолчанию.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: DefaultMaxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+		MaxDelay:    DefaultMaxDelay,
+		Jitter:      DefaultJitter,
+	}
+}
+
+// Backoff возвращает задержку перед повтором с номером attempt, начиная с 0.
+func (p Policy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// delay возвращает задержку перед повтором с номером attempt. Задержку из Retry-After сервера политика
+// выдерживает, если она больше Backoff, но не дольше MaxDelay, чтобы сервер не мог остановить отправку
+// на произвольное время.
+func (p Policy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.Backoff(attempt)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Do выполняет fn, повторяя ее после временных ошибок, пока не кончатся попытки. Ожидание прерывается
+// отменой ctx, в этом случае возвращается последняя ошибка fn.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		var temporary *Error
+		if err == nil || !errors.As(err, &temporary) || attempt+1 >= p.MaxAttempts {
+			return err
+		}
+		timer := time.NewTimer(p.delay(attempt, temporary.RetryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
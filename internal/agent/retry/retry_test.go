@@ -0,0 +1,128 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestPolicy_Backoff(t *testing.T) {
+	p := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 0, want: 100 * time.Millisecond},
+		{attempt: 1, want: 200 * time.Millisecond},
+		{attempt: 3, want: 800 * time.Millisecond},
+		{attempt: 4, want: time.Second},
+		{attempt: 100, want: time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, p.Backoff(tt.attempt), tt.want)
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := p.Backoff(1)
+		if delay < 100*time.Millisecond || delay > 200*time.Millisecond {
+			t.Errorf("Policy.Backoff() with jitter = %v, want in [100ms, 200ms]", delay)
+		}
+	}
+}
+
+func TestPolicy_Do(t *testing.T) {
+	errTemp := Temporary(errors.New("temporary"), 0)
+	errPerm := errors.New("permanent")
+	tests := []struct {
+		name         string
+		errs         []error
+		maxAttempts  int
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "success", errs: []error{nil}, maxAttempts: 3, wantAttempts: 1},
+		{name: "temporary then success", errs: []error{errTemp, errTemp, nil}, maxAttempts: 3, wantAttempts: 3},
+		{name: "attempts exhausted", errs: []error{errTemp, errTemp, errTemp, nil}, maxAttempts: 3, wantAttempts: 3, wantErr: errTemp},
+		{name: "permanent error", errs: []error{errPerm, nil}, maxAttempts: 3, wantAttempts: 1, wantErr: errPerm},
+		{name: "zero attempts runs once", errs: []error{errTemp, nil}, maxAttempts: 0, wantAttempts: 1, wantErr: errTemp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Policy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond}
+			attempts := 0
+			err := p.Do(context.Background(), func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			assert.Equal(t, err, tt.wantErr)
+			assert.Equal(t, attempts, tt.wantAttempts)
+		})
+	}
+}
+
+func TestPolicy_delay(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxDelay   time.Duration
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{name: "backoff", maxDelay: 10 * time.Second, want: time.Second},
+		{name: "retry after", maxDelay: 10 * time.Second, retryAfter: 5 * time.Second, want: 5 * time.Second},
+		{name: "retry after capped", maxDelay: 10 * time.Second, retryAfter: time.Hour, want: 10 * time.Second},
+		{name: "no limit", retryAfter: time.Hour, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Policy{BaseDelay: time.Second, MaxDelay: tt.maxDelay}
+			assert.Equal(t, p.delay(0, tt.retryAfter), tt.want)
+		})
+	}
+}
+
+func TestPolicy_DoCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	p := Policy{MaxAttempts: 5, BaseDelay: time.Minute}
+	attempts := 0
+	start := time.Now()
+	err := p.Do(ctx, func() error {
+		attempts++
+		return Temporary(errors.New("down"), time.Minute)
+	})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, attempts, 1)
+	if time.Since(start) > time.Second {
+		t.Error("Policy.Do() did not stop on context cancel")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "5", want: 5 * time.Second},
+		{value: "-1", want: 0},
+		{value: "Mon, 01 Aug 2022 12:00:30 GMT", want: 30 * time.Second},
+		{value: "Mon, 01 Aug 2022 11:00:00 GMT", want: 0},
+		{value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, ParseRetryAfter(tt.value, now), tt.want)
+	}
+	assert.Equal(t, RetryableStatus(429), true)
+	assert.Equal(t, RetryableStatus(503), true)
+	assert.Equal(t, RetryableStatus(404), false)
+	assert.Equal(t, Temporary(nil, 0), nil)
+	assert.Equal(t, errors.Unwrap(Temporary(errors.New("x"), 0)).Error(), "x")
+	assert.Equal(t, Permanent(nil), nil)
+	assert.Equal(t, IsPermanent(fmt.Errorf("wrapped: %w", Permanent(errors.New("x")))), true)
+	assert.Equal(t, IsPermanent(Temporary(errors.New("x"), 0)), false)
+}
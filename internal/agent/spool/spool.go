@@ -0,0 +1,201 @@
+// Package spool реализует очередь неотправленных метрик агента на диске. Каждая неудачная отправка
+// сохраняется отдельным файлом в каталоге очереди и переотправляется в порядке записи, когда сервер
+// снова доступен. Очередь ограничена общим размером и возрастом записей и переживает перезапуск агента.
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/agent/retry"
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+)
+
+// Значения ограничений очереди по умолчанию.
+const (
+	DefaultMaxSize = 64 << 20
+	DefaultMaxAge  = 24 * time.Hour
+)
+
+// fileSuffix расширение файлов записей очереди.
+const fileSuffix = ".json"
+
+// Spool очередь записей в каталоге Dir. Имя файла записи - время постановки в очередь в наносекундах,
+// дополненное нулями, поэтому лексикографический порядок файлов совпадает с порядком записи.
+// Ограничение MaxSize задается в байтах, при его превышении удаляются самые старые записи.
+// Записи старше MaxAge удаляются без отправки. Нулевые ограничения не действуют.
+type Spool struct {
+	Dir     string
+	MaxSize int64
+	MaxAge  time.Duration
+	last    int64
+	mutex   sync.Mutex
+}
+
+// NewSpool функция-конструктор для Spool, создающая каталог очереди при необходимости.
+func NewSpool(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{Dir: dir, MaxSize: maxSize, MaxAge: maxAge}, nil
+}
+
+// entry файл записи очереди.
+type entry struct {
+	name string
+	size int64
+	time time.Time
+}
+
+// Enqueue сохраняет список метрик в конец очереди. Запись сначала пишется во временный файл и затем
+// переименовывается, поэтому при сбое в очереди не остается недописанных записей.
+func (s *Spool) Enqueue(metrics []storage.Metrics) error {
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := time.Now().UnixNano()
+	if id <= s.last {
+		id = s.last + 1
+	}
+	s.last = id
+	name := fmt.Sprintf("%020d%s", id, fileSuffix)
+	tmp, err := ioutil.TempFile(s.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return s.enforceSize()
+}
+
+// Replay отправляет записи очереди по порядку функцией send и удаляет успешно отправленные.
+// Записи, которые сервер отклонил окончательно (ошибка помечена retry.Permanent), удаляются, иначе
+// они навсегда остановили бы очередь. На любой другой ошибке отправка останавливается, чтобы не нарушать
+// порядок, и эта ошибка возвращается. Возвращает количество отправленных записей.
+func (s *Spool) Replay(send func([]storage.Metrics) error) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries, err := s.entries()
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, e := range entries {
+		path := filepath.Join(s.Dir, e.name)
+		if s.MaxAge > 0 && time.Since(e.time) > s.MaxAge {
+			log.Println("Spool: dropping expired entry", e.name)
+			os.Remove(path)
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return sent, err
+		}
+		metrics := []storage.Metrics{}
+		err = json.Unmarshal(data, &metrics)
+		if err != nil {
+			log.Println("Spool: dropping corrupted entry", e.name, err)
+			os.Remove(path)
+			continue
+		}
+		err = send(metrics)
+		if retry.IsPermanent(err) {
+			log.Println("Spool: dropping rejected entry", e.name, err)
+			os.Remove(path)
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// Len возвращает количество записей в очереди.
+func (s *Spool) Len() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries, err := s.entries()
+	return len(entries), err
+}
+
+// enforceSize удаляет самые старые записи, пока общий размер очереди превышает MaxSize.
+// Вызывается под блокировкой.
+func (s *Spool) enforceSize() error {
+	if s.MaxSize <= 0 {
+		return nil
+	}
+	entries, err := s.entries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	for i := 0; total > s.MaxSize && i < len(entries); i++ {
+		log.Println("Spool: size limit exceeded, dropping entry", entries[i].name)
+		err = os.Remove(filepath.Join(s.Dir, entries[i].name))
+		if err != nil {
+			return err
+		}
+		total -= entries[i].size
+	}
+	return nil
+}
+
+// entries возвращает записи очереди в порядке постановки. Временные и посторонние файлы пропускаются.
+func (s *Spool) entries() ([]entry, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := []entry{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(name, fileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{name: name, size: info.Size(), time: time.Unix(0, nanos)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
@@ -0,0 +1,123 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/agent/retry"
+	"github.com/dsft54/rt-metrics/internal/agent/storage"
+)
+
+func batch(id string, delta int64) []storage.Metrics {
+	return []storage.Metrics{{ID: id, MType: "counter", Delta: &delta}}
+}
+
+func TestSpool_EnqueueReplay(t *testing.T) {
+	s, err := NewSpool(filepath.Join(t.TempDir(), "spool"), 0, 0)
+	assert.Equal(t, err, nil)
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, s.Enqueue(batch(fmt.Sprint("m", i), int64(i))), nil)
+	}
+	n, err := s.Len()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 3)
+
+	// Отправка останавливается на первой ошибке, оставшиеся записи сохраняются по порядку.
+	var got []string
+	sent, err := s.Replay(func(m []storage.Metrics) error {
+		if m[0].ID == "m2" {
+			return errors.New("server down")
+		}
+		got = append(got, m[0].ID)
+		return nil
+	})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, sent, 1)
+	assert.Equal(t, got, []string{"m1"})
+
+	// Новый экземпляр на том же каталоге продолжает очередь, как после перезапуска агента.
+	restarted, err := NewSpool(s.Dir, 0, 0)
+	assert.Equal(t, err, nil)
+	sent, err = restarted.Replay(func(m []storage.Metrics) error {
+		got = append(got, m[0].ID)
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, sent, 2)
+	assert.Equal(t, got, []string{"m1", "m2", "m3"})
+	n, _ = restarted.Len()
+	assert.Equal(t, n, 0)
+}
+
+func TestSpool_ReplayRejected(t *testing.T) {
+	s, err := NewSpool(filepath.Join(t.TempDir(), "spool"), 0, 0)
+	assert.Equal(t, err, nil)
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, s.Enqueue(batch(fmt.Sprint("m", i), int64(i))), nil)
+	}
+	// Отклоненная сервером запись удаляется и не останавливает очередь.
+	var got []string
+	sent, err := s.Replay(func(m []storage.Metrics) error {
+		if m[0].ID == "m2" {
+			return retry.Permanent(errors.New("server responded with status 400"))
+		}
+		got = append(got, m[0].ID)
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, sent, 2)
+	assert.Equal(t, got, []string{"m1", "m3"})
+	n, _ := s.Len()
+	assert.Equal(t, n, 0)
+
+	// Временная ошибка останавливает очередь, запись остается.
+	assert.Equal(t, s.Enqueue(batch("m4", 4)), nil)
+	sent, err = s.Replay(func(m []storage.Metrics) error {
+		return retry.Temporary(errors.New("server responded with status 503"), 0)
+	})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, sent, 0)
+	n, _ = s.Len()
+	assert.Equal(t, n, 1)
+}
+
+func TestSpool_Limits(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 0, time.Hour)
+	assert.Equal(t, err, nil)
+	// Устаревшая и поврежденная записи пропускаются при отправке.
+	expired := fmt.Sprintf("%020d%s", time.Now().Add(-2*time.Hour).UnixNano(), fileSuffix)
+	assert.Equal(t, ioutil.WriteFile(filepath.Join(dir, expired), []byte("[]"), 0644), nil)
+	corrupted := fmt.Sprintf("%020d%s", time.Now().Add(-time.Minute).UnixNano(), fileSuffix)
+	assert.Equal(t, ioutil.WriteFile(filepath.Join(dir, corrupted), []byte("[{"), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(filepath.Join(dir, "foreign.txt"), []byte("x"), 0644), nil)
+	assert.Equal(t, s.Enqueue(batch("fresh", 1)), nil)
+	var got []string
+	sent, err := s.Replay(func(m []storage.Metrics) error {
+		got = append(got, m[0].ID)
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, sent, 1)
+	assert.Equal(t, got, []string{"fresh"})
+
+	// При превышении размера удаляются самые старые записи.
+	size := int64(len(`[{"id":"m0","type":"counter","delta":0}]`))
+	s.MaxSize = 2 * size
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, s.Enqueue(batch(fmt.Sprint("m", i), 0)), nil)
+	}
+	got = nil
+	_, err = s.Replay(func(m []storage.Metrics) error {
+		got = append(got, m[0].ID)
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, got, []string{"m2", "m3"})
+}
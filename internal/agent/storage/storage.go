@@ -7,13 +7,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
-	"runtime"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
-
-	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/mem"
 )
 
 type (
@@ -23,111 +21,208 @@ type (
 
 // Metrics json совместимая структура для отправки метрик POST запросом штучно или списком.
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // значение хеш-функции
+	ID        string            `json:"id"`                  // имя метрики
+	MType     string            `json:"type"`                // параметр, принимающий значение gauge, counter или histogram
+	Labels    map[string]string `json:"labels,omitempty"`    // метки, отличающие ряды метрики с одним именем
+	Delta     *int64            `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64          `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *Histogram        `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Hash      string            `json:"hash,omitempty"`      // значение хеш-функции
+}
+
+// Histogram распределение значений по корзинам в том же виде, что принимает сервер: Bounds - верхние
+// границы корзин по возрастанию, Counts - количество значений в каждой корзине и последней корзине +Inf,
+// Sum - сумма значений.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Sum    float64   `json:"sum"`
+}
+
+// count возвращает общее количество значений.
+func (h *Histogram) count() int64 {
+	var count int64
+	for _, c := range h.Counts {
+		count += c
+	}
+	return count
+}
+
+// histogramHashInput часть строки, от которой считается хеш метрики histogram. Должна совпадать с тем,
+// как хеш проверяет сервер.
+func histogramHashInput(h *Histogram) string {
+	bounds := make([]string, len(h.Bounds))
+	for i, b := range h.Bounds {
+		bounds[i] = strconv.FormatFloat(b, 'g', -1, 64)
+	}
+	counts := make([]string, len(h.Counts))
+	for i, c := range h.Counts {
+		counts[i] = strconv.FormatInt(c, 10)
+	}
+	return fmt.Sprintf("%s:%s:%f", strings.Join(bounds, ","), strings.Join(counts, ","), h.Sum)
+}
+
+// labelNamePattern допустимое название метки, такое же как на сервере.
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ParseLabels разбирает набор меток вида name=value,name=value из настроек агента.
+func ParseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || !labelNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("bad label %q, want name=value", pair)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+	return labels, nil
 }
 
-// MemStorage хранилище в памяти состоящее из массивов двух типов и мьютекса для потокобезопасного
-// обращения к ним.
+// labelsHashSuffix дополнение строки, от которой считается хеш метрики с метками: ":" и метки вида
+// name="value", упорядоченные по названию. Должно совпадать с тем, как хеш проверяет сервер.
+func labelsHashSuffix(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return ":" + strings.Join(pairs, ",")
+}
+
+// MemStorage хранилище в памяти состоящее из массивов трех типов и мьютекса для потокобезопасного
+// обращения к ним. Значения counter и гистограммы накапливаются, а в acknowledged и ackHistograms хранится
+// часть каждого из них, уже принятая сервером, чтобы отправлять только прирост с последней успешной отправки.
+// Labels добавляются ко всем отправляемым метрикам, чтобы метрики разных агентов не смешивались.
 type MemStorage struct {
-	GaugeMetrics   map[string]gauge
-	CounterMetrics map[string]counter
+	GaugeMetrics     map[string]gauge
+	CounterMetrics   map[string]counter
+	HistogramMetrics map[string]*Histogram
+	Labels           map[string]string
+	acknowledged     map[string]counter
+	ackHistograms    map[string]*Histogram
 	sync.RWMutex
 }
 
 // NewMemStorage функция конструктор, инициализирующая массивы структуры MemStorage.
 func NewMemStorage() *MemStorage {
 	ms := MemStorage{
-		GaugeMetrics:   make(map[string]gauge),
-		CounterMetrics: make(map[string]counter),
+		GaugeMetrics:     make(map[string]gauge),
+		CounterMetrics:   make(map[string]counter),
+		HistogramMetrics: make(map[string]*Histogram),
+		acknowledged:     make(map[string]counter),
+		ackHistograms:    make(map[string]*Histogram),
 	}
 	return &ms
 }
 
-// CollectRuntimeMetrics определяет основные метрики для отправки (27 из 33 возвращаемых runtime.ReadMemStats).
-func (ms *MemStorage) CollectRuntimeMetrics() {
-	var memstats runtime.MemStats
-	runtime.ReadMemStats(&memstats)
+// SetGauge потокобезопасно устанавливает значение gauge.
+func (ms *MemStorage) SetGauge(name string, value float64) {
 	ms.Lock()
 	defer ms.Unlock()
-	ms.GaugeMetrics["Alloc"] = gauge(memstats.Alloc)
-	ms.GaugeMetrics["BuckHashSys"] = gauge(memstats.BuckHashSys)
-	ms.GaugeMetrics["Frees"] = gauge(memstats.Frees)
-	ms.GaugeMetrics["GCCPUFraction"] = gauge(memstats.GCCPUFraction)
-	ms.GaugeMetrics["GCSys"] = gauge(memstats.GCSys)
-	ms.GaugeMetrics["HeapAlloc"] = gauge(memstats.HeapAlloc)
-	ms.GaugeMetrics["HeapIdle"] = gauge(memstats.HeapIdle)
-	ms.GaugeMetrics["HeapInuse"] = gauge(memstats.HeapInuse)
-	ms.GaugeMetrics["HeapObjects"] = gauge(memstats.HeapObjects)
-	ms.GaugeMetrics["HeapReleased"] = gauge(memstats.HeapReleased)
-	ms.GaugeMetrics["HeapSys"] = gauge(memstats.HeapSys)
-	ms.GaugeMetrics["LastGC"] = gauge(memstats.LastGC)
-	ms.GaugeMetrics["Lookups"] = gauge(memstats.Lookups)
-	ms.GaugeMetrics["MCacheInuse"] = gauge(memstats.MCacheInuse)
-	ms.GaugeMetrics["MCacheSys"] = gauge(memstats.MCacheSys)
-	ms.GaugeMetrics["MSpanInuse"] = gauge(memstats.MSpanInuse)
-	ms.GaugeMetrics["MSpanSys"] = gauge(memstats.MSpanSys)
-	ms.GaugeMetrics["Mallocs"] = gauge(memstats.Mallocs)
-	ms.GaugeMetrics["NextGC"] = gauge(memstats.NextGC)
-	ms.GaugeMetrics["NumForcedGC"] = gauge(memstats.NumForcedGC)
-	ms.GaugeMetrics["NumGC"] = gauge(memstats.NumGC)
-	ms.GaugeMetrics["OtherSys"] = gauge(memstats.OtherSys)
-	ms.GaugeMetrics["PauseTotalNs"] = gauge(memstats.PauseTotalNs)
-	ms.GaugeMetrics["StackInuse"] = gauge(memstats.StackInuse)
-	ms.GaugeMetrics["StackSys"] = gauge(memstats.StackSys)
-	ms.GaugeMetrics["Sys"] = gauge(memstats.Sys)
-	ms.GaugeMetrics["TotalAlloc"] = gauge(memstats.TotalAlloc)
-	ms.GaugeMetrics["RandomValue"] = gauge(rand.Float64())
-	ms.CounterMetrics["PollCount"] += 1
+	ms.GaugeMetrics[name] = gauge(value)
 }
 
-// CollectPSUtilMetrics дополнительный набор метрик, который будет собираться в другой горутине.
-// Собирается загрузка процессора и утилизация памяти.
-func (ms *MemStorage) CollectPSUtilMetrics() error {
-	v, err := mem.VirtualMemory()
-	if err != nil {
-		return err
-	}
-	c, err := cpu.Percent(0, true)
-	if err != nil {
-		return err
+// SetGauges потокобезопасно устанавливает значения нескольких gauge под одной блокировкой.
+func (ms *MemStorage) SetGauges(values map[string]float64) {
+	ms.Lock()
+	defer ms.Unlock()
+	for name, value := range values {
+		ms.GaugeMetrics[name] = gauge(value)
 	}
+}
+
+// AddCounter потокобезопасно увеличивает значение counter на delta.
+func (ms *MemStorage) AddCounter(name string, delta int64) {
 	ms.Lock()
-	ms.GaugeMetrics["TotalMemory"] = gauge(v.Total)
-	ms.GaugeMetrics["FreeMemory"] = gauge(v.Free)
-	for i, value := range c {
-		ms.GaugeMetrics["CPUutilization"+strconv.Itoa(i+1)] = gauge(value)
+	defer ms.Unlock()
+	ms.CounterMetrics[name] += counter(delta)
+}
+
+// ObserveHistogram потокобезопасно добавляет значения values в гистограмму name. Значение попадает
+// в первую корзину, верхняя граница которой не меньше него, или в корзину +Inf. Границы bounds задаются
+// при первом наблюдении гистограммы и дальше не меняются.
+func (ms *MemStorage) ObserveHistogram(name string, bounds []float64, values ...float64) {
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.HistogramMetrics == nil {
+		ms.HistogramMetrics = make(map[string]*Histogram)
+	}
+	h, ok := ms.HistogramMetrics[name]
+	if !ok {
+		h = &Histogram{
+			Bounds: append([]float64{}, bounds...),
+			Counts: make([]int64, len(bounds)+1),
+		}
+		ms.HistogramMetrics[name] = h
+	}
+	for _, v := range values {
+		h.Counts[sort.SearchFloat64s(h.Bounds, v)]++
+		h.Sum += v
 	}
-	ms.Unlock()
-	return nil
 }
 
 // ConvertToMetricsJSON преобразует все имеющиеся метрики в хранилище в список json
-// совместимых структур Metrics, при наличии ключа, также считает хеш.
+// совместимых структур Metrics с метками Labels, при наличии ключа, также считает хеш, в том числе
+// от меток. Для counter и гистограмм передается прирост с последнего подтверждения, метрики без прироста
+// не включаются в список.
 func (ms *MemStorage) ConvertToMetricsJSON(hkey string) []Metrics {
 	metricsSlice := []Metrics{}
 	ms.RLock()
 	for id, value := range ms.GaugeMetrics {
-		metricsPart := Metrics{MType: "gauge", ID: id}
+		metricsPart := Metrics{MType: "gauge", ID: id, Labels: ms.Labels}
 		v := float64(value)
 		metricsPart.Value = &v
 		if hkey != "" {
 			h := hmac.New(sha256.New, []byte(hkey))
-			h.Write([]byte(fmt.Sprintf("%s:gauge:%f", id, v)))
+			h.Write([]byte(fmt.Sprintf("%s:gauge:%f", id, v) + labelsHashSuffix(ms.Labels)))
 			metricsPart.Hash = hex.EncodeToString(h.Sum(nil))
 		}
 		metricsSlice = append(metricsSlice, metricsPart)
 	}
-	for id, delta := range ms.CounterMetrics {
-		metricsPart := Metrics{MType: "counter", ID: id}
-		v := int64(delta)
+	for id, value := range ms.CounterMetrics {
+		v := int64(value - ms.acknowledged[id])
+		if v == 0 {
+			continue
+		}
+		metricsPart := Metrics{MType: "counter", ID: id, Labels: ms.Labels}
 		metricsPart.Delta = &v
 		if hkey != "" {
 			h := hmac.New(sha256.New, []byte(hkey))
-			h.Write([]byte(fmt.Sprintf("%s:counter:%d", id, v)))
+			h.Write([]byte(fmt.Sprintf("%s:counter:%d", id, v) + labelsHashSuffix(ms.Labels)))
+			metricsPart.Hash = hex.EncodeToString(h.Sum(nil))
+		}
+		metricsSlice = append(metricsSlice, metricsPart)
+	}
+	for id, value := range ms.HistogramMetrics {
+		delta := &Histogram{
+			Bounds: append([]float64{}, value.Bounds...),
+			Counts: append([]int64{}, value.Counts...),
+			Sum:    value.Sum,
+		}
+		if ack, ok := ms.ackHistograms[id]; ok {
+			for i := range delta.Counts {
+				delta.Counts[i] -= ack.Counts[i]
+			}
+			delta.Sum -= ack.Sum
+		}
+		if delta.count() == 0 {
+			continue
+		}
+		metricsPart := Metrics{MType: "histogram", ID: id, Labels: ms.Labels, Histogram: delta}
+		if hkey != "" {
+			h := hmac.New(sha256.New, []byte(hkey))
+			h.Write([]byte(fmt.Sprintf("%s:histogram:%s", id, histogramHashInput(delta)) + labelsHashSuffix(ms.Labels)))
 			metricsPart.Hash = hex.EncodeToString(h.Sum(nil))
 		}
 		metricsSlice = append(metricsSlice, metricsPart)
@@ -137,16 +232,51 @@ func (ms *MemStorage) ConvertToMetricsJSON(hkey string) []Metrics {
 }
 
 // ConvertToURLParams преобразует все имеющиеся метрики в хранилище в список строк вида
-// /тип/название/значение для их дальнейшей отправки на сервер.
+// /тип/название/значение для их дальнейшей отправки на сервер. Для counter, как и в
+// ConvertToMetricsJSON, передается прирост с последнего подтверждения.
 func (ms *MemStorage) ConvertToURLParams() []string {
 	urlsList := []string{}
 	ms.RLock()
 	for id, value := range ms.GaugeMetrics {
 		urlsList = append(urlsList, fmt.Sprintf("/%s/%s/%v", "gauge", id, value))
 	}
-	for id, delta := range ms.CounterMetrics {
-		urlsList = append(urlsList, fmt.Sprintf("/%s/%s/%v", "counter", id, delta))
+	for id, value := range ms.CounterMetrics {
+		urlsList = append(urlsList, fmt.Sprintf("/%s/%s/%v", "counter", id, value-ms.acknowledged[id]))
 	}
 	ms.RUnlock()
 	return urlsList
 }
+
+// Acknowledge отмечает отправленные метрики как принятые сервером. Приросты counter и гистограмм из metrics
+// добавляются к подтвержденной части, поэтому значения, собранные после формирования списка, будут
+// отправлены в следующий раз. Если отправка не удалась, Acknowledge не вызывается и неподтвержденный
+// прирост уходит со следующей отправкой.
+func (ms *MemStorage) Acknowledge(metrics []Metrics) {
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.acknowledged == nil {
+		ms.acknowledged = make(map[string]counter)
+	}
+	if ms.ackHistograms == nil {
+		ms.ackHistograms = make(map[string]*Histogram)
+	}
+	for _, metric := range metrics {
+		switch {
+		case metric.MType == "counter" && metric.Delta != nil:
+			ms.acknowledged[metric.ID] += counter(*metric.Delta)
+		case metric.MType == "histogram" && metric.Histogram != nil:
+			ack, ok := ms.ackHistograms[metric.ID]
+			if !ok {
+				ack = &Histogram{Counts: make([]int64, len(metric.Histogram.Counts))}
+				ms.ackHistograms[metric.ID] = ack
+			}
+			if len(ack.Counts) != len(metric.Histogram.Counts) {
+				continue
+			}
+			for i, c := range metric.Histogram.Counts {
+				ack.Counts[i] += c
+			}
+			ack.Sum += metric.Histogram.Sum
+		}
+	}
+}
@@ -5,10 +5,16 @@
 package storage
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"math"
 	"reflect"
 	"sort"
 	"testing"
+
+	serverstorage "github.com/dsft54/rt-metrics/internal/server/storage"
 )
 
 func ExampleMemStorage_ConvertToURLParams() {
@@ -52,47 +58,14 @@ func TestNewMemStorage(t *testing.T) {
 	}
 }
 
-func TestMemStorage_CollectRuntimeMetrics(t *testing.T) {
-	tests := []struct {
-		ms   *MemStorage
-		name string
-	}{
-		{
-			name: "normal conditions test",
-			ms:   NewMemStorage(),
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.ms.CollectRuntimeMetrics()
-			if tt.ms.GaugeMetrics["Alloc"] == 0 || tt.ms.CounterMetrics["PollCount"] != 1 {
-				t.Error("Cannot collect runtime metrics")
-			}
-		})
-	}
-}
-
-func TestMemStorage_CollectPSUtilMetrics(t *testing.T) {
-	tests := []struct {
-		ms      *MemStorage
-		name    string
-		wantErr bool
-	}{
-		{
-			name:    "normal conditions test",
-			ms:      NewMemStorage(),
-			wantErr: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.ms.CollectPSUtilMetrics(); (err != nil) != tt.wantErr {
-				t.Errorf("MemStorage.CollectPSUtilMetrics() error = %v, wantErr %v", err, tt.wantErr)
-			}
-			if tt.ms.GaugeMetrics["TotalMemory"] == 0 {
-				t.Error("Cannot collect PSutil metrics")
-			}
-		})
+func TestMemStorage_Setters(t *testing.T) {
+	ms := NewMemStorage()
+	ms.SetGauge("Alloc", 3.14)
+	ms.SetGauges(map[string]float64{"Alloc": 6.28, "Heap": 1})
+	ms.AddCounter("PollCount", 1)
+	ms.AddCounter("PollCount", 2)
+	if ms.GaugeMetrics["Alloc"] != 6.28 || ms.GaugeMetrics["Heap"] != 1 || ms.CounterMetrics["PollCount"] != 3 {
+		t.Errorf("MemStorage setters result = %v, %v", ms.GaugeMetrics, ms.CounterMetrics)
 	}
 }
 
@@ -164,3 +137,105 @@ func TestMemStorage_ConvertToMetricsJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestMemStorage_Acknowledge(t *testing.T) {
+	ms := &MemStorage{
+		GaugeMetrics:   map[string]gauge{},
+		CounterMetrics: map[string]counter{"PollCount": 3},
+	}
+	sent := ms.ConvertToMetricsJSON("")
+	if len(sent) != 1 || *sent[0].Delta != 3 {
+		t.Fatalf("first report = %v, want PollCount delta 3", sent)
+	}
+	// Значение собрано после формирования списка, но до подтверждения.
+	ms.CounterMetrics["PollCount"] += 2
+	ms.Acknowledge(sent)
+	if got := ms.ConvertToURLParams(); !reflect.DeepEqual(got, []string{"/counter/PollCount/2"}) {
+		t.Errorf("MemStorage.ConvertToURLParams() = %v, want only delta 2", got)
+	}
+
+	// Неудачная отправка не подтверждается, прирост переносится в следующую.
+	failed := ms.ConvertToMetricsJSON("")
+	ms.CounterMetrics["PollCount"]++
+	retry := ms.ConvertToMetricsJSON("")
+	if *failed[0].Delta != 2 || *retry[0].Delta != 3 {
+		t.Errorf("deltas = %d, %d, want 2, 3", *failed[0].Delta, *retry[0].Delta)
+	}
+	ms.Acknowledge(retry)
+	if got := ms.ConvertToMetricsJSON(""); len(got) != 0 {
+		t.Errorf("MemStorage.ConvertToMetricsJSON() = %v, want no counters without delta", got)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "pairs", s: "host=web1, region=eu", want: map[string]string{"host": "web1", "region": "eu"}},
+		{name: "no value", s: "host", wantErr: true},
+		{name: "bad name", s: "host-name=web1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLabels(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemStorage_ConvertToMetricsJSONLabels(t *testing.T) {
+	labels := map[string]string{"region": "eu", "host": `web"1`}
+	ms := NewMemStorage()
+	ms.Labels = labels
+	ms.SetGauge("Alloc", 3.14)
+	got := ms.ConvertToMetricsJSON("key")
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Labels, labels) {
+		t.Fatalf("ConvertToMetricsJSON() = %v, want labels %v", got, labels)
+	}
+	h := hmac.New(sha256.New, []byte("key"))
+	h.Write([]byte(`Alloc:gauge:3.140000:host="web\"1",region="eu"`))
+	if got[0].Hash != hex.EncodeToString(h.Sum(nil)) {
+		t.Errorf("ConvertToMetricsJSON() hash does not cover labels")
+	}
+	// Сервер проверяет хеш с тем же дополнением.
+	if labelsHashSuffix(labels) != serverstorage.LabelsHashSuffix(labels) {
+		t.Errorf("labelsHashSuffix() = %s, server uses %s", labelsHashSuffix(labels), serverstorage.LabelsHashSuffix(labels))
+	}
+}
+
+func TestMemStorage_Histogram(t *testing.T) {
+	bounds := []float64{0.001, 0.01}
+	ms := NewMemStorage()
+	ms.ObserveHistogram("GCPauseSeconds", bounds, 0.0005, 0.001, 0.005, 1)
+	sent := ms.ConvertToMetricsJSON("key")
+	if len(sent) != 1 || !reflect.DeepEqual(sent[0].Histogram.Counts, []int64{2, 1, 1}) {
+		t.Fatalf("ConvertToMetricsJSON() = %v, want counts [2 1 1]", sent)
+	}
+	server := &serverstorage.Histogram{Bounds: bounds, Counts: sent[0].Histogram.Counts, Sum: sent[0].Histogram.Sum}
+	h := hmac.New(sha256.New, []byte("key"))
+	h.Write([]byte("GCPauseSeconds:histogram:" + serverstorage.HistogramHashInput(server)))
+	if sent[0].Hash != hex.EncodeToString(h.Sum(nil)) {
+		t.Errorf("ConvertToMetricsJSON() hash differs from the one the server checks")
+	}
+
+	// Значения, собранные до подтверждения, отправляются со следующим списком.
+	ms.ObserveHistogram("GCPauseSeconds", bounds, 0.02)
+	ms.Acknowledge(sent)
+	next := ms.ConvertToMetricsJSON("")
+	if len(next) != 1 || !reflect.DeepEqual(next[0].Histogram.Counts, []int64{0, 0, 1}) || math.Abs(next[0].Histogram.Sum-0.02) > 1e-9 {
+		t.Fatalf("ConvertToMetricsJSON() = %v, want only the unacknowledged value", next)
+	}
+	ms.Acknowledge(next)
+	if got := ms.ConvertToMetricsJSON(""); len(got) != 0 {
+		t.Errorf("ConvertToMetricsJSON() = %v, want no histograms without new values", got)
+	}
+}
@@ -0,0 +1,294 @@
+// Package alerting реализует правила оповещения по значениям сохраненных метрик. Правила загружаются
+// из файла и периодически проверяются по хранилищу. Правило проверяется для каждого подходящего под него
+// ряда метрики отдельно, устаревшие ряды не проверяются. Оповещение ряда проходит состояния inactive,
+// pending, firing и resolved, о переходах в firing и resolved отправляется POST запрос на webhook.
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// Состояния оповещения.
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// DefaultInterval интервал проверки правил по умолчанию.
+const DefaultInterval = 10 * time.Second
+
+// Alert текущее состояние оповещения по правилу для одного ряда метрики. Если правилу не соответствует
+// ни один ряд, вместо оповещений рядов правило представлено одним оповещением без меток с NoData.
+type Alert struct {
+	Rule        string            `json:"rule"`
+	Expr        string            `json:"expr"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	NoData      bool              `json:"no_data,omitempty"`
+	State       string            `json:"state"`
+	Value       *float64          `json:"value,omitempty"`
+	ActiveSince *time.Time        `json:"active_since,omitempty"`
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+	EvaluatedAt time.Time         `json:"evaluated_at"`
+}
+
+// Notification тело запроса, отправляемого на webhook при срабатывании или разрешении оповещения.
+type Notification struct {
+	Rule   string            `json:"rule"`
+	Expr   string            `json:"expr"`
+	Labels map[string]string `json:"labels,omitempty"`
+	State  string            `json:"state"`
+	Value  *float64          `json:"value,omitempty"`
+	Time   time.Time         `json:"time"`
+}
+
+// Engine проверяет правила по хранилищу и хранит состояния оповещений по ключу из названия правила
+// и ключа ряда. Если Webhook пуст, уведомления только пишутся в лог.
+type Engine struct {
+	Storage storage.IStorage
+	Rules   []Rule
+	Webhook string
+	Client  *http.Client
+	alerts  map[string]*Alert
+	mutex   sync.RWMutex
+}
+
+// alertKey ключ оповещения правила rule для ряда series. Для пустого series это ключ оповещения правила,
+// которому не соответствует ни один ряд.
+func alertKey(rule, series string) string {
+	if series == "" {
+		return rule
+	}
+	return rule + "\x00" + series
+}
+
+// NewEngine функция-конструктор для Engine, до первой проверки у каждого правила одно оповещение
+// в состоянии inactive.
+func NewEngine(st storage.IStorage, rules []Rule, webhook string) *Engine {
+	e := &Engine{
+		Storage: st,
+		Rules:   rules,
+		Webhook: webhook,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+		alerts:  make(map[string]*Alert),
+	}
+	for _, rule := range rules {
+		e.alerts[rule.Name] = &Alert{Rule: rule.Name, Expr: rule.Expr, State: StateInactive}
+	}
+	return e
+}
+
+// Run проверяет правила с интервалом interval до ctx.Done.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.Evaluate(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Evaluate проверяет все правила на момент now, обновляет состояния оповещений и отправляет уведомления
+// о переходах в firing и resolved. Ряд, который пропал или устарел, считается не удовлетворяющим условию:
+// его оповещение разрешается и удаляется при следующей проверке. Правила, которым не соответствует ни один
+// ряд, пишутся в лог. Если хранилище недоступно, состояния не меняются.
+func (e *Engine) Evaluate(now time.Time) {
+	metrics, err := e.Storage.ReadAllMetrics()
+	if err != nil {
+		log.Println("Alert rules evaluation failed", err)
+		return
+	}
+	var notifications []Notification
+	e.mutex.Lock()
+	for i := range e.Rules {
+		rule := &e.Rules[i]
+		series := matchSeries(rule, metrics)
+		for key, s := range series {
+			alert, ok := e.alerts[alertKey(rule.Name, key)]
+			if !ok {
+				alert = &Alert{Rule: rule.Name, Expr: rule.Expr, Labels: s.labels, State: StateInactive}
+				e.alerts[alertKey(rule.Name, key)] = alert
+			}
+			value := s.value
+			if n, ok := alert.update(rule, &value, now); ok {
+				notifications = append(notifications, n)
+			}
+		}
+		for key, alert := range e.alerts {
+			if alert.Rule != rule.Name || key == rule.Name {
+				continue
+			}
+			if _, ok := series[key[len(rule.Name)+1:]]; ok {
+				continue
+			}
+			if alert.State == StateInactive || alert.State == StateResolved {
+				delete(e.alerts, key)
+				continue
+			}
+			if n, ok := alert.update(rule, nil, now); ok {
+				notifications = append(notifications, n)
+			}
+		}
+		e.updateNoData(rule, len(series) == 0, now)
+	}
+	e.mutex.Unlock()
+	for _, n := range notifications {
+		err := e.notify(n)
+		if err != nil {
+			log.Println("Alert notification failed", err)
+		}
+	}
+}
+
+// updateNoData ведет оповещение правила, которому не соответствует ни один ряд: создает его и пишет
+// в лог, когда ряды пропадают, и удаляет, когда они появляются. Вызывается под блокировкой mutex.
+func (e *Engine) updateNoData(rule *Rule, noData bool, now time.Time) {
+	alert, ok := e.alerts[rule.Name]
+	if !noData {
+		delete(e.alerts, rule.Name)
+		return
+	}
+	if !ok {
+		alert = &Alert{Rule: rule.Name, Expr: rule.Expr, State: StateInactive}
+		e.alerts[rule.Name] = alert
+	}
+	if !alert.NoData {
+		log.Printf("Alert rule %s matches no series", rule.Name)
+		alert.NoData = true
+	}
+	alert.EvaluatedAt = now
+}
+
+// update переводит оповещение в следующее состояние по значению ряда value на момент now. Отсутствующее
+// значение не удовлетворяет условию. Возвращает уведомление, если оповещение сработало или разрешилось.
+func (a *Alert) update(rule *Rule, value *float64, now time.Time) (Notification, bool) {
+	a.EvaluatedAt = now
+	a.Value = value
+	if value != nil && rule.Check(*value) {
+		switch a.State {
+		case StateInactive, StateResolved:
+			since := now
+			a.ActiveSince = &since
+			a.ResolvedAt = nil
+			a.State = StatePending
+		}
+		if a.State == StatePending && now.Sub(*a.ActiveSince) >= rule.For {
+			fired := now
+			a.FiredAt = &fired
+			a.State = StateFiring
+			return a.notification(now), true
+		}
+		return Notification{}, false
+	}
+	switch a.State {
+	case StatePending:
+		a.State = StateInactive
+		a.ActiveSince = nil
+	case StateFiring:
+		resolved := now
+		a.ResolvedAt = &resolved
+		a.ActiveSince = nil
+		a.State = StateResolved
+		return a.notification(now), true
+	}
+	return Notification{}, false
+}
+
+// Alerts возвращает копию состояний всех оповещений, упорядоченных по названию правила.
+func (e *Engine) Alerts() []Alert {
+	alerts := []Alert{}
+	if e == nil {
+		return alerts
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	for _, alert := range e.alerts {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule == alerts[j].Rule {
+			return storage.FormatLabels(alerts[i].Labels) < storage.FormatLabels(alerts[j].Labels)
+		}
+		return alerts[i].Rule < alerts[j].Rule
+	})
+	return alerts
+}
+
+// seriesValue значение ряда метрики, подходящего под правило, и его метки.
+type seriesValue struct {
+	labels map[string]string
+	value  float64
+}
+
+// matchSeries возвращает по ключу ряда значения всех не устаревших рядов метрики правила, метки которых
+// подходят под его селектор. Значение counter приводится к float64. Если тип в правиле не задан и ряд
+// с теми же метками есть и среди gauge, и среди counter, берется gauge.
+func matchSeries(rule *Rule, metrics []storage.Metrics) map[string]seriesValue {
+	series := make(map[string]seriesValue)
+	for _, m := range storage.FilterMetrics(metrics, rule.Labels) {
+		if m.ID != rule.Metric || m.Stale || (rule.Type != "" && m.MType != rule.Type) {
+			continue
+		}
+		var value float64
+		switch {
+		case m.MType == "gauge" && m.Value != nil:
+			value = *m.Value
+		case m.MType == "counter" && m.Delta != nil:
+			if _, ok := series[m.Key()]; ok {
+				continue
+			}
+			value = float64(*m.Delta)
+		default:
+			continue
+		}
+		series[m.Key()] = seriesValue{labels: m.Labels, value: value}
+	}
+	return series
+}
+
+// notification формирует уведомление о текущем состоянии оповещения.
+func (a *Alert) notification(now time.Time) Notification {
+	n := Notification{Rule: a.Rule, Expr: a.Expr, Labels: a.Labels, State: a.State, Time: now}
+	if a.Value != nil {
+		v := *a.Value
+		n.Value = &v
+	}
+	return n
+}
+
+// notify отправляет уведомление на webhook в формате json.
+func (e *Engine) notify(n Notification) error {
+	log.Printf("Alert %s is %s", n.Rule, n.State)
+	if e.Webhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := e.Client.Post(e.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
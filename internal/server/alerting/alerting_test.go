@@ -0,0 +1,149 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestEngine_Evaluate(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		received []Notification
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := Notification{}
+		err := json.NewDecoder(r.Body).Decode(&n)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		received = append(received, n)
+		mutex.Unlock()
+	}))
+	defer webhook.Close()
+
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{"CPUutilization1": 95},
+		CounterMetrics: map[string]int64{"PollCount": 3},
+	}
+	rules := []Rule{
+		{Name: "HighCPU", Expr: "CPUutilization1 > 90 for 5m"},
+		{Name: "Polls", Expr: "PollCount >= 3", Type: "counter"},
+		{Name: "Missing", Expr: "FreeMemory < 1e9"},
+	}
+	for i := range rules {
+		assert.Equal(t, rules[i].Parse(), nil)
+	}
+	e := NewEngine(st, rules, webhook.URL)
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	states := func() map[string]string {
+		result := make(map[string]string)
+		for _, alert := range e.Alerts() {
+			result[alert.Rule] = alert.State
+		}
+		return result
+	}
+
+	e.Evaluate(start)
+	assert.Equal(t, states(), map[string]string{"HighCPU": StatePending, "Polls": StateFiring, "Missing": StateInactive})
+
+	e.Evaluate(start.Add(5 * time.Minute))
+	assert.Equal(t, states()["HighCPU"], StateFiring)
+
+	st.GaugeMetrics["CPUutilization1"] = 50
+	e.Evaluate(start.Add(6 * time.Minute))
+	assert.Equal(t, states()["HighCPU"], StateResolved)
+
+	// Кратковременное превышение не должно приводить к срабатыванию.
+	st.GaugeMetrics["CPUutilization1"] = 99
+	e.Evaluate(start.Add(7 * time.Minute))
+	assert.Equal(t, states()["HighCPU"], StatePending)
+	st.GaugeMetrics["CPUutilization1"] = 10
+	e.Evaluate(start.Add(8 * time.Minute))
+	assert.Equal(t, states()["HighCPU"], StateInactive)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, len(received), 3)
+	assert.Equal(t, received[0].Rule, "Polls")
+	assert.Equal(t, received[0].State, StateFiring)
+	assert.Equal(t, received[1].Rule, "HighCPU")
+	assert.Equal(t, received[1].State, StateFiring)
+	assert.Equal(t, *received[1].Value, 95.0)
+	assert.Equal(t, received[2].Rule, "HighCPU")
+	assert.Equal(t, received[2].State, StateResolved)
+}
+
+func TestEngine_EvaluateSeries(t *testing.T) {
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	insert := func(host string, value float64) {
+		m := storage.Metrics{ID: "CPUutilization1", MType: "gauge", Labels: map[string]string{"host": host}, Value: &value}
+		assert.Equal(t, st.InsertMetric(&m), nil)
+	}
+	insert("a", 95)
+	insert("b", 10)
+	rules := []Rule{
+		{Name: "HighCPU", Expr: "CPUutilization1 > 90"},
+		{Name: "HighCPUb", Expr: "CPUutilization1 > 90", Labels: map[string]string{"host": "b"}},
+		{Name: "Missing", Expr: "CPUutilization1 > 90", Labels: map[string]string{"host": "c"}},
+	}
+	for i := range rules {
+		assert.Equal(t, rules[i].Parse(), nil)
+	}
+	e := NewEngine(st, rules, "")
+	states := func() map[string]string {
+		result := make(map[string]string)
+		for _, alert := range e.Alerts() {
+			key := alert.Rule + "/" + alert.Labels["host"]
+			result[key] = alert.State
+			assert.Equal(t, alert.NoData, alert.Rule == "Missing")
+		}
+		return result
+	}
+
+	start := time.Now()
+	e.Evaluate(start)
+	assert.Equal(t, states(), map[string]string{
+		"HighCPU/a": StateFiring, "HighCPU/b": StateInactive, "HighCPUb/b": StateInactive, "Missing/": StateInactive,
+	})
+
+	// Устаревший ряд больше не проверяется: его оповещение разрешается, а затем удаляется.
+	_, err := st.SweepStale(time.Now().Add(time.Second), false)
+	assert.Equal(t, err, nil)
+	insert("b", 99)
+	e.Evaluate(start.Add(time.Minute))
+	assert.Equal(t, states(), map[string]string{
+		"HighCPU/a": StateResolved, "HighCPU/b": StateFiring, "HighCPUb/b": StateFiring, "Missing/": StateInactive,
+	})
+	e.Evaluate(start.Add(2 * time.Minute))
+	assert.Equal(t, states(), map[string]string{
+		"HighCPU/b": StateFiring, "HighCPUb/b": StateFiring, "Missing/": StateInactive,
+	})
+}
+
+func TestEngine_notify(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+	e := NewEngine(nil, nil, webhook.URL)
+	assert.NotEqual(t, e.notify(Notification{Rule: "a", State: StateFiring}), nil)
+	e.Webhook = ""
+	assert.Equal(t, e.notify(Notification{Rule: "a", State: StateFiring}), nil)
+
+	var nilEngine *Engine
+	assert.Equal(t, len(nilEngine.Alerts()), 0)
+}
@@ -0,0 +1,119 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// Операторы сравнения, допустимые в правилах.
+var operators = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+var errExprFormat = errors.New("expression should look like: <metric> <op> <threshold> [for <duration>]")
+
+// Rule правило оповещения: условие на значение метрики и время, в течение которого оно должно
+// выполняться, прежде чем оповещение сработает. Labels ограничивает проверяемые ряды метрики теми,
+// у которых есть все указанные метки с теми же значениями, без него проверяются все ряды.
+type Rule struct {
+	Name      string            `json:"name"`
+	Expr      string            `json:"expr"`
+	Metric    string            `json:"-"`
+	Type      string            `json:"type,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Op        string            `json:"-"`
+	Threshold float64           `json:"-"`
+	For       time.Duration     `json:"-"`
+}
+
+// rulesFile формат файла с правилами.
+type rulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadRules читает правила из json файла вида {"rules": [{"name": "HighCPU", "expr": "CPUutilization1 > 90 for 5m"}]}.
+// Поле type ограничивает тип метрики, без него метрика ищется сначала среди gauge, затем среди counter.
+// Поле labels, например {"host": "web1"}, ограничивает проверяемые ряды метрики.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file rulesFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		err = rule.Parse()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name %q", i+1, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return file.Rules, nil
+}
+
+// Parse заполняет поля правила из выражения Expr. Если название правила не задано, им становится выражение.
+func (r *Rule) Parse() error {
+	fields := strings.Fields(r.Expr)
+	if len(fields) != 3 && len(fields) != 5 {
+		return errExprFormat
+	}
+	if _, ok := operators[fields[1]]; !ok {
+		return fmt.Errorf("unknown operator %q", fields[1])
+	}
+	threshold, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return fmt.Errorf("wrong threshold %q", fields[2])
+	}
+	var duration time.Duration
+	if len(fields) == 5 {
+		if fields[3] != "for" {
+			return errExprFormat
+		}
+		duration, err = time.ParseDuration(fields[4])
+		if err != nil || duration < 0 {
+			return fmt.Errorf("wrong duration %q", fields[4])
+		}
+	}
+	switch r.Type {
+	case "", "gauge", "counter":
+	default:
+		return fmt.Errorf("unknown metric type %q", r.Type)
+	}
+	err = storage.ValidateLabels(r.Labels)
+	if err != nil {
+		return err
+	}
+	r.Metric = fields[0]
+	r.Op = fields[1]
+	r.Threshold = threshold
+	r.For = duration
+	if r.Name == "" {
+		r.Name = r.Expr
+	}
+	return nil
+}
+
+// Check проверяет значение метрики на соответствие условию правила.
+func (r *Rule) Check(value float64) bool {
+	return operators[r.Op](value, r.Threshold)
+}
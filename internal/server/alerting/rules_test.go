@@ -0,0 +1,133 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestRule_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		want    Rule
+		wantErr bool
+	}{
+		{
+			name: "with duration",
+			rule: Rule{Name: "HighCPU", Expr: "CPUutilization1 > 90 for 5m"},
+			want: Rule{Name: "HighCPU", Expr: "CPUutilization1 > 90 for 5m", Metric: "CPUutilization1",
+				Op: ">", Threshold: 90, For: 5 * time.Minute},
+		},
+		{
+			name: "without duration and name",
+			rule: Rule{Expr: "FreeMemory < 1e9", Type: "gauge"},
+			want: Rule{Name: "FreeMemory < 1e9", Expr: "FreeMemory < 1e9", Metric: "FreeMemory", Type: "gauge",
+				Op: "<", Threshold: 1e9},
+		},
+		{
+			name:    "unknown operator",
+			rule:    Rule{Expr: "FreeMemory => 1"},
+			wantErr: true,
+		},
+		{
+			name:    "bad threshold",
+			rule:    Rule{Expr: "FreeMemory < many"},
+			wantErr: true,
+		},
+		{
+			name:    "bad for keyword",
+			rule:    Rule{Expr: "FreeMemory < 1 during 5m"},
+			wantErr: true,
+		},
+		{
+			name:    "bad duration",
+			rule:    Rule{Expr: "FreeMemory < 1 for soon"},
+			wantErr: true,
+		},
+		{
+			name:    "bad type",
+			rule:    Rule{Expr: "FreeMemory < 1", Type: "histogram"},
+			wantErr: true,
+		},
+		{
+			name: "with labels",
+			rule: Rule{Expr: "CPUutilization1 > 90", Labels: map[string]string{"host": "web1"}},
+			want: Rule{Name: "CPUutilization1 > 90", Expr: "CPUutilization1 > 90", Metric: "CPUutilization1",
+				Labels: map[string]string{"host": "web1"}, Op: ">", Threshold: 90},
+		},
+		{
+			name:    "bad label name",
+			rule:    Rule{Expr: "CPUutilization1 > 90", Labels: map[string]string{"host-name": "web1"}},
+			wantErr: true,
+		},
+		{
+			name:    "short expression",
+			rule:    Rule{Expr: "FreeMemory <"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Parse()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Rule.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "normal rules",
+			data: `{"rules": [{"name": "HighCPU", "expr": "CPUutilization1 > 90 for 5m"}, {"expr": "FreeMemory < 1e9"}]}`,
+			want: 2,
+		},
+		{
+			name:    "duplicate names",
+			data:    `{"rules": [{"name": "a", "expr": "A > 1"}, {"name": "a", "expr": "B > 1"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad rule",
+			data:    `{"rules": [{"expr": "A >"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad json",
+			data:    `{"rules": `,
+			wantErr: true,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+".json")
+			err := os.WriteFile(path, []byte(tt.data), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadRules(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadRules() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, len(got), tt.want)
+		})
+	}
+	_, err := LoadRules(filepath.Join(dir, "missing.json"))
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,149 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dsft54/rt-metrics/internal/proto"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// RealIPKey ключ метаданных, в котором агент или доверенный прокси передает ip адрес агента.
+const RealIPKey = "x-real-ip"
+
+// metricHash считает hmac sha256 подпись метрики в том же формате, что и http обработчики, включая метки.
+func metricHash(key string, m *pb.Metric) string {
+	h := hmac.New(sha256.New, []byte(key))
+	switch m.GetType() {
+	case "gauge":
+		h.Write([]byte(fmt.Sprintf("%s:gauge:%f", m.GetId(), m.GetValue()) + storage.LabelsHashSuffix(m.GetLabels())))
+	case "counter":
+		h.Write([]byte(fmt.Sprintf("%s:counter:%d", m.GetId(), m.GetDelta()) + storage.LabelsHashSuffix(m.GetLabels())))
+	case "histogram":
+		h.Write([]byte(fmt.Sprintf("%s:histogram:%s", m.GetId(),
+			storage.HistogramHashInput(histogramToStorage(m.GetHistogram()))) + storage.LabelsHashSuffix(m.GetLabels())))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashUnaryInterceptor проверяет подпись метрики в запросах Update и подписывает метрику в ответах
+// GetValue. При пустом ключе проверка не выполняется.
+func HashUnaryInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if key == "" {
+			return handler(ctx, req)
+		}
+		if r, ok := req.(*pb.UpdateRequest); ok {
+			if r.GetMetric().GetHash() != metricHash(key, r.GetMetric()) {
+				return nil, status.Error(codes.InvalidArgument, "wrong metric hash")
+			}
+		}
+		resp, err := handler(ctx, req)
+		if r, ok := resp.(*pb.GetValueResponse); ok && err == nil {
+			r.Metric.Hash = metricHash(key, r.Metric)
+		}
+		return resp, err
+	}
+}
+
+// hashStream обертка над потоком, которая пропускает метрики с неверной подписью.
+type hashStream struct {
+	grpc.ServerStream
+	key string
+}
+
+// RecvMsg получает следующее сообщение потока с верной подписью.
+func (s *hashStream) RecvMsg(m interface{}) error {
+	for {
+		err := s.ServerStream.RecvMsg(m)
+		if err != nil {
+			return err
+		}
+		metric, ok := m.(*pb.Metric)
+		if !ok || metric.GetHash() == metricHash(s.key, metric) {
+			return nil
+		}
+	}
+}
+
+// HashStreamInterceptor отбрасывает из потока UpdateBatch метрики с неверной подписью, так же как
+// http обработчик списка метрик. При пустом ключе проверка не выполняется.
+func HashStreamInterceptor(key string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if key == "" {
+			return handler(srv, ss)
+		}
+		return handler(srv, &hashStream{ServerStream: ss, key: key})
+	}
+}
+
+// realIP определяет ip адрес клиента по адресу соединения. Метаданные x-real-ip учитываются, только если
+// соединение установлено из подсети доверенных прокси proxy, иначе клиент мог бы подставить в них
+// любой адрес.
+func realIP(ctx context.Context, proxy *net.IPNet) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || proxy == nil || !proxy.Contains(ip) {
+		return ip
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RealIPKey); len(values) > 0 {
+			return net.ParseIP(values[0])
+		}
+	}
+	return ip
+}
+
+// checkSubnet возвращает ошибку PermissionDenied, если ip адрес клиента не входит в доверенную подсеть.
+func checkSubnet(ctx context.Context, subnet, proxy *net.IPNet) error {
+	if subnet == nil {
+		return nil
+	}
+	ip := realIP(ctx, proxy)
+	if ip == nil || !subnet.Contains(ip) {
+		return status.Error(codes.PermissionDenied, "client ip is not in trusted subnet")
+	}
+	return nil
+}
+
+// SubnetUnaryInterceptor отклоняет запросы клиентов не из доверенной подсети. Адрес клиента из метаданных
+// x-real-ip принимается только от прокси из подсети proxy. Если подсеть не задана, проверка не выполняется.
+func SubnetUnaryInterceptor(subnet, proxy *net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := checkSubnet(ctx, subnet, proxy)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// SubnetStreamInterceptor отклоняет потоковые запросы клиентов не из доверенной подсети. Адрес клиента
+// из метаданных x-real-ip принимается только от прокси из подсети proxy. Если подсеть не задана, проверка
+// не выполняется.
+func SubnetStreamInterceptor(subnet, proxy *net.IPNet) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := checkSubnet(ss.Context(), subnet, proxy)
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
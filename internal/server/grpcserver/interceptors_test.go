@@ -0,0 +1,194 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-playground/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dsft54/rt-metrics/internal/proto"
+)
+
+func TestHashInterceptors(t *testing.T) {
+	var (
+		v float64 = 3.14
+		d int64   = 3
+	)
+	key := "testkey"
+	st := newMemoryStorage()
+	client := startServer(t, st,
+		grpc.UnaryInterceptor(HashUnaryInterceptor(key)),
+		grpc.StreamInterceptor(HashStreamInterceptor(key)))
+
+	signed := &pb.Metric{Id: "Alloc", Type: "gauge", Value: &v}
+	signed.Hash = metricHash(key, signed)
+	_, err := client.Update(context.Background(), &pb.UpdateRequest{Metric: signed})
+	assert.Equal(t, status.Code(err), codes.OK)
+	_, err = client.Update(context.Background(), &pb.UpdateRequest{Metric: &pb.Metric{Id: "Alloc", Type: "gauge", Value: &v, Hash: "bad"}})
+	assert.Equal(t, status.Code(err), codes.InvalidArgument)
+
+	resp, err := client.GetValue(context.Background(), &pb.GetValueRequest{Id: "Alloc", Type: "gauge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp.GetMetric().GetHash(), signed.Hash)
+
+	labeled := &pb.Metric{Id: "Alloc", Type: "gauge", Value: &v, Labels: map[string]string{"host": "a"}}
+	labeled.Hash = metricHash(key, labeled)
+	assert.NotEqual(t, labeled.Hash, signed.Hash)
+	_, err = client.Update(context.Background(), &pb.UpdateRequest{Metric: labeled})
+	assert.Equal(t, status.Code(err), codes.OK)
+	_, err = client.Update(context.Background(), &pb.UpdateRequest{Metric: &pb.Metric{
+		Id: "Alloc", Type: "gauge", Value: &v, Labels: map[string]string{"host": "b"}, Hash: labeled.Hash}})
+	assert.Equal(t, status.Code(err), codes.InvalidArgument)
+
+	histogram := &pb.Metric{Id: "Latency", Type: "histogram",
+		Histogram: &pb.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5}}
+	histogram.Hash = metricHash(key, histogram)
+	_, err = client.Update(context.Background(), &pb.UpdateRequest{Metric: histogram})
+	assert.Equal(t, status.Code(err), codes.OK)
+	histogram.Histogram.Sum = 2
+	_, err = client.Update(context.Background(), &pb.UpdateRequest{Metric: histogram})
+	assert.Equal(t, status.Code(err), codes.InvalidArgument)
+
+	stream, err := client.UpdateBatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := &pb.Metric{Id: "PollCount", Type: "counter", Delta: &d}
+	good.Hash = metricHash(key, good)
+	bad := &pb.Metric{Id: "PollCount", Type: "counter", Delta: &d, Hash: "bad"}
+	for _, m := range []*pb.Metric{good, bad} {
+		err = stream.Send(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	batchResp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, batchResp.GetAccepted(), int64(1))
+	assert.Equal(t, st.CounterMetrics["PollCount"], d)
+}
+
+func TestSubnetInterceptors(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		subnet *net.IPNet
+		ip     string
+		code   codes.Code
+	}{
+		{
+			name:   "spoofed ip from untrusted peer",
+			subnet: subnet,
+			ip:     "192.168.1.10",
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "no ip",
+			subnet: subnet,
+			code:   codes.PermissionDenied,
+		},
+		{
+			name: "no subnet",
+			ip:   "10.0.0.1",
+			code: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := startServer(t, newMemoryStorage(),
+				grpc.UnaryInterceptor(SubnetUnaryInterceptor(tt.subnet, nil)),
+				grpc.StreamInterceptor(SubnetStreamInterceptor(tt.subnet, nil)))
+			ctx := context.Background()
+			if tt.ip != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, RealIPKey, tt.ip)
+			}
+			_, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+			assert.Equal(t, status.Code(err), tt.code)
+			stream, err := client.UpdateBatch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, status.Code(err), tt.code)
+		})
+	}
+}
+
+func Test_realIP(t *testing.T) {
+	_, proxy, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		peer  string
+		proxy *net.IPNet
+		md    string
+		want  net.IP
+	}{
+		{
+			name: "peer address",
+			peer: "192.168.1.10:5000",
+			want: net.ParseIP("192.168.1.10"),
+		},
+		{
+			name: "metadata without trusted proxy",
+			peer: "10.0.0.1:5000",
+			md:   "192.168.1.10",
+			want: net.ParseIP("10.0.0.1"),
+		},
+		{
+			name:  "metadata from trusted proxy",
+			peer:  "10.0.0.1:5000",
+			proxy: proxy,
+			md:    "192.168.1.10",
+			want:  net.ParseIP("192.168.1.10"),
+		},
+		{
+			name:  "metadata from untrusted peer",
+			peer:  "172.16.0.1:5000",
+			proxy: proxy,
+			md:    "192.168.1.10",
+			want:  net.ParseIP("172.16.0.1"),
+		},
+		{
+			name:  "trusted proxy without metadata",
+			peer:  "10.0.0.1:5000",
+			proxy: proxy,
+			want:  net.ParseIP("10.0.0.1"),
+		},
+		{
+			name: "no peer",
+			md:   "192.168.1.10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.peer != "" {
+				addr, err := net.ResolveTCPAddr("tcp", tt.peer)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+			}
+			if tt.md != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RealIPKey, tt.md))
+			}
+			assert.Equal(t, realIP(ctx, tt.proxy).String(), tt.want.String())
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Package grpcserver реализует gRPC сервис Metrics поверх того же хранилища IStorage, с которым
+// работают http обработчики, а также перехватчики для проверки хеша метрик и доверенной подсети.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dsft54/rt-metrics/internal/proto"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// MetricsServer реализация gRPC сервиса Metrics. Если требуется синхронная запись в файл,
+// она осуществляется через FileStorage.
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+	Storage     storage.IStorage
+	FileStorage *storage.FileStorage
+}
+
+// NewMetricsServer функция-конструктор для MetricsServer.
+func NewMetricsServer(st storage.IStorage, fs *storage.FileStorage) *MetricsServer {
+	return &MetricsServer{
+		Storage:     st,
+		FileStorage: fs,
+	}
+}
+
+// Update сохраняет одну метрику.
+func (s *MetricsServer) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	metric, err := ToStorage(req.GetMetric())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = s.Storage.InsertMetric(&metric)
+	if err != nil {
+		log.Println("Insert metric err", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = s.synchronize()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateResponse{}, nil
+}
+
+// UpdateBatch принимает поток метрик и после его закрытия сохраняет их одним списком.
+func (s *MetricsServer) UpdateBatch(stream pb.Metrics_UpdateBatchServer) error {
+	metricsBatch := []storage.Metrics{}
+	for {
+		m, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		metric, err := ToStorage(m)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		metricsBatch = append(metricsBatch, metric)
+	}
+	err := s.Storage.InsertBatchMetric(metricsBatch)
+	if err != nil {
+		log.Println("Error while update metrics from batch", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	err = s.synchronize()
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&pb.UpdateBatchResponse{Accepted: int64(len(metricsBatch))})
+}
+
+// GetValue возвращает текущее значение ряда метрики с указанными метками.
+func (s *MetricsServer) GetValue(ctx context.Context, req *pb.GetValueRequest) (*pb.GetValueResponse, error) {
+	metric, err := s.Storage.ReadMetric(&storage.Metrics{ID: req.GetId(), MType: req.GetType(), Labels: req.GetLabels()})
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &pb.GetValueResponse{Metric: FromStorage(metric)}, nil
+}
+
+// ListMetrics возвращает сохраненные метрики. Устаревшие ряды пропускаются, как и в http обработчиках,
+// если в запросе не задан include_stale.
+func (s *MetricsServer) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	metrics, err := s.Storage.ReadAllMetrics()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.ListMetricsResponse{}
+	for i := range metrics {
+		if metrics[i].Stale && !req.GetIncludeStale() {
+			continue
+		}
+		resp.Metrics = append(resp.Metrics, FromStorage(&metrics[i]))
+	}
+	return resp, nil
+}
+
+// synchronize сохраняет хранилище в файл, если включена синхронная запись.
+func (s *MetricsServer) synchronize() error {
+	if s.FileStorage == nil || !s.FileStorage.Synchronize {
+		return nil
+	}
+	err := s.FileStorage.SaveStorageToFile(s.Storage)
+	if err != nil {
+		log.Println("Synchronized data saving was failed", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// ToStorage преобразует gRPC сообщение в структуру Metrics, проверяя тип метрики, наличие значения,
+// названия меток и корректность гистограммы.
+func ToStorage(m *pb.Metric) (storage.Metrics, error) {
+	metric := storage.Metrics{
+		ID:     m.GetId(),
+		MType:  m.GetType(),
+		Hash:   m.GetHash(),
+		Labels: m.GetLabels(),
+	}
+	switch metric.MType {
+	case "gauge":
+		if m.Value == nil {
+			return metric, errors.New("gauge without value")
+		}
+		value := m.GetValue()
+		metric.Value = &value
+	case "counter":
+		if m.Delta == nil {
+			return metric, errors.New("counter without delta")
+		}
+		delta := m.GetDelta()
+		metric.Delta = &delta
+	case "histogram":
+		if m.Histogram == nil {
+			return metric, errors.New("histogram without value")
+		}
+		metric.Histogram = histogramToStorage(m.GetHistogram())
+	default:
+		return metric, errors.New("wrong metric type - " + metric.MType)
+	}
+	return metric, metric.Validate()
+}
+
+// FromStorage преобразует структуру Metrics в gRPC сообщение.
+func FromStorage(m *storage.Metrics) *pb.Metric {
+	metric := &pb.Metric{
+		Id:     m.ID,
+		Type:   m.MType,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Hash:   m.Hash,
+		Labels: m.Labels,
+		Stale:  m.Stale,
+	}
+	if m.Histogram != nil {
+		metric.Histogram = &pb.Histogram{
+			Bounds:    m.Histogram.Bounds,
+			Counts:    m.Histogram.Counts,
+			Sum:       m.Histogram.Sum,
+			Quantiles: m.Histogram.Quantiles,
+		}
+	}
+	return metric
+}
+
+// histogramToStorage преобразует гистограмму из gRPC сообщения. Оценки квантилей не переносятся,
+// сервер считает их сам при чтении.
+func histogramToStorage(h *pb.Histogram) *storage.Histogram {
+	return &storage.Histogram{
+		Bounds: h.GetBounds(),
+		Counts: h.GetCounts(),
+		Sum:    h.GetSum(),
+	}
+}
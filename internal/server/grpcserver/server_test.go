@@ -0,0 +1,249 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/dsft54/rt-metrics/internal/proto"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// startServer поднимает сервис на bufconn и возвращает клиента к нему.
+func startServer(t *testing.T, st storage.IStorage, opts ...grpc.ServerOption) pb.MetricsClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(server, NewMetricsServer(st, &storage.FileStorage{}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+func newMemoryStorage() *storage.MemoryStorage {
+	return &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+}
+
+func TestMetricsServer_Update(t *testing.T) {
+	var (
+		v float64 = 3.14
+		d int64   = 3
+	)
+	tests := []struct {
+		name   string
+		metric *pb.Metric
+		code   codes.Code
+	}{
+		{
+			name:   "gauge",
+			metric: &pb.Metric{Id: "Alloc", Type: "gauge", Value: &v},
+			code:   codes.OK,
+		},
+		{
+			name:   "counter",
+			metric: &pb.Metric{Id: "PollCount", Type: "counter", Delta: &d},
+			code:   codes.OK,
+		},
+		{
+			name:   "gauge without value",
+			metric: &pb.Metric{Id: "Alloc", Type: "gauge"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "wrong type",
+			metric: &pb.Metric{Id: "Alloc", Type: "summary", Value: &v},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "gauge with labels",
+			metric: &pb.Metric{Id: "Alloc", Type: "gauge", Value: &v, Labels: map[string]string{"host": "a"}},
+			code:   codes.OK,
+		},
+		{
+			name: "histogram",
+			metric: &pb.Metric{Id: "Latency", Type: "histogram",
+				Histogram: &pb.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5}},
+			code: codes.OK,
+		},
+		{
+			name:   "histogram without value",
+			metric: &pb.Metric{Id: "Latency", Type: "histogram"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name: "histogram counts mismatch",
+			metric: &pb.Metric{Id: "Latency", Type: "histogram",
+				Histogram: &pb.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2}}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:   "invalid label name",
+			metric: &pb.Metric{Id: "Alloc", Type: "gauge", Value: &v, Labels: map[string]string{"1host": "a"}},
+			code:   codes.InvalidArgument,
+		},
+	}
+	client := startServer(t, newMemoryStorage())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Update(context.Background(), &pb.UpdateRequest{Metric: tt.metric})
+			assert.Equal(t, status.Code(err), tt.code)
+		})
+	}
+}
+
+func TestMetricsServer_UpdateBatch(t *testing.T) {
+	var (
+		v float64 = 3.14
+		d int64   = 3
+	)
+	st := newMemoryStorage()
+	client := startServer(t, st)
+	stream, err := client.UpdateBatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*pb.Metric{
+		{Id: "Alloc", Type: "gauge", Value: &v},
+		{Id: "PollCount", Type: "counter", Delta: &d},
+		{Id: "PollCount", Type: "counter", Delta: &d},
+	} {
+		err = stream.Send(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp.GetAccepted(), int64(3))
+	assert.Equal(t, st.GaugeMetrics["Alloc"], v)
+	assert.Equal(t, st.CounterMetrics["PollCount"], int64(6))
+}
+
+func TestMetricsServer_GetValue(t *testing.T) {
+	st := newMemoryStorage()
+	st.GaugeMetrics["Alloc"] = 3.14
+	client := startServer(t, st)
+	resp, err := client.GetValue(context.Background(), &pb.GetValueRequest{Id: "Alloc", Type: "gauge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp.GetMetric().GetValue(), 3.14)
+	_, err = client.GetValue(context.Background(), &pb.GetValueRequest{Id: "Heap", Type: "gauge"})
+	assert.Equal(t, status.Code(err), codes.NotFound)
+}
+
+func TestMetricsServer_Histogram(t *testing.T) {
+	st := newMemoryStorage()
+	client := startServer(t, st)
+	for i := 0; i < 2; i++ {
+		_, err := client.Update(context.Background(), &pb.UpdateRequest{Metric: &pb.Metric{Id: "Latency", Type: "histogram",
+			Histogram: &pb.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := client.GetValue(context.Background(), &pb.GetValueRequest{Id: "Latency", Type: "histogram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := resp.GetMetric().GetHistogram()
+	assert.Equal(t, h.GetCounts(), []int64{2, 4, 0})
+	assert.Equal(t, h.GetSum(), 3.0)
+	assert.NotEqual(t, len(h.GetQuantiles()), 0)
+}
+
+func TestMetricsServer_Labels(t *testing.T) {
+	var (
+		a float64 = 1
+		b float64 = 2
+	)
+	st := newMemoryStorage()
+	client := startServer(t, st)
+	for _, m := range []*pb.Metric{
+		{Id: "Alloc", Type: "gauge", Value: &a, Labels: map[string]string{"host": "a"}},
+		{Id: "Alloc", Type: "gauge", Value: &b, Labels: map[string]string{"host": "b"}},
+	} {
+		_, err := client.Update(context.Background(), &pb.UpdateRequest{Metric: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := client.GetValue(context.Background(),
+		&pb.GetValueRequest{Id: "Alloc", Type: "gauge", Labels: map[string]string{"host": "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp.GetMetric().GetValue(), b)
+	assert.Equal(t, resp.GetMetric().GetLabels(), map[string]string{"host": "b"})
+	_, err = client.GetValue(context.Background(), &pb.GetValueRequest{Id: "Alloc", Type: "gauge"})
+	assert.Equal(t, status.Code(err), codes.NotFound)
+
+	list, err := client.ListMetrics(context.Background(), &pb.ListMetricsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := map[string]float64{}
+	for _, m := range list.GetMetrics() {
+		hosts[m.GetLabels()["host"]] = m.GetValue()
+	}
+	assert.Equal(t, hosts, map[string]float64{"a": a, "b": b})
+}
+
+func TestMetricsServer_ListMetrics(t *testing.T) {
+	var (
+		v float64 = 3.14
+		d int64   = 3
+	)
+	st := newMemoryStorage()
+	assert.Equal(t, st.InsertMetric(&storage.Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+	assert.Equal(t, st.InsertMetric(&storage.Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+	_, err := st.SweepStale(time.Now().Add(time.Second), false)
+	assert.Equal(t, err, nil)
+	// Обновление снимает с ряда признак устаревания.
+	assert.Equal(t, st.InsertMetric(&storage.Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+	client := startServer(t, st)
+
+	tests := []struct {
+		name      string
+		req       *pb.ListMetricsRequest
+		wantStale map[string]bool
+	}{
+		{name: "fresh only", req: &pb.ListMetricsRequest{}, wantStale: map[string]bool{"PollCount": false}},
+		{name: "include stale", req: &pb.ListMetricsRequest{IncludeStale: true},
+			wantStale: map[string]bool{"Alloc": true, "PollCount": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.ListMetrics(context.Background(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stale := map[string]bool{}
+			for _, m := range resp.GetMetrics() {
+				stale[m.GetId()] = m.GetStale()
+			}
+			assert.Equal(t, stale, tt.wantStale)
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dsft54/rt-metrics/internal/server/alerting"
+)
+
+// Alerts возвращает текущие состояния оповещений в формате json. Предназначен для обработки GET запроса
+// на /alerts. Если правила оповещения не настроены, возвращается пустой список.
+func Alerts(e *alerting.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, e.Alerts())
+	}
+}
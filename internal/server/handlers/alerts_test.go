@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/alerting"
+)
+
+func TestAlerts(t *testing.T) {
+	rule := alerting.Rule{Name: "HighAlloc", Expr: "Alloc >= 0"}
+	assert.Equal(t, rule.Parse(), nil)
+	engine := alerting.NewEngine(&mockStorage{}, []alerting.Rule{rule}, "")
+	engine.Evaluate(time.Now())
+	tests := []struct {
+		name   string
+		engine *alerting.Engine
+		want   []alerting.Alert
+	}{
+		{
+			name: "No rules",
+			want: []alerting.Alert{},
+		},
+		{
+			name:   "Firing rule",
+			engine: engine,
+			want:   engine.Alerts(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.GET("/alerts", Alerts(tt.engine))
+			req, _ := http.NewRequest("GET", "/alerts", nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, http.StatusOK)
+			got := []alerting.Alert{}
+			assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &got), nil)
+			assert.Equal(t, len(got), len(tt.want))
+			for i := range got {
+				assert.Equal(t, got[i].Rule, tt.want[i].Rule)
+				assert.Equal(t, got[i].State, tt.want[i].State)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+// dashboardTemplate шаблон страницы со списком метрик, встроенный в бинарный файл.
+var dashboardTemplate = template.Must(template.ParseFS(templatesFS, "templates/dashboard.html"))
+
+// dashboardRow строка таблицы метрик на странице с уже отформатированными значениями.
+type dashboardRow struct {
+	Name    string
+	Type    string
+	Value   string
+	Updated string
+	Stale   bool
+}
+
+// RequestAllMetrics возвращает значения всех сохраненных метрик. Предназначен для обработки GET запроса
+// на /. По умолчанию отдается html страница с таблицей метрик, которую можно сортировать и фильтровать.
+// Если клиент передает заголовок Accept: application/json, метрики возвращаются списком в json формате.
+// Параметр labels вида name=value,name=value оставляет только метрики с этими метками. Устаревшие метрики
+// показываются только с параметром include_stale=1.
+func RequestAllMetrics(st storage.IStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		selector, ok := labelsQuery(c)
+		if !ok {
+			return
+		}
+		metrics, err := st.ReadAllMetrics()
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		metrics = storage.FilterMetrics(withoutStale(c, metrics), selector)
+		if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+			c.JSON(http.StatusOK, metrics)
+			return
+		}
+		var buf bytes.Buffer
+		err = dashboardTemplate.Execute(&buf, dashboardRows(metrics))
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+	}
+}
+
+// dashboardRows готовит строки таблицы, упорядоченные по названию и типу метрики. Метрики с метками
+// называются ключом ряда, например CPUutilization1{host="a"}.
+func dashboardRows(metrics []storage.Metrics) []dashboardRow {
+	rows := make([]dashboardRow, 0, len(metrics))
+	for _, metric := range metrics {
+		row := dashboardRow{Name: metric.Key(), Type: metric.MType, Stale: metric.Stale}
+		switch {
+		case metric.Value != nil:
+			row.Value = strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+		case metric.Delta != nil:
+			row.Value = strconv.FormatInt(*metric.Delta, 10)
+		case metric.Histogram != nil:
+			row.Value = formatHistogram(metric.Histogram)
+		}
+		if metric.Updated != nil {
+			row.Updated = metric.Updated.Format(time.RFC3339)
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Name == rows[j].Name {
+			return rows[i].Type < rows[j].Type
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return rows
+}
+
+// formatHistogram кратко описывает гистограмму для таблицы: количество значений и оценки квантилей,
+// например "count=5 p0.5=0.003 p0.9=0.01".
+func formatHistogram(h *storage.Histogram) string {
+	quantiles := make([]string, 0, len(h.Quantiles))
+	for q := range h.Quantiles {
+		quantiles = append(quantiles, q)
+	}
+	sort.Slice(quantiles, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(quantiles[i], 64)
+		b, _ := strconv.ParseFloat(quantiles[j], 64)
+		return a < b
+	})
+	value := "count=" + strconv.FormatInt(h.Count(), 10)
+	for _, q := range quantiles {
+		value += " p" + q + "=" + strconv.FormatFloat(h.Quantiles[q], 'g', -1, 64)
+	}
+	return value
+}
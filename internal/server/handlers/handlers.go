@@ -8,16 +8,21 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	
+	"github.com/dsft54/rt-metrics/internal/server/rollup"
 	"github.com/dsft54/rt-metrics/internal/server/storage"
+	"github.com/dsft54/rt-metrics/internal/server/stream"
 )
 
 // ParametersUpdate используется для обработки POST запроса для обновления/записи
 // метрики с использованием параметров в url запроса в формате "/update/:type/:name/:value".
 // Если требуется синхронная запись в файл, она осуществляется через метод FileStorage.
-func ParametersUpdate(st storage.IStorage, fs *storage.FileStorage) gin.HandlerFunc {
+// Обновленная метрика рассылается подписчикам Broker.
+func ParametersUpdate(st storage.IStorage, fs *storage.FileStorage, b *stream.Broker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var code int
 		mType := c.Param("type")
@@ -35,19 +40,38 @@ func ParametersUpdate(st storage.IStorage, fs *storage.FileStorage) gin.HandlerF
 				return
 			}
 		}
+		if code == 200 {
+			publishUpdates(b, st, storage.Metrics{ID: mName, MType: mType})
+		}
 		c.Status(code)
 	}
 }
 
 // AddressedRequest используется для обработки GET запроса на получение одной метрики,
 // тип и название которой будет получено из параметров url запроса вида "/value/:type/:name".
+// Метки ряда передаются параметром labels вида name=value,name=value. Для histogram возвращается оценка
+// квантиля из параметра q, по умолчанию медиана.
 func AddressedRequest(st storage.IStorage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		rType := c.Param("type")
 		rID := c.Param("name")
+		labels, ok := labelsQuery(c)
+		if !ok {
+			return
+		}
+		q := 0.5
+		if rType == "histogram" && c.Query("q") != "" {
+			var err error
+			q, err = strconv.ParseFloat(c.Query("q"), 64)
+			if err != nil || q < 0 || q > 1 {
+				c.String(http.StatusBadRequest, "bad q param: %v", c.Query("q"))
+				return
+			}
+		}
 		metricsRequest := storage.Metrics{
-			ID:    rID,
-			MType: rType,
+			ID:     rID,
+			MType:  rType,
+			Labels: labels,
 		}
 		metricsResponse, err := st.ReadMetric(&metricsRequest)
 		if err != nil {
@@ -62,6 +86,10 @@ func AddressedRequest(st storage.IStorage) gin.HandlerFunc {
 			c.String(http.StatusOK, "%v", *metricsResponse.Value)
 			return
 		}
+		if rType == "histogram" {
+			c.String(http.StatusOK, "%v", metricsResponse.Histogram.Quantile(q))
+			return
+		}
 	}
 }
 
@@ -91,10 +119,17 @@ func RequestMetricJSON(st storage.IStorage, key string) gin.HandlerFunc {
 			h := hmac.New(sha256.New, []byte(key))
 			switch metricsResponse.MType {
 			case "gauge":
-				h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metricsResponse.ID, *metricsResponse.Value)))
+				h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metricsResponse.ID, *metricsResponse.Value) +
+					storage.LabelsHashSuffix(metricsResponse.Labels)))
 				metricsResponse.Hash = hex.EncodeToString(h.Sum(nil))
 			case "counter":
-				h.Write([]byte(fmt.Sprintf("%s:counter:%d", metricsResponse.ID, *metricsResponse.Delta)))
+				h.Write([]byte(fmt.Sprintf("%s:counter:%d", metricsResponse.ID, *metricsResponse.Delta) +
+					storage.LabelsHashSuffix(metricsResponse.Labels)))
+				metricsResponse.Hash = hex.EncodeToString(h.Sum(nil))
+			case "histogram":
+				h.Write([]byte(fmt.Sprintf("%s:histogram:%s", metricsResponse.ID,
+					storage.HistogramHashInput(metricsResponse.Histogram)) +
+					storage.LabelsHashSuffix(metricsResponse.Labels)))
 				metricsResponse.Hash = hex.EncodeToString(h.Sum(nil))
 			}
 		}
@@ -105,9 +140,10 @@ func RequestMetricJSON(st storage.IStorage, key string) gin.HandlerFunc {
 // UpdateMetricJSON используется для обработки POST запроса на обновление/запись одной метрики,
 // где тип, название и значение метрики передается в теле запроса в формате json по url /update/.
 // В случае если при запуске сервера был указан ключ, считается хеш полученной метрики и сравнивается
-// с тем, который был получен от агента. При неравенстве хешей такой запрос отбрасывается.
-// При необходимости запись дублируется в файл.
-func UpdateMetricJSON(st storage.IStorage, fs *storage.FileStorage, key string) gin.HandlerFunc {
+// с тем, который был получен от агента. При неравенстве хешей такой запрос отбрасывается, как и метрика
+// с недопустимыми названиями меток или некорректной гистограммой. При необходимости запись дублируется в файл, обновленная метрика
+// рассылается подписчикам Broker.
+func UpdateMetricJSON(st storage.IStorage, fs *storage.FileStorage, b *stream.Broker, key string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		rawData, err := c.GetRawData()
 		if err != nil {
@@ -122,13 +158,24 @@ func UpdateMetricJSON(st storage.IStorage, fs *storage.FileStorage, key string)
 			c.Status(http.StatusInternalServerError)
 			return
 		}
+		err = metricsRequest.Validate()
+		if err != nil {
+			c.String(http.StatusBadRequest, "%v", err)
+			return
+		}
 		if key != "" {
 			h := hmac.New(sha256.New, []byte(key))
 			switch metricsRequest.MType {
 			case "gauge":
-				h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metricsRequest.ID, *metricsRequest.Value)))
+				h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metricsRequest.ID, *metricsRequest.Value) +
+					storage.LabelsHashSuffix(metricsRequest.Labels)))
 			case "counter":
-				h.Write([]byte(fmt.Sprintf("%s:counter:%d", metricsRequest.ID, *metricsRequest.Delta)))
+				h.Write([]byte(fmt.Sprintf("%s:counter:%d", metricsRequest.ID, *metricsRequest.Delta) +
+					storage.LabelsHashSuffix(metricsRequest.Labels)))
+			case "histogram":
+				h.Write([]byte(fmt.Sprintf("%s:histogram:%s", metricsRequest.ID,
+					storage.HistogramHashInput(metricsRequest.Histogram)) +
+					storage.LabelsHashSuffix(metricsRequest.Labels)))
 			default:
 				c.Status(http.StatusInternalServerError)
 				return
@@ -152,6 +199,7 @@ func UpdateMetricJSON(st storage.IStorage, fs *storage.FileStorage, key string)
 				return
 			}
 		}
+		publishUpdates(b, st, *metricsRequest)
 		c.Status(http.StatusOK)
 	}
 }
@@ -169,27 +217,10 @@ func PingDatabase(st storage.IStorage) gin.HandlerFunc {
 	}
 }
 
-// RequestAllMetrics возвращает значения всех сохраненных метрик в json формате. Предназначен для обработки GET запроса
-// на /.
-func RequestAllMetrics(st storage.IStorage) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		metrics, err := st.ReadAllMetrics()
-		if err != nil {
-			c.Status(http.StatusInternalServerError)
-			return
-		}
-		data, err := json.Marshal(metrics)
-		if err != nil {
-			c.Status(http.StatusInternalServerError)
-			return
-		}
-		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
-	}
-}
-
 // BatchUpdateJSON предназначен для обновления списка метрик полученных в теле POST запроса
 // в формате json. Также проверяется хеш при наличии ключа. При необходимости запись дублируется в файл.
-func BatchUpdateJSON(st storage.IStorage, fs *storage.FileStorage, key string) gin.HandlerFunc {
+// Обновленные метрики рассылаются подписчикам Broker.
+func BatchUpdateJSON(st storage.IStorage, fs *storage.FileStorage, b *stream.Broker, key string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		rawData, err := c.GetRawData()
 		if err != nil {
@@ -204,15 +235,27 @@ func BatchUpdateJSON(st storage.IStorage, fs *storage.FileStorage, key string) g
 			c.Status(http.StatusInternalServerError)
 			return
 		}
+		for _, metric := range metricsBatch {
+			err = metric.Validate()
+			if err != nil {
+				c.String(http.StatusBadRequest, "%v", err)
+				return
+			}
+		}
 		metricsBatchClean := []storage.Metrics{}
 		if key != "" {
 			for _, metric := range metricsBatch {
 				h := hmac.New(sha256.New, []byte(key))
 				switch metric.MType {
 				case "gauge":
-					h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metric.ID, *metric.Value)))
+					h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metric.ID, *metric.Value) +
+						storage.LabelsHashSuffix(metric.Labels)))
 				case "counter":
-					h.Write([]byte(fmt.Sprintf("%s:counter:%d", metric.ID, *metric.Delta)))
+					h.Write([]byte(fmt.Sprintf("%s:counter:%d", metric.ID, *metric.Delta) +
+						storage.LabelsHashSuffix(metric.Labels)))
+				case "histogram":
+					h.Write([]byte(fmt.Sprintf("%s:histogram:%s", metric.ID, storage.HistogramHashInput(metric.Histogram)) +
+						storage.LabelsHashSuffix(metric.Labels)))
 				}
 				if metric.Hash != hex.EncodeToString(h.Sum(nil)) {
 					continue
@@ -235,10 +278,204 @@ func BatchUpdateJSON(st storage.IStorage, fs *storage.FileStorage, key string) g
 				return
 			}
 		}
+		publishUpdates(b, st, metricsBatch...)
+		c.Status(http.StatusOK)
+	}
+}
+
+// DeleteMetric используется для обработки DELETE запроса на удаление ряда метрики вместе с его историей
+// по url "/value/:type/:name". Метки ряда передаются параметром labels. Если ряда нет, отвечает 404.
+// При необходимости удаление дублируется в файл.
+func DeleteMetric(st storage.IStorage, fs *storage.FileStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		labels, ok := labelsQuery(c)
+		if !ok {
+			return
+		}
+		metric := storage.Metrics{ID: c.Param("name"), MType: c.Param("type"), Labels: labels}
+		deleted, err := st.DeleteMetrics([]storage.Metrics{metric})
+		if err != nil {
+			log.Println("Delete metric err", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if fs.Synchronize {
+			err = fs.SaveStorageToFile(st)
+			if err != nil {
+				log.Println("Synchronized data saving was failed", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+		}
+		c.Status(http.StatusOK)
+	}
+}
+
+// BatchDeleteJSON предназначен для удаления списка рядов метрик, полученных в теле POST запроса в формате
+// json по url /delete/. Ряд определяется типом, названием и метками, значения не учитываются. Отвечает
+// количеством удаленных рядов в виде {"deleted": n}. При необходимости удаление дублируется в файл.
+func BatchDeleteJSON(st storage.IStorage, fs *storage.FileStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawData, err := c.GetRawData()
+		if err != nil {
+			log.Println(err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		metricsBatch := []storage.Metrics{}
+		err = json.Unmarshal(rawData, &metricsBatch)
+		if err != nil {
+			log.Println(err)
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		deleted, err := st.DeleteMetrics(metricsBatch)
+		if err != nil {
+			log.Println("Error while delete metrics from batch", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if deleted > 0 && fs.Synchronize {
+			err = fs.SaveStorageToFile(st)
+			if err != nil {
+				log.Println("Synchronized data saving was failed", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+	}
+}
+
+// ResetCounter используется для обработки POST запроса на обнуление counter по url "/reset/counter/:name".
+// Метки ряда передаются параметром labels. Если ряда нет, отвечает 404. При необходимости запись
+// дублируется в файл, обнуленная метрика рассылается подписчикам Broker.
+func ResetCounter(st storage.IStorage, fs *storage.FileStorage, b *stream.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		labels, ok := labelsQuery(c)
+		if !ok {
+			return
+		}
+		metric := storage.Metrics{ID: c.Param("name"), MType: "counter", Labels: labels}
+		found, err := st.ResetCounter(&metric)
+		if err != nil {
+			log.Println("Reset counter err", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if fs.Synchronize {
+			err = fs.SaveStorageToFile(st)
+			if err != nil {
+				log.Println("Synchronized data saving was failed", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+		}
+		publishUpdates(b, st, metric)
 		c.Status(http.StatusOK)
 	}
 }
 
+// RequestHistory используется для обработки GET запроса на получение истории значений метрики по url
+// "/history/:type/:name?from=&to=&step=&labels=". Границы интервала from и to принимаются в формате RFC3339 или
+// unix time в секундах, по умолчанию возвращается последний час. Шаг прореживания step задается в формате
+// time.Duration, без него возвращаются все точки. Метки ряда передаются параметром labels. Если from старше
+// глубины хранения истории retention и включены агрегаты r, ответ строится из агрегатов подходящего разрешения:
+// у gauge в точке последнее значение интервала, у counter - накопленное значение на его конец. Выбранное
+// разрешение передается в заголовке X-History-Resolution.
+func RequestHistory(st storage.IStorage, r *rollup.Store, retention time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		labels, ok := labelsQuery(c)
+		if !ok {
+			return
+		}
+		now := time.Now()
+		to, err := parseTimeParam(c.Query("to"), now)
+		if err != nil {
+			c.String(http.StatusBadRequest, "bad to param: %v", err)
+			return
+		}
+		from, err := parseTimeParam(c.Query("from"), to.Add(-time.Hour))
+		if err != nil {
+			c.String(http.StatusBadRequest, "bad from param: %v", err)
+			return
+		}
+		var step time.Duration
+		if c.Query("step") != "" {
+			step, err = time.ParseDuration(c.Query("step"))
+			if err != nil || step < 0 {
+				c.String(http.StatusBadRequest, "bad step param: %v", c.Query("step"))
+				return
+			}
+		}
+		key := storage.SeriesKey(c.Param("name"), labels)
+		if r != nil && retention > 0 && from.Before(now.Add(-retention)) {
+			res := r.Resolution(from, to, step, now)
+			points, err := r.Query(c.Param("type"), key, res, from, to, step)
+			if err != nil {
+				log.Println("Read rollups err", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			c.Header("X-History-Resolution", rollupNames[res])
+			c.JSON(http.StatusOK, rollupSamples(points))
+			return
+		}
+		samples, err := st.ReadHistory(c.Param("type"), key, from, to, step)
+		if err != nil {
+			log.Println("Read history err", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, samples)
+	}
+}
+
+// labelsQuery разбирает метки из параметра запроса labels вида name=value,name=value. Если параметр
+// задан неверно, отвечает 400 и возвращает false.
+func labelsQuery(c *gin.Context) (map[string]string, bool) {
+	labels, err := storage.ParseLabels(c.Query("labels"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad labels param: %v", err)
+		return nil, false
+	}
+	return labels, true
+}
+
+// withoutStale убирает из списка устаревшие метрики, если в запросе не передан параметр include_stale=1.
+func withoutStale(c *gin.Context, metrics []storage.Metrics) []storage.Metrics {
+	if c.Query("include_stale") == "1" {
+		return metrics
+	}
+	fresh := make([]storage.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if !m.Stale {
+			fresh = append(fresh, m)
+		}
+	}
+	return fresh
+}
+
+// parseTimeParam разбирает значение параметра запроса в формате RFC3339 или unix time в секундах.
+// Для пустой строки возвращает значение по умолчанию def.
+func parseTimeParam(param string, def time.Time) (time.Time, error) {
+	if param == "" {
+		return def, nil
+	}
+	if unix, err := strconv.ParseInt(param, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, param)
+}
+
 // WithoutID возвращает ошибку 404 при попытке сделать POST запрос на "/update/counter/" и "/update/value/"
 // т.е. без указания названия искомой метрики.
 func WithoutID(c *gin.Context) {
@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +13,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/assert"
@@ -64,6 +70,30 @@ func (ms *mockStorage) InsertBatchMetric(m []storage.Metrics) error {
 	return nil
 }
 
+func (ms *mockStorage) DeleteMetrics(m []storage.Metrics) (int, error) {
+	deleted := 0
+	for _, metric := range m {
+		if metric.ID == "ERROR" {
+			return 0, errNotFound
+		}
+		if _, err := ms.ReadMetric(&metric); err == nil {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (ms *mockStorage) ResetCounter(m *storage.Metrics) (bool, error) {
+	if m.ID == "ERROR" {
+		return false, errNotFound
+	}
+	return m.MType == "counter" && m.ID == "Pollcount", nil
+}
+
+func (ms *mockStorage) SweepStale(before time.Time, evict bool) ([]storage.Metrics, error) {
+	return nil, nil
+}
+
 func (ms *mockStorage) ReadMetric(mr *storage.Metrics) (*storage.Metrics, error) {
 	var (
 		value float64
@@ -102,6 +132,14 @@ func (ms *mockStorage) ReadAllMetrics() ([]storage.Metrics, error) {
 	return mmSlice, nil
 }
 
+func (ms *mockStorage) ReadHistory(mType, id string, from, to time.Time, step time.Duration) ([]storage.Sample, error) {
+	var value float64
+	if mType != "gauge" || id != "Alloc" {
+		return nil, errNotFound
+	}
+	return []storage.Sample{{Time: to, Value: &value}}, nil
+}
+
 func (ms *mockStorage) SaveToFile(f *os.File) error {
 	_, err := f.Write([]byte("mock_test"))
 	if err != nil {
@@ -181,7 +219,7 @@ func TestParametersUpdate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
 			_, r := gin.CreateTestContext(w)
-			r.POST("/update/:type/:name/:value", ParametersUpdate(tt.st, tt.fs))
+			r.POST("/update/:type/:name/:value", ParametersUpdate(tt.st, tt.fs, nil))
 			req, _ := http.NewRequest("POST", tt.url, nil)
 			r.ServeHTTP(w, req)
 			if tt.fs.Synchronize && tt.fs.FilePath != "" {
@@ -193,13 +231,19 @@ func TestParametersUpdate(t *testing.T) {
 				if err != nil {
 					t.Error(err, tt)
 				}
-				assert.Equal(t, string(data), "mock_test")
+				assert.Equal(t, string(data), snapshotOf("mock_test"))
 			}
 			assert.Equal(t, tt.code, w.Code)
 		})
 	}
 }
 
+// snapshotOf возвращает содержимое файла снимка с заголовком контрольной суммы для тела body.
+func snapshotOf(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return "RTMETRICS sha256=" + hex.EncodeToString(sum[:]) + "\n" + body
+}
+
 func TestAddressedRequest(t *testing.T) {
 	tests := []struct {
 		name string
@@ -535,7 +579,7 @@ func TestUpdateMetricJSON(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
 			_, r := gin.CreateTestContext(w)
-			r.POST("/update/", UpdateMetricJSON(tt.st, tt.fs, tt.key))
+			r.POST("/update/", UpdateMetricJSON(tt.st, tt.fs, nil, tt.key))
 			breq, err := json.Marshal(tt.request)
 			if err != nil {
 				t.Error(err)
@@ -562,7 +606,7 @@ func TestUpdateMetricJSON(t *testing.T) {
 					if err != nil {
 						t.Error(err, tt)
 					}
-					assert.Equal(t, string(data), "mock_test")
+					assert.Equal(t, string(data), snapshotOf("mock_test"))
 				}
 			}
 		})
@@ -600,14 +644,31 @@ func TestPingDatabase(t *testing.T) {
 
 func TestRequestAllMetrics(t *testing.T) {
 	tests := []struct {
-		st   storage.IStorage
-		name string
-		code int
+		st          storage.IStorage
+		name        string
+		accept      string
+		code        int
+		contentType string
 	}{
 		{
-			name: "Normal working",
-			st:   &mockStorage{},
-			code: 200,
+			name:        "Normal working",
+			st:          &mockStorage{},
+			code:        200,
+			contentType: "text/html; charset=utf-8",
+		},
+		{
+			name:        "Browser accept",
+			st:          &mockStorage{},
+			accept:      "text/html,application/xhtml+xml,*/*;q=0.8",
+			code:        200,
+			contentType: "text/html; charset=utf-8",
+		},
+		{
+			name:        "Json accept",
+			st:          &mockStorage{},
+			accept:      "application/json",
+			code:        200,
+			contentType: "application/json; charset=utf-8",
 		},
 		{
 			name: "normal err",
@@ -621,15 +682,42 @@ func TestRequestAllMetrics(t *testing.T) {
 			_, r := gin.CreateTestContext(w)
 			r.GET("/", RequestAllMetrics(tt.st))
 			req, _ := http.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
 			r.ServeHTTP(w, req)
 			assert.Equal(t, tt.code, w.Code)
-			if tt.name != "normal err" {
-				assert.NotEqual(t, w.Body.Bytes(), nil)
+			if tt.code != 200 {
+				return
 			}
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			if tt.contentType == gin.MIMEJSON+"; charset=utf-8" {
+				metrics := []storage.Metrics{}
+				assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &metrics), nil)
+				assert.Equal(t, len(metrics), 2)
+				return
+			}
+			assert.Equal(t, strings.Contains(w.Body.String(), "<td>Alloc</td><td>gauge</td>"), true)
+			assert.Equal(t, strings.Contains(w.Body.String(), "<td>Counter</td><td>counter</td>"), true)
 		})
 	}
 }
 
+func Test_dashboardRows(t *testing.T) {
+	v := 1.5
+	d := int64(7)
+	updated := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	metrics := []storage.Metrics{
+		{ID: "b", MType: "counter", Delta: &d},
+		{ID: "a", MType: "gauge", Value: &v, Updated: &updated},
+	}
+	want := []dashboardRow{
+		{Name: "a", Type: "gauge", Value: "1.5", Updated: "2022-08-01T12:00:00Z"},
+		{Name: "b", Type: "counter", Value: "7"},
+	}
+	assert.Equal(t, dashboardRows(metrics), want)
+}
+
 func TestBatchUpdateJSON(t *testing.T) {
 	var v float64
 	var d int64
@@ -756,7 +844,7 @@ func TestBatchUpdateJSON(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
 			_, r := gin.CreateTestContext(w)
-			r.POST("/updates/", BatchUpdateJSON(tt.st, tt.fs, tt.key))
+			r.POST("/updates/", BatchUpdateJSON(tt.st, tt.fs, nil, tt.key))
 			breq, err := json.Marshal(tt.request)
 			if err != nil {
 				t.Error(err)
@@ -783,8 +871,425 @@ func TestBatchUpdateJSON(t *testing.T) {
 					if err != nil {
 						t.Error(err, tt)
 					}
-					assert.Equal(t, string(data), "mock_test")
+					assert.Equal(t, string(data), snapshotOf("mock_test"))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBatchUpdateJSON сравнивает хранилища в настройках сервера по умолчанию: история глубиной сутки
+// включена, у сегментированного хранилища DefaultShards сегментов. Каждый агент отправляет свои ряды.
+func BenchmarkBatchUpdateJSON(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+	storages := []struct {
+		name string
+		st   storage.IStorage
+	}{
+		{name: "memory", st: &storage.MemoryStorage{
+			GaugeMetrics:   map[string]float64{},
+			CounterMetrics: map[string]int64{},
+			History:        storage.NewHistory(24 * time.Hour),
+		}},
+		{name: "sharded", st: storage.NewShardedStorage(storage.DefaultShards, storage.NewHistory(24*time.Hour))},
+	}
+	for _, bs := range storages {
+		b.Run(bs.name, func(b *testing.B) {
+			r := gin.New()
+			r.POST("/updates/", BatchUpdateJSON(bs.st, &storage.FileStorage{}, nil, ""))
+			var agents int32
+			// Сотни агентов отправляют метрики одновременно.
+			b.SetParallelism(64)
+			b.RunParallel(func(pb *testing.PB) {
+				labels := map[string]string{"agent": strconv.Itoa(int(atomic.AddInt32(&agents, 1)))}
+				batch := make([]storage.Metrics, 0, 30)
+				for i := 0; i < 29; i++ {
+					v := float64(i)
+					batch = append(batch, storage.Metrics{ID: fmt.Sprintf("Gauge%d", i), MType: "gauge", Labels: labels, Value: &v})
+				}
+				d := int64(1)
+				batch = append(batch, storage.Metrics{ID: "PollCount", MType: "counter", Labels: labels, Delta: &d})
+				body, err := json.Marshal(batch)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				for pb.Next() {
+					w := httptest.NewRecorder()
+					req, _ := http.NewRequest("POST", "/updates/", bytes.NewReader(body))
+					r.ServeHTTP(w, req)
+					if w.Code != http.StatusOK {
+						b.Errorf("unexpected status %d", w.Code)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	key := "secret"
+	sign := func(m storage.Metrics, withLabels bool) storage.Metrics {
+		h := hmac.New(sha256.New, []byte(key))
+		data := fmt.Sprintf("%s:gauge:%f", m.ID, *m.Value)
+		if withLabels {
+			data += storage.LabelsHashSuffix(m.Labels)
+		}
+		h.Write([]byte(data))
+		m.Hash = hex.EncodeToString(h.Sum(nil))
+		return m
+	}
+	va, vb := 1.5, 2.5
+	hostA := map[string]string{"host": "a"}
+	hostB := map[string]string{"host": "b"}
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+		History:        storage.NewHistory(time.Hour),
+	}
+	r := gin.New()
+	r.POST("/updates/", BatchUpdateJSON(st, &storage.FileStorage{}, nil, key))
+	r.GET("/value/:type/:name", AddressedRequest(st))
+	r.GET("/history/:type/:name", RequestHistory(st, nil, 0))
+	r.GET("/", RequestAllMetrics(st))
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     []storage.Metrics
+		code     int
+		wantBody string
+	}{
+		{
+			name:   "labeled batch",
+			method: "POST",
+			url:    "/updates/",
+			body: []storage.Metrics{
+				sign(storage.Metrics{ID: "CPUutilization1", MType: "gauge", Labels: hostA, Value: &va}, true),
+				sign(storage.Metrics{ID: "CPUutilization1", MType: "gauge", Labels: hostB, Value: &vb}, true),
+				// Хеш без меток не подходит для метрики с метками, метрика отбрасывается.
+				sign(storage.Metrics{ID: "Unsigned", MType: "gauge", Labels: hostA, Value: &va}, false),
+			},
+			code: 200,
+		},
+		{
+			name:   "bad label name",
+			method: "POST",
+			url:    "/updates/",
+			body: []storage.Metrics{
+				sign(storage.Metrics{ID: "CPUutilization1", MType: "gauge",
+					Labels: map[string]string{"host-name": "a"}, Value: &va}, true),
+			},
+			code: 400,
+		},
+		{name: "value host a", method: "GET", url: "/value/gauge/CPUutilization1?labels=host=a", code: 200, wantBody: "1.5"},
+		{name: "value host b", method: "GET", url: "/value/gauge/CPUutilization1?labels=host=b", code: 200, wantBody: "2.5"},
+		{name: "value without labels", method: "GET", url: "/value/gauge/CPUutilization1", code: 404},
+		{name: "value dropped", method: "GET", url: "/value/gauge/Unsigned?labels=host=a", code: 404},
+		{name: "value bad labels", method: "GET", url: "/value/gauge/CPUutilization1?labels=host", code: 400},
+		{name: "history", method: "GET", url: "/history/gauge/CPUutilization1?labels=host=b", code: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.url, bytes.NewBuffer(body))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.code)
+			if tt.wantBody != "" {
+				assert.Equal(t, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/?labels=host=b", nil)
+	req.Header.Set("Accept", "application/json")
+	r.ServeHTTP(w, req)
+	listed := []storage.Metrics{}
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &listed), nil)
+	assert.Equal(t, len(listed), 1)
+	assert.Equal(t, listed[0].Labels, hostB)
+}
+
+func TestHistogram(t *testing.T) {
+	key := "secret"
+	sign := func(m storage.Metrics) storage.Metrics {
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write([]byte(fmt.Sprintf("%s:histogram:%s", m.ID, storage.HistogramHashInput(m.Histogram)) +
+			storage.LabelsHashSuffix(m.Labels)))
+		m.Hash = hex.EncodeToString(h.Sum(nil))
+		return m
+	}
+	bounds := []float64{1, 2, 4}
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	r := gin.New()
+	r.POST("/update/", UpdateMetricJSON(st, &storage.FileStorage{}, nil, key))
+	r.POST("/updates/", BatchUpdateJSON(st, &storage.FileStorage{}, nil, key))
+	r.GET("/value/:type/:name", AddressedRequest(st))
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     interface{}
+		code     int
+		wantBody string
+	}{
+		{
+			name:   "single",
+			method: "POST",
+			url:    "/update/",
+			body: sign(storage.Metrics{ID: "GCPauseSeconds", MType: "histogram",
+				Histogram: &storage.Histogram{Bounds: bounds, Counts: []int64{2, 2, 0, 0}, Sum: 3}}),
+			code: 200,
+		},
+		{
+			name:   "batch",
+			method: "POST",
+			url:    "/updates/",
+			body: []storage.Metrics{sign(storage.Metrics{ID: "GCPauseSeconds", MType: "histogram",
+				Histogram: &storage.Histogram{Bounds: bounds, Counts: []int64{0, 0, 4, 2}, Sum: 20}})},
+			code: 200,
+		},
+		{
+			name:   "bad hash",
+			method: "POST",
+			url:    "/update/",
+			body: storage.Metrics{ID: "GCPauseSeconds", MType: "histogram", Hash: "bad",
+				Histogram: &storage.Histogram{Bounds: bounds, Counts: []int64{1, 0, 0, 0}, Sum: 1}},
+			code: 400,
+		},
+		{
+			name:   "no histogram",
+			method: "POST",
+			url:    "/update/",
+			body:   storage.Metrics{ID: "GCPauseSeconds", MType: "histogram"},
+			code:   400,
+		},
+		{
+			name:   "missing +Inf bucket",
+			method: "POST",
+			url:    "/updates/",
+			body: []storage.Metrics{sign(storage.Metrics{ID: "GCPauseSeconds", MType: "histogram",
+				Histogram: &storage.Histogram{Bounds: bounds, Counts: []int64{1, 0, 0}, Sum: 1}})},
+			code: 400,
+		},
+		{name: "median", method: "GET", url: "/value/histogram/GCPauseSeconds", code: 200, wantBody: "2.5"},
+		{name: "quantile", method: "GET", url: "/value/histogram/GCPauseSeconds?q=0.1", code: 200, wantBody: "0.5"},
+		{name: "bad quantile", method: "GET", url: "/value/histogram/GCPauseSeconds?q=2", code: 400},
+		{name: "not found", method: "GET", url: "/value/histogram/Alloc", code: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.url, bytes.NewBuffer(body))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.code)
+			if tt.wantBody != "" {
+				assert.Equal(t, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	metrics, err := st.ReadAllMetrics()
+	assert.Equal(t, err, nil)
+	rows := dashboardRows(metrics)
+	assert.Equal(t, len(rows), 1)
+	assert.Equal(t, rows[0].Value, "count=10 p0.5=2.5 p0.9=4 p0.99=4")
+}
+
+func TestDeleteAndReset(t *testing.T) {
+	v, d := 1.5, int64(3)
+	hostA := map[string]string{"host": "a"}
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	assert.Equal(t, st.InsertBatchMetric([]storage.Metrics{
+		{ID: "CPUutilization7", MType: "gauge", Labels: hostA, Value: &v},
+		{ID: "CPUutilization8", MType: "gauge", Value: &v},
+		{ID: "CPUutilization9", MType: "gauge", Value: &v},
+		{ID: "PollCount", MType: "counter", Delta: &d},
+	}), nil)
+	r := gin.New()
+	r.DELETE("/value/:type/:name", DeleteMetric(st, &storage.FileStorage{}))
+	r.POST("/delete/", BatchDeleteJSON(st, &storage.FileStorage{}))
+	r.POST("/reset/counter/:name", ResetCounter(st, &storage.FileStorage{}, nil))
+	r.GET("/value/:type/:name", AddressedRequest(st))
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		code     int
+		wantBody string
+	}{
+		{name: "delete without labels", method: "DELETE", url: "/value/gauge/CPUutilization7", code: 404},
+		{name: "delete", method: "DELETE", url: "/value/gauge/CPUutilization7?labels=host=a", code: 200},
+		{name: "deleted", method: "GET", url: "/value/gauge/CPUutilization7?labels=host=a", code: 404},
+		{name: "delete bad labels", method: "DELETE", url: "/value/gauge/CPUutilization7?labels=host", code: 400},
+		{
+			name:     "batch delete",
+			method:   "POST",
+			url:      "/delete/",
+			body:     `[{"id":"CPUutilization8","type":"gauge"},{"id":"CPUutilization9","type":"gauge"},{"id":"Missing","type":"gauge"}]`,
+			code:     200,
+			wantBody: `{"deleted":2}`,
+		},
+		{name: "batch delete bad json", method: "POST", url: "/delete/", body: `{`, code: 400},
+		{name: "reset", method: "POST", url: "/reset/counter/PollCount", code: 200},
+		{name: "reset value", method: "GET", url: "/value/counter/PollCount", code: 200, wantBody: "0"},
+		{name: "reset missing", method: "POST", url: "/reset/counter/Missing", code: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.code)
+			if tt.wantBody != "" {
+				assert.Equal(t, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+	assert.Equal(t, len(st.GaugeMetrics), 0)
+}
+
+func TestDeleteMetric_Errors(t *testing.T) {
+	r := gin.New()
+	r.DELETE("/value/:type/:name", DeleteMetric(&mockStorage{}, &storage.FileStorage{}))
+	r.POST("/reset/counter/:name", ResetCounter(&mockStorage{}, &storage.FileStorage{}, nil))
+	for _, tt := range []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{method: "DELETE", url: "/value/gauge/Alloc", code: 200},
+		{method: "DELETE", url: "/value/gauge/ERROR", code: 500},
+		{method: "POST", url: "/reset/counter/Pollcount", code: 200},
+		{method: "POST", url: "/reset/counter/ERROR", code: 500},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, tt.code)
+	}
+}
+
+func TestIncludeStale(t *testing.T) {
+	v := 1.5
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	assert.Equal(t, st.InsertMetric(&storage.Metrics{ID: "Decommissioned", MType: "gauge", Value: &v}), nil)
+	_, err := st.SweepStale(time.Now().Add(time.Second), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, st.InsertMetric(&storage.Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+	r := gin.New()
+	r.GET("/", RequestAllMetrics(st))
+	r.GET("/metrics", PrometheusMetrics(st))
+
+	tests := []struct {
+		name    string
+		url     string
+		accept  string
+		want    []string
+		wantNot []string
+	}{
+		{name: "json", url: "/", accept: "application/json", want: []string{`"id":"Alloc"`},
+			wantNot: []string{"Decommissioned"}},
+		{name: "json include stale", url: "/?include_stale=1", accept: "application/json",
+			want: []string{`"id":"Alloc"`, `"id":"Decommissioned"`, `"stale":true`}},
+		{name: "html", url: "/", want: []string{"Alloc"}, wantNot: []string{"Decommissioned"}},
+		{name: "html include stale", url: "/?include_stale=1", want: []string{"Decommissioned", "(stale)"}},
+		{name: "prometheus", url: "/metrics", want: []string{"Alloc 1.5"}, wantNot: []string{"Decommissioned"}},
+		{name: "prometheus include stale", url: "/metrics?include_stale=1", want: []string{"Decommissioned 1.5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, http.StatusOK)
+			for _, s := range tt.want {
+				assert.Equal(t, strings.Contains(w.Body.String(), s), true)
+			}
+			for _, s := range tt.wantNot {
+				assert.Equal(t, strings.Contains(w.Body.String(), s), false)
+			}
+		})
+	}
+}
+
+func TestRequestHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		code int
+	}{
+		{
+			name: "Default interval",
+			url:  "/history/gauge/Alloc",
+			code: 200,
+		},
+		{
+			name: "Full params",
+			url:  "/history/gauge/Alloc?from=2022-08-01T00:00:00Z&to=1659312000&step=1m",
+			code: 200,
+		},
+		{
+			name: "Bad from",
+			url:  "/history/gauge/Alloc?from=yesterday",
+			code: 400,
+		},
+		{
+			name: "Bad to",
+			url:  "/history/gauge/Alloc?to=tomorrow",
+			code: 400,
+		},
+		{
+			name: "Bad step",
+			url:  "/history/gauge/Alloc?step=-1m",
+			code: 400,
+		},
+		{
+			name: "Storage err",
+			url:  "/history/counter/Alloc",
+			code: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.GET("/history/:type/:name", RequestHistory(&mockStorage{}, nil, 0))
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == 200 {
+				samples := []storage.Sample{}
+				err := json.Unmarshal(w.Body.Bytes(), &samples)
+				if err != nil {
+					t.Error(err)
 				}
+				assert.Equal(t, len(samples), 1)
 			}
 		})
 	}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dsft54/rt-metrics/internal/server/persister"
+)
+
+// Health возвращает состояние сервера в формате json. Предназначен для обработки GET запроса на /health.
+// Если периодическое сохранение в файл включено, в ответ добавляется его состояние, а при ошибке последнего
+// сохранения сервер отвечает 503.
+func Health(p *persister.Persister) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p == nil {
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			return
+		}
+		status := p.Status()
+		if !status.Healthy() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded", "persistence": status})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "persistence": status})
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/persister"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestHealth(t *testing.T) {
+	newPersister := func(path string) *persister.Persister {
+		st := &storage.MemoryStorage{GaugeMetrics: map[string]float64{}, CounterMetrics: map[string]int64{}}
+		p := persister.NewPersister(&storage.FileStorage{FilePath: path, StoreData: true}, st, time.Minute)
+		p.MarkDirty()
+		p.Flush()
+		return p
+	}
+	tests := []struct {
+		name       string
+		p          *persister.Persister
+		wantCode   int
+		wantStatus string
+	}{
+		{
+			name:       "Persistence disabled",
+			wantCode:   http.StatusOK,
+			wantStatus: "ok",
+		},
+		{
+			name:       "Last flush succeeded",
+			p:          newPersister(filepath.Join(t.TempDir(), "metrics.json")),
+			wantCode:   http.StatusOK,
+			wantStatus: "ok",
+		},
+		{
+			name:       "Last flush failed",
+			p:          newPersister(filepath.Join(t.TempDir(), "missing", "metrics.json")),
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "degraded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.GET("/health", Health(tt.p))
+			req, _ := http.NewRequest("GET", "/health", nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.wantCode)
+			got := struct {
+				Status      string            `json:"status"`
+				Persistence *persister.Status `json:"persistence"`
+			}{}
+			assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &got), nil)
+			assert.Equal(t, got.Status, tt.wantStatus)
+			assert.Equal(t, got.Persistence != nil, tt.p != nil)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dsft54/rt-metrics/internal/server/lineproto"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// LineProtocolWrite принимает POST запросы на /write с телом в формате InfluxDB line protocol.
+// Каждое поле строки сохраняется отдельной метрикой с названием measurement_field и тегами строки в качестве
+// меток: целые поля (суффиксы i и u) - как counter, дробные и булевы - как gauge, строковые поля
+// пропускаются. Строки с недопустимыми для меток ключами тегов считаются ошибочными. Корректные строки
+// сохраняются даже при наличии ошибок в остальных, ошибки возвращаются списком с номерами строк
+// и кодом 400. При необходимости запись дублируется в файл.
+func LineProtocolWrite(st storage.IStorage, fs *storage.FileStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawData, err := c.GetRawData()
+		if err != nil {
+			log.Println(err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		points, lineErrors := lineproto.Parse(string(rawData))
+		metricsBatch := []storage.Metrics{}
+		for _, point := range points {
+			metrics, err := pointToMetrics(point)
+			if err != nil {
+				lineErrors = append(lineErrors, lineproto.LineError{Line: point.Line, Err: err.Error()})
+				continue
+			}
+			metricsBatch = append(metricsBatch, metrics...)
+		}
+		err = st.InsertBatchMetric(metricsBatch)
+		if err != nil {
+			log.Println("Error while update metrics from line protocol", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if fs.Synchronize {
+			err = fs.SaveStorageToFile(st)
+			if err != nil {
+				log.Println("Synchronized data saving was failed", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+		}
+		if len(lineErrors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": lineErrors})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// pointToMetrics преобразует разобранную строку line protocol в список метрик, теги строки становятся
+// метками каждой из них. Возвращает ошибку, если ключ тега не подходит для названия метки.
+func pointToMetrics(point lineproto.Point) ([]storage.Metrics, error) {
+	var labels map[string]string
+	if len(point.Tags) > 0 {
+		labels = make(map[string]string, len(point.Tags))
+		for _, tag := range point.Tags {
+			labels[tag.Key] = tag.Value
+		}
+		err := storage.ValidateLabels(labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metrics := []storage.Metrics{}
+	for _, field := range point.Fields {
+		id := point.Measurement + "_" + field.Key
+		switch field.Type {
+		case lineproto.FieldInteger, lineproto.FieldUnsigned:
+			delta := field.Integer
+			metrics = append(metrics, storage.Metrics{ID: id, MType: "counter", Labels: labels, Delta: &delta})
+		case lineproto.FieldFloat, lineproto.FieldBoolean:
+			value := field.Float
+			metrics = append(metrics, storage.Metrics{ID: id, MType: "gauge", Labels: labels, Value: &value})
+		}
+	}
+	return metrics, nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/lineproto"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestLineProtocolWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		fs         *storage.FileStorage
+		body       string
+		code       int
+		wantErrors int
+	}{
+		{
+			name: "Normal write",
+			fs:   &storage.FileStorage{},
+			body: "cpu,host=a usage_idle=98.5,procs=12i 1659312000000000000\nmem free=1024u",
+			code: 204,
+		},
+		{
+			name:       "Partial write",
+			fs:         &storage.FileStorage{},
+			body:       "cpu usage_idle=98.5\ncpu usage_idle=\nmem",
+			code:       400,
+			wantErrors: 2,
+		},
+		{
+			name: "Sync err",
+			fs: &storage.FileStorage{
+				FilePath:    "",
+				Synchronize: true,
+			},
+			body: "cpu usage_idle=98.5",
+			code: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.POST("/write", LineProtocolWrite(&mockStorage{}, tt.fs))
+			req, _ := http.NewRequest("POST", "/write", bytes.NewBufferString(tt.body))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+			if tt.wantErrors > 0 {
+				resp := struct {
+					Errors []lineproto.LineError `json:"errors"`
+				}{}
+				err := json.Unmarshal(w.Body.Bytes(), &resp)
+				if err != nil {
+					t.Error(err)
+				}
+				assert.Equal(t, len(resp.Errors), tt.wantErrors)
+			}
+		})
+	}
+}
+
+func Test_pointToMetrics(t *testing.T) {
+	point := lineproto.Point{
+		Measurement: "cpu",
+		Fields: []lineproto.Field{
+			{Key: "usage", Type: lineproto.FieldFloat, Float: 1.5},
+			{Key: "procs", Type: lineproto.FieldInteger, Integer: 3},
+			{Key: "note", Type: lineproto.FieldString, String: "skip"},
+		},
+	}
+	metrics, err := pointToMetrics(point)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(metrics), 2)
+	assert.Equal(t, metrics[0].ID, "cpu_usage")
+	assert.Equal(t, metrics[0].MType, "gauge")
+	assert.Equal(t, *metrics[0].Value, 1.5)
+	assert.Equal(t, metrics[1].ID, "cpu_procs")
+	assert.Equal(t, metrics[1].MType, "counter")
+	assert.Equal(t, *metrics[1].Delta, int64(3))
+}
+
+func TestLineProtocolWriteTags(t *testing.T) {
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	w := httptest.NewRecorder()
+	_, r := gin.CreateTestContext(w)
+	r.POST("/write", LineProtocolWrite(st, &storage.FileStorage{}))
+	body := "cpu,host=a usage=1.5,procs=3i\ncpu,host=b usage=2.5,procs=4i\ncpu,host=a procs=1i\ncpu,host.name=c usage=1"
+	req, _ := http.NewRequest("POST", "/write", bytes.NewBufferString(body))
+	r.ServeHTTP(w, req)
+	// Строка с недопустимым ключом тега отклоняется, остальные сохраняются отдельными рядами.
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	assert.Equal(t, st.GaugeMetrics, map[string]float64{`cpu_usage{host="a"}`: 1.5, `cpu_usage{host="b"}`: 2.5})
+	assert.Equal(t, st.CounterMetrics, map[string]int64{`cpu_procs{host="a"}`: 4, `cpu_procs{host="b"}`: 4})
+}
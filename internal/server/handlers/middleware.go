@@ -24,10 +24,20 @@ func (gz gzipBodyWriter) Write(b []byte) (int, error) {
 	return gz.writer.Write(b)
 }
 
+// Flush сбрасывает в ответ накопленные сжатые данные, что нужно для потоковых ответов.
+func (gz gzipBodyWriter) Flush() {
+	if f, ok := gz.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	gz.ResponseWriter.Flush()
+}
+
 // Compression middleware - сжимает тело запроса/ответа и передает дальше по цепочке обработчиков.
+// Запросы на смену протокола, например WebSocket, не сжимаются.
 func Compression(gzipSpeed int) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !strings.Contains(c.Request.Header.Get("Accept-Encoding"), "gzip") {
+		if !strings.Contains(c.Request.Header.Get("Accept-Encoding"), "gzip") ||
+			c.Request.Header.Get("Upgrade") != "" {
 			c.Next()
 			return
 		}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dsft54/rt-metrics/internal/server/remotewrite"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// prometheusContentType тип содержимого текстового формата экспозиции Prometheus.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusMetrics возвращает значения всех сохраненных метрик в текстовом формате экспозиции Prometheus.
+// Предназначен для обработки GET запроса на /metrics. Метрики gauge и counter отдаются с соответствующими
+// строками # TYPE, названия приводятся к допустимому в Prometheus виду. Устаревшие метрики отдаются только
+// с параметром include_stale=1.
+func PrometheusMetrics(st storage.IStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		metrics, err := st.ReadAllMetrics()
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, prometheusContentType, exposePrometheus(withoutStale(c, metrics)))
+	}
+}
+
+// RemoteWrite принимает POST запросы Prometheus remote_write на /api/v1/write: сжатое snappy сообщение
+// protobuf WriteRequest. Ряд сохраняется с названием из метки __name__ и остальными метками ряда. Тип берется
+// из метаданных, которые Prometheus присылает не в каждом запросе, поэтому они запоминаются между запросами.
+// Ряд семейства COUNTER сохраняется как counter, остальные - как gauge.
+//
+// Из точек ряда в запросе используется только самая поздняя. Для gauge промежуточные значения теряются.
+// Для counter это не важно, так как Prometheus передает накопленное значение: в хранилище добавляется его
+// прирост относительно сохраненного, а уменьшение считается сбросом счетчика в источнике, и тогда приростом
+// считается все новое значение. Дробная часть значения counter округляется.
+//
+// Ряды без названия пропускаются, ряд с недопустимым названием метки отклоняет весь запрос с кодом 400.
+// При необходимости запись дублируется в файл.
+func RemoteWrite(st storage.IStorage, fs *storage.FileStorage) gin.HandlerFunc {
+	types := &remoteWriteTypes{types: make(map[string]int)}
+	return func(c *gin.Context) {
+		rawData, err := c.GetRawData()
+		if err != nil {
+			log.Println(err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		wr, err := remotewrite.Decode(rawData)
+		if err != nil {
+			log.Println(err)
+			c.String(http.StatusBadRequest, "%v", err)
+			return
+		}
+		types.update(wr.Metadata)
+		metricsBatch := []storage.Metrics{}
+		for _, ts := range wr.Timeseries {
+			name := ts.Name()
+			sample, ok := ts.Latest()
+			if name == "" || !ok {
+				continue
+			}
+			metric := storage.Metrics{
+				ID:     name,
+				MType:  "gauge",
+				Labels: ts.SeriesLabels(),
+			}
+			err = metric.Validate()
+			if err != nil {
+				c.String(http.StatusBadRequest, "%v", err)
+				return
+			}
+			if types.get(name) == remotewrite.MetricTypeCounter {
+				delta := counterIncrease(st, &metric, sample.Value)
+				metric.MType = "counter"
+				metric.Delta = &delta
+			} else {
+				value := sample.Value
+				metric.Value = &value
+			}
+			metricsBatch = append(metricsBatch, metric)
+		}
+		err = st.InsertBatchMetric(metricsBatch)
+		if err != nil {
+			log.Println("Error while update metrics from remote write", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if fs.Synchronize {
+			err = fs.SaveStorageToFile(st)
+			if err != nil {
+				log.Println("Synchronized data saving was failed", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// remoteWriteTypes типы метрик из метаданных remote_write по названию семейства.
+type remoteWriteTypes struct {
+	types map[string]int
+	mutex sync.RWMutex
+}
+
+// update запоминает типы из метаданных запроса.
+func (t *remoteWriteTypes) update(metadata []remotewrite.MetricMetadata) {
+	if len(metadata) == 0 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, md := range metadata {
+		t.types[md.MetricFamilyName] = md.Type
+	}
+}
+
+// get возвращает тип семейства name или MetricTypeUnknown, если метаданных для него не было.
+func (t *remoteWriteTypes) get(name string) int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.types[name]
+}
+
+// counterIncrease возвращает прирост counter ряда metric до накопленного значения value относительно
+// сохраненного в хранилище. Если value меньше сохраненного, счетчик в источнике был сброшен и приростом
+// считается все value.
+func counterIncrease(st storage.IStorage, metric *storage.Metrics, value float64) int64 {
+	total := int64(math.Round(value))
+	current, err := st.ReadMetric(&storage.Metrics{ID: metric.ID, MType: "counter", Labels: metric.Labels})
+	if err != nil || current == nil || current.Delta == nil || total < *current.Delta {
+		return total
+	}
+	return total - *current.Delta
+}
+
+// exposePrometheus собирает список метрик в текстовый формат экспозиции Prometheus. Метрики сортируются
+// по названию и меткам, ряды одной метрики с разными метками идут под общей строкой # TYPE. При совпадении
+// названий после приведения остается первая из метрик. Гистограмма выводится накопленными корзинами
+// _bucket с меткой le, суммой _sum и количеством значений _count.
+func exposePrometheus(metrics []storage.Metrics) []byte {
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID == metrics[j].ID {
+			return storage.FormatLabels(metrics[i].Labels) < storage.FormatLabels(metrics[j].Labels)
+		}
+		return metrics[i].ID < metrics[j].ID
+	})
+	var buf bytes.Buffer
+	// owners исходные название и тип метрики, которой принадлежит приведенное название.
+	owners := make(map[string]string)
+	for _, metric := range metrics {
+		var value string
+		switch {
+		case metric.MType == "gauge" && metric.Value != nil:
+			value = formatPrometheusValue(*metric.Value)
+		case metric.MType == "counter" && metric.Delta != nil:
+			value = strconv.FormatInt(*metric.Delta, 10)
+		case metric.MType == "histogram" && metric.Histogram != nil:
+		default:
+			continue
+		}
+		name := sanitizePrometheusName(metric.ID)
+		owner := metric.MType + ":" + metric.ID
+		if o, ok := owners[name]; ok && o != owner {
+			continue
+		} else if !ok {
+			owners[name] = owner
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", name, metric.MType)
+		}
+		if metric.Histogram != nil {
+			writePrometheusHistogram(&buf, name, metric.Labels, metric.Histogram)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s%s %s\n", name, formatPrometheusLabels(metric.Labels), value)
+	}
+	return buf.Bytes()
+}
+
+// writePrometheusHistogram выводит ряды гистограммы: накопленное количество значений в корзинах, сумму
+// и общее количество значений.
+func writePrometheusHistogram(buf *bytes.Buffer, name string, labels map[string]string, h *storage.Histogram) {
+	bucketLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		bucketLabels[k] = v
+	}
+	var cumulative int64
+	for i, c := range h.Counts {
+		cumulative += c
+		bucketLabels["le"] = "+Inf"
+		if i < len(h.Bounds) {
+			bucketLabels["le"] = formatPrometheusValue(h.Bounds[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", name, formatPrometheusLabels(bucketLabels), cumulative)
+	}
+	fmt.Fprintf(buf, "%s_sum%s %s\n", name, formatPrometheusLabels(labels), formatPrometheusValue(h.Sum))
+	fmt.Fprintf(buf, "%s_count%s %d\n", name, formatPrometheusLabels(labels), cumulative)
+}
+
+// prometheusLabelEscaper экранирует значение метки по правилам текстового формата Prometheus.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatPrometheusLabels форматирует метки ряда в виде {name="value",...}, упорядоченные по названию.
+// Для ряда без меток возвращает пустую строку.
+func formatPrometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, prometheusLabelEscaper.Replace(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// sanitizePrometheusName заменяет недопустимые в названии метрики Prometheus символы на "_".
+// Допустимое название соответствует выражению [a-zA-Z_:][a-zA-Z0-9_:]*.
+func sanitizePrometheusName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	out := []byte(name)
+	for i, b := range out {
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b == '_', b == ':':
+		case b >= '0' && b <= '9' && i > 0:
+		default:
+			out[i] = '_'
+		}
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		return "_" + name[:1] + string(out[1:])
+	}
+	return string(out)
+}
+
+// formatPrometheusValue форматирует значение gauge, в том числе специальные значения NaN и ±Inf.
+func formatPrometheusValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/remotewrite"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	tests := []struct {
+		st   storage.IStorage
+		name string
+		want string
+		code int
+	}{
+		{
+			name: "Normal working",
+			st:   &mockStorage{},
+			want: "# TYPE Alloc gauge\nAlloc 0\n# TYPE Counter counter\nCounter 0\n",
+			code: 200,
+		},
+		{
+			name: "storage err",
+			st:   &storage.DBStorage{},
+			code: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.GET("/metrics", PrometheusMetrics(tt.st))
+			req, _ := http.NewRequest("GET", "/metrics", nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == 200 {
+				assert.Equal(t, tt.want, w.Body.String())
+				assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func Test_exposePrometheus(t *testing.T) {
+	var (
+		d  int64 = 5
+		v        = 3.14
+		nv       = math.Inf(-1)
+	)
+	metrics := []storage.Metrics{
+		{ID: "requests.total", MType: "counter", Delta: &d},
+		{ID: "Alloc", MType: "gauge", Value: &v},
+		{ID: "requests-total", MType: "counter", Delta: &d},
+		{ID: "Low", MType: "gauge", Value: &nv},
+		{ID: "Broken", MType: "gauge"},
+		{ID: "CPUutilization1", MType: "gauge", Labels: map[string]string{"host": "b"}, Value: &v},
+		{ID: "CPUutilization1", MType: "gauge", Labels: map[string]string{"host": "a", "path": "C:\\\"x\""}, Value: &v},
+	}
+	want := "# TYPE Alloc gauge\nAlloc 3.14\n" +
+		"# TYPE CPUutilization1 gauge\n" +
+		"CPUutilization1{host=\"a\",path=\"C:\\\\\\\"x\\\"\"} 3.14\n" +
+		"CPUutilization1{host=\"b\"} 3.14\n" +
+		"# TYPE Low gauge\nLow -Inf\n" +
+		"# TYPE requests_total counter\nrequests_total 5\n"
+	assert.Equal(t, want, string(exposePrometheus(metrics)))
+}
+
+func Test_exposePrometheusHistogram(t *testing.T) {
+	metrics := []storage.Metrics{
+		{ID: "GCPauseSeconds", MType: "histogram", Labels: map[string]string{"host": "a"},
+			Histogram: &storage.Histogram{Bounds: []float64{0.001, 0.01}, Counts: []int64{1, 2, 1}, Sum: 0.5}},
+	}
+	want := "# TYPE GCPauseSeconds histogram\n" +
+		"GCPauseSeconds_bucket{host=\"a\",le=\"0.001\"} 1\n" +
+		"GCPauseSeconds_bucket{host=\"a\",le=\"0.01\"} 3\n" +
+		"GCPauseSeconds_bucket{host=\"a\",le=\"+Inf\"} 4\n" +
+		"GCPauseSeconds_sum{host=\"a\"} 0.5\n" +
+		"GCPauseSeconds_count{host=\"a\"} 4\n"
+	assert.Equal(t, want, string(exposePrometheus(metrics)))
+}
+
+func Test_sanitizePrometheusName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "valid", in: "CPUutilization1", want: "CPUutilization1"},
+		{name: "dots and dashes", in: "http.requests-count", want: "http_requests_count"},
+		{name: "leading digit", in: "1st", want: "_1st"},
+		{name: "colon", in: "job:rate5m", want: "job:rate5m"},
+		{name: "empty", in: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizePrometheusName(tt.in))
+		})
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	series := func(name string) remotewrite.TimeSeries {
+		return remotewrite.TimeSeries{
+			Labels:  []remotewrite.Label{{Name: remotewrite.MetricNameLabel, Value: name}},
+			Samples: []remotewrite.Sample{{Value: 1, Timestamp: 1659312000000}},
+		}
+	}
+	tests := []struct {
+		name string
+		fs   *storage.FileStorage
+		body []byte
+		code int
+	}{
+		{
+			name: "Normal write",
+			fs:   &storage.FileStorage{},
+			body: remotewrite.Encode(&remotewrite.WriteRequest{
+				Timeseries: []remotewrite.TimeSeries{series("up"), {}},
+			}),
+			code: 204,
+		},
+		{
+			name: "Bad body",
+			fs:   &storage.FileStorage{},
+			body: []byte("not snappy"),
+			code: 400,
+		},
+		{
+			name: "Insert err",
+			fs:   &storage.FileStorage{},
+			body: remotewrite.Encode(&remotewrite.WriteRequest{
+				Timeseries: []remotewrite.TimeSeries{series("ERROR")},
+			}),
+			code: 500,
+		},
+		{
+			name: "Sync err",
+			fs: &storage.FileStorage{
+				FilePath:    "",
+				Synchronize: true,
+			},
+			body: remotewrite.Encode(&remotewrite.WriteRequest{
+				Timeseries: []remotewrite.TimeSeries{series("up")},
+			}),
+			code: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.POST("/api/v1/write", RemoteWrite(&mockStorage{}, tt.fs))
+			req, _ := http.NewRequest("POST", "/api/v1/write", bytes.NewBuffer(tt.body))
+			req.Header.Set("Content-Encoding", "snappy")
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestRemoteWriteLabels(t *testing.T) {
+	series := func(value float64, labels ...remotewrite.Label) remotewrite.TimeSeries {
+		return remotewrite.TimeSeries{
+			Labels:  append([]remotewrite.Label{{Name: remotewrite.MetricNameLabel, Value: "cpu"}}, labels...),
+			Samples: []remotewrite.Sample{{Value: value, Timestamp: 1659312000000}},
+		}
+	}
+	tests := []struct {
+		name       string
+		series     []remotewrite.TimeSeries
+		code       int
+		wantGauges map[string]float64
+	}{
+		{
+			name: "two label sets",
+			series: []remotewrite.TimeSeries{
+				series(1, remotewrite.Label{Name: "host", Value: "a"}),
+				series(2, remotewrite.Label{Name: "host", Value: "b"}),
+			},
+			code:       204,
+			wantGauges: map[string]float64{`cpu{host="a"}`: 1, `cpu{host="b"}`: 2},
+		},
+		{
+			name:       "bad label name",
+			series:     []remotewrite.TimeSeries{series(1, remotewrite.Label{Name: "host-name", Value: "a"})},
+			code:       400,
+			wantGauges: map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &storage.MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+			}
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.POST("/api/v1/write", RemoteWrite(st, &storage.FileStorage{}))
+			body := remotewrite.Encode(&remotewrite.WriteRequest{Timeseries: tt.series})
+			req, _ := http.NewRequest("POST", "/api/v1/write", bytes.NewBuffer(body))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.code)
+			assert.Equal(t, st.GaugeMetrics, tt.wantGauges)
+		})
+	}
+}
+
+func TestRemoteWriteTypes(t *testing.T) {
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	r := gin.New()
+	r.POST("/api/v1/write", RemoteWrite(st, &storage.FileStorage{}))
+	series := func(name string, values ...float64) remotewrite.TimeSeries {
+		ts := remotewrite.TimeSeries{Labels: []remotewrite.Label{{Name: remotewrite.MetricNameLabel, Value: name}}}
+		for i, v := range values {
+			ts.Samples = append(ts.Samples, remotewrite.Sample{Value: v, Timestamp: int64(i)})
+		}
+		return ts
+	}
+	write := func(wr *remotewrite.WriteRequest) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/write", bytes.NewBuffer(remotewrite.Encode(wr)))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, http.StatusNoContent)
+	}
+
+	// Метаданные приходят отдельным запросом без точек.
+	write(&remotewrite.WriteRequest{Metadata: []remotewrite.MetricMetadata{
+		{Type: remotewrite.MetricTypeCounter, MetricFamilyName: "requests_total"},
+		{Type: remotewrite.MetricTypeGauge, MetricFamilyName: "up"},
+	}})
+	tests := []struct {
+		name         string
+		series       []remotewrite.TimeSeries
+		wantCounters map[string]int64
+		wantGauges   map[string]float64
+	}{
+		{
+			name:         "first write",
+			series:       []remotewrite.TimeSeries{series("requests_total", 4, 10), series("up", 0, 1), series("temp", 21.5)},
+			wantCounters: map[string]int64{"requests_total": 10},
+			wantGauges:   map[string]float64{"up": 1, "temp": 21.5},
+		},
+		{
+			name:         "counter increase",
+			series:       []remotewrite.TimeSeries{series("requests_total", 15)},
+			wantCounters: map[string]int64{"requests_total": 15},
+			wantGauges:   map[string]float64{"up": 1, "temp": 21.5},
+		},
+		{
+			name:         "counter reset",
+			series:       []remotewrite.TimeSeries{series("requests_total", 4)},
+			wantCounters: map[string]int64{"requests_total": 19},
+			wantGauges:   map[string]float64{"up": 1, "temp": 21.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(&remotewrite.WriteRequest{Timeseries: tt.series})
+			assert.Equal(t, st.CounterMetrics, tt.wantCounters)
+			assert.Equal(t, st.GaugeMetrics, tt.wantGauges)
+		})
+	}
+}
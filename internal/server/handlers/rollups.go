@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dsft54/rt-metrics/internal/server/rollup"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// rollupResponse ответ на запрос агрегатов: выбранное разрешение и агрегаты ряда.
+type rollupResponse struct {
+	Resolution string          `json:"resolution"`
+	Points     []rollup.Rollup `json:"points"`
+}
+
+// RequestRollups используется для обработки GET запроса на получение агрегатов истории метрики по url
+// "/rollup/:type/:name?from=&to=&step=&resolution=&labels=". Параметры from, to, step и labels задаются так же,
+// как в RequestHistory, по умолчанию возвращаются последние сутки. Разрешение 1m, 1h или 1d можно передать
+// параметром resolution, иначе оно выбирается по интервалу, шагу и глубине хранения агрегатов. Если
+// построение агрегатов отключено, отвечает 404.
+func RequestRollups(r *rollup.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r == nil {
+			c.String(http.StatusNotFound, "rollups are disabled")
+			return
+		}
+		labels, ok := labelsQuery(c)
+		if !ok {
+			return
+		}
+		now := time.Now()
+		to, err := parseTimeParam(c.Query("to"), now)
+		if err != nil {
+			c.String(http.StatusBadRequest, "bad to param: %v", err)
+			return
+		}
+		from, err := parseTimeParam(c.Query("from"), to.Add(-rollup.Day))
+		if err != nil {
+			c.String(http.StatusBadRequest, "bad from param: %v", err)
+			return
+		}
+		var step time.Duration
+		if c.Query("step") != "" {
+			step, err = time.ParseDuration(c.Query("step"))
+			if err != nil || step < 0 {
+				c.String(http.StatusBadRequest, "bad step param: %v", c.Query("step"))
+				return
+			}
+		}
+		res := r.Resolution(from, to, step, now)
+		if c.Query("resolution") != "" {
+			res, ok = rollupResolutions[c.Query("resolution")]
+			if !ok {
+				c.String(http.StatusBadRequest, "bad resolution param: %v", c.Query("resolution"))
+				return
+			}
+		}
+		points, err := r.Query(c.Param("type"), storage.SeriesKey(c.Param("name"), labels), res, from, to, step)
+		if err != nil {
+			c.String(http.StatusBadRequest, "%s", err)
+			return
+		}
+		c.JSON(http.StatusOK, rollupResponse{Resolution: rollupNames[res], Points: points})
+	}
+}
+
+// rollupSamples представляет агрегаты точками истории для RequestHistory.
+func rollupSamples(points []rollup.Rollup) []storage.Sample {
+	samples := make([]storage.Sample, 0, len(points))
+	for _, p := range points {
+		samples = append(samples, storage.Sample{Time: p.Time, Value: p.Last, Delta: p.Delta})
+	}
+	return samples
+}
+
+// rollupResolutions разрешения агрегатов по их названиям в параметре resolution.
+var rollupResolutions = map[string]time.Duration{"1m": rollup.Minute, "1h": rollup.Hour, "1d": rollup.Day}
+
+// rollupNames названия разрешений агрегатов для ответа.
+var rollupNames = map[time.Duration]string{rollup.Minute: "1m", rollup.Hour: "1h", rollup.Day: "1d"}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/rollup"
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestRequestRollups(t *testing.T) {
+	now := time.Now()
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{`Alloc{host="a"}`: 3},
+		CounterMetrics: map[string]int64{},
+		History:        storage.NewHistory(0),
+	}
+	for i, v := range []float64{1, 2, 3} {
+		value := v
+		st.History.Record("gauge", `Alloc{host="a"}`, &value, nil, now.Add(-time.Duration(3-i)*time.Hour))
+	}
+	store := rollup.NewStore(map[time.Duration]time.Duration{rollup.Minute: 24 * time.Hour})
+	assert.Equal(t, rollup.NewCompactor(st, store, "").Compact(now), nil)
+
+	r := gin.New()
+	r.GET("/rollup/:type/:name", RequestRollups(store))
+	disabled := gin.New()
+	disabled.GET("/rollup/:type/:name", RequestRollups(nil))
+
+	tests := []struct {
+		name           string
+		router         *gin.Engine
+		url            string
+		code           int
+		wantResolution string
+		wantPoints     int
+	}{
+		{name: "auto", router: r, url: "/rollup/gauge/Alloc?labels=host=a", code: 200, wantResolution: "1m", wantPoints: 3},
+		{name: "step", router: r, url: "/rollup/gauge/Alloc?labels=host=a&step=6h", code: 200, wantResolution: "1h"},
+		{name: "explicit", router: r, url: "/rollup/gauge/Alloc?labels=host=a&resolution=1m&step=24h", code: 200,
+			wantResolution: "1m", wantPoints: 1},
+		{name: "other series", router: r, url: "/rollup/gauge/Alloc", code: 200, wantResolution: "1m"},
+		{name: "bad resolution", router: r, url: "/rollup/gauge/Alloc?resolution=5m", code: 400},
+		{name: "bad step", router: r, url: "/rollup/gauge/Alloc?step=-1s", code: 400},
+		{name: "bad from", router: r, url: "/rollup/gauge/Alloc?from=yesterday", code: 400},
+		{name: "bad labels", router: r, url: "/rollup/gauge/Alloc?labels=host", code: 400},
+		{name: "disabled", router: disabled, url: "/rollup/gauge/Alloc", code: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			tt.router.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.code)
+			if tt.code != http.StatusOK {
+				return
+			}
+			got := rollupResponse{}
+			assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &got), nil)
+			assert.Equal(t, got.Resolution, tt.wantResolution)
+			if tt.wantPoints > 0 {
+				assert.Equal(t, len(got.Points), tt.wantPoints)
+			}
+		})
+	}
+}
+
+func TestRequestHistoryRollups(t *testing.T) {
+	now := time.Now()
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{"Alloc": 3},
+		CounterMetrics: map[string]int64{"PollCount": 30},
+		History:        storage.NewHistory(0),
+	}
+	for i, v := range []int64{10, 20, 30} {
+		gauge, delta := float64(i+1), v
+		at := now.Add(-time.Duration(3-i) * time.Hour)
+		st.History.Record("gauge", "Alloc", &gauge, nil, at)
+		st.History.Record("counter", "PollCount", nil, &delta, at)
+	}
+	store := rollup.NewStore(map[time.Duration]time.Duration{rollup.Minute: 24 * time.Hour})
+	assert.Equal(t, rollup.NewCompactor(st, store, "").Compact(now), nil)
+	// Сырая история в st хранит все точки, поэтому по числу точек видно, откуда построен ответ.
+	st.History.Record("gauge", "Alloc", &[]float64{4}[0], nil, now.Add(-time.Minute))
+
+	r := gin.New()
+	r.GET("/history/:type/:name", RequestHistory(st, store, 90*time.Minute))
+	from := strconv.FormatInt(now.Add(-4*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name           string
+		url            string
+		wantResolution string
+		wantSamples    int
+		wantLast       *int64
+	}{
+		{name: "raw", url: "/history/gauge/Alloc", wantSamples: 1},
+		{name: "gauge rollups", url: "/history/gauge/Alloc?from=" + from, wantResolution: "1m", wantSamples: 3},
+		{name: "counter rollups", url: "/history/counter/PollCount?from=" + from, wantResolution: "1m", wantSamples: 3,
+			wantLast: &[]int64{30}[0]},
+		{name: "step", url: "/history/gauge/Alloc?step=24h&from=" + from, wantResolution: "1d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, http.StatusOK)
+			assert.Equal(t, w.Header().Get("X-History-Resolution"), tt.wantResolution)
+			samples := []storage.Sample{}
+			assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &samples), nil)
+			assert.Equal(t, len(samples), tt.wantSamples)
+			if tt.wantLast != nil {
+				assert.Equal(t, *samples[len(samples)-1].Delta, *tt.wantLast)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+	"github.com/dsft54/rt-metrics/internal/server/stream"
+)
+
+// streamKeepAlive интервал отправки комментариев в поток SSE, чтобы соединение не закрывалось
+// промежуточными прокси и отключившиеся клиенты обнаруживались без ожидания обновлений.
+const streamKeepAlive = 15 * time.Second
+
+// StreamSSE отдает обновления метрик в формате Server-Sent Events по GET запросу на /stream. Каждое
+// обновление передается событием metric с метрикой в формате json. Параметр type ограничивает тип метрик,
+// параметр name задает префикс названия или шаблон вида Heap*. Если клиент не успевает принимать события,
+// поток завершается, и клиент может переподключиться.
+func StreamSSE(b *stream.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := streamFilter(c)
+		if !ok {
+			return
+		}
+		sub := b.Subscribe(filter)
+		defer b.Unsubscribe(sub)
+		ticker := time.NewTicker(streamKeepAlive)
+		defer ticker.Stop()
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case metric, ok := <-sub.C:
+				if !ok {
+					return false
+				}
+				c.SSEvent("metric", metric)
+				return true
+			case <-ticker.C:
+				_, err := c.Writer.WriteString(": keepalive\n\n")
+				return err == nil
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// StreamWebSocket отдает те же обновления, что и StreamSSE, через WebSocket по GET запросу на /stream/ws.
+// Каждое обновление передается отдельным текстовым сообщением с метрикой в формате json.
+func StreamWebSocket(b *stream.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := streamFilter(c)
+		if !ok {
+			return
+		}
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			sub := b.Subscribe(filter)
+			defer b.Unsubscribe(sub)
+			// Входящие сообщения не используются, чтение нужно только для обнаружения закрытия соединения.
+			go func() {
+				var msg string
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				b.Unsubscribe(sub)
+			}()
+			for metric := range sub.C {
+				err := websocket.JSON.Send(ws, metric)
+				if err != nil {
+					log.Println("Websocket send err", err)
+					return
+				}
+			}
+		}}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// streamFilter получает условие отбора из параметров запроса. При неизвестном типе метрики отвечает 400.
+func streamFilter(c *gin.Context) (stream.Filter, bool) {
+	filter := stream.Filter{
+		Type: c.Query("type"),
+		Name: c.Query("name"),
+	}
+	switch filter.Type {
+	case "", "gauge", "counter", "histogram":
+		return filter, true
+	}
+	c.String(http.StatusBadRequest, "bad type param: %v", filter.Type)
+	return filter, false
+}
+
+// publishUpdates рассылает подписчикам актуальные значения обновленных метрик. Значения перечитываются
+// из хранилища, чтобы для counter передавалось накопленное значение, а не приращение. Если подписчиков нет,
+// хранилище не читается.
+func publishUpdates(b *stream.Broker, st storage.IStorage, metrics ...storage.Metrics) {
+	if !b.Active() {
+		return
+	}
+	now := time.Now()
+	updated := make([]storage.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		current, err := st.ReadMetric(&storage.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels})
+		if err != nil {
+			log.Println("Read updated metric err", err)
+			continue
+		}
+		current.Hash = ""
+		current.Updated = &now
+		updated = append(updated, *current)
+	}
+	b.Publish(updated...)
+}
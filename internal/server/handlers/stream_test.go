@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert"
+	"golang.org/x/net/websocket"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+	"github.com/dsft54/rt-metrics/internal/server/stream"
+)
+
+// setupStreamServer поднимает сервер с обработчиками потоков и обновления метрик.
+func setupStreamServer(b *stream.Broker) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/stream", StreamSSE(b))
+	r.GET("/stream/ws", StreamWebSocket(b))
+	r.POST("/update/", UpdateMetricJSON(&mockStorage{}, &storage.FileStorage{}, b, ""))
+	r.POST("/update/:type/:name/:value", ParametersUpdate(&mockStorage{}, &storage.FileStorage{}, b))
+	return httptest.NewServer(r)
+}
+
+// waitSubscribers ждет появления подписчика, чтобы не потерять опубликованные до подписки метрики.
+func waitSubscribers(t *testing.T, b *stream.Broker) {
+	for i := 0; i < 100 && !b.Active(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !b.Active() {
+		t.Fatal("no subscribers")
+	}
+}
+
+func TestStreamSSE(t *testing.T) {
+	b := stream.NewBroker(0)
+	server := setupStreamServer(b)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream?type=bad")
+	assert.Equal(t, err, nil)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/stream?type=gauge&name=All", nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	waitSubscribers(t, b)
+	// Counter не проходит фильтр, в поток должен попасть только gauge.
+	resp1, err := http.Post(server.URL+"/update/counter/Pollcount/1", "text/plain", nil)
+	assert.Equal(t, err, nil)
+	resp1.Body.Close()
+	value := 1.5
+	body, _ := json.Marshal(storage.Metrics{ID: "Alloc", MType: "gauge", Value: &value})
+	resp2, err := http.Post(server.URL+"/update/", "application/json", bytes.NewReader(body))
+	assert.Equal(t, err, nil)
+	resp2.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	var event, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	assert.Equal(t, event, "metric")
+	metric := storage.Metrics{}
+	assert.Equal(t, json.Unmarshal([]byte(data), &metric), nil)
+	assert.Equal(t, metric.ID, "Alloc")
+	assert.Equal(t, metric.MType, "gauge")
+	assert.NotEqual(t, metric.Updated, nil)
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	b := stream.NewBroker(0)
+	server := setupStreamServer(b)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/stream/ws?type=counter"
+	ws, err := websocket.Dial(wsURL, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, b)
+	resp, err := http.Post(server.URL+"/update/counter/Pollcount/1", "text/plain", nil)
+	assert.Equal(t, err, nil)
+	resp.Body.Close()
+
+	metric := storage.Metrics{}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.Equal(t, websocket.JSON.Receive(ws, &metric), nil)
+	assert.Equal(t, metric.ID, "Pollcount")
+	assert.Equal(t, metric.MType, "counter")
+
+	// После закрытия соединения подписка должна быть удалена.
+	ws.Close()
+	for i := 0; i < 100 && b.Active(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, b.Active(), false)
+}
@@ -0,0 +1,232 @@
+// Package lineproto реализует разбор протокола InfluxDB line protocol, в котором метрики отправляет
+// Telegraf. Строка имеет вид measurement[,tag=value...] field=value[,field=value...] [timestamp].
+package lineproto
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Типы значений полей.
+const (
+	FieldFloat = iota
+	FieldInteger
+	FieldUnsigned
+	FieldString
+	FieldBoolean
+)
+
+var (
+	errNoFields      = errors.New("missing fields")
+	errNoMeasurement = errors.New("missing measurement")
+	errUnclosedQuote = errors.New("unterminated quoted string")
+)
+
+// Field поле строки: ключ, тип и значение. Для строковых полей значение хранится в String,
+// для булевых в Float (0 или 1), для целых - в Integer.
+type Field struct {
+	Key     string
+	Type    int
+	Float   float64
+	Integer int64
+	String  string
+}
+
+// Tag пара ключ-значение тега.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Point разобранная строка протокола.
+type Point struct {
+	Measurement string
+	Tags        []Tag
+	Fields      []Field
+	Timestamp   int64
+	HasTime     bool
+	Line        int // номер строки в теле запроса, начиная с 1, заполняется Parse
+}
+
+// LineError ошибка разбора строки с ее номером, начиная с 1.
+type LineError struct {
+	Line int    `json:"line"`
+	Err  string `json:"error"`
+}
+
+// Error реализует интерфейс error.
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// Parse разбирает тело запроса построчно. Пустые строки и комментарии пропускаются, для строк,
+// которые не удалось разобрать, возвращаются ошибки с их номерами.
+func Parse(body string) ([]Point, []LineError) {
+	var (
+		points []Point
+		errs   []LineError
+	)
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := ParseLine(line)
+		if err != nil {
+			errs = append(errs, LineError{Line: i + 1, Err: err.Error()})
+			continue
+		}
+		point.Line = i + 1
+		points = append(points, point)
+	}
+	return points, errs
+}
+
+// ParseLine разбирает одну строку протокола.
+func ParseLine(line string) (Point, error) {
+	p := Point{}
+	sections, err := splitUnescaped(line, ' ')
+	if err != nil {
+		return p, err
+	}
+	if len(sections) < 2 {
+		return p, errNoFields
+	}
+	if len(sections) > 3 {
+		return p, fmt.Errorf("unexpected section %q", sections[3])
+	}
+	series, err := splitUnescaped(sections[0], ',')
+	if err != nil {
+		return p, err
+	}
+	p.Measurement = unescape(series[0])
+	if p.Measurement == "" {
+		return p, errNoMeasurement
+	}
+	for _, raw := range series[1:] {
+		key, value, err := splitPair(raw)
+		if err != nil {
+			return p, err
+		}
+		p.Tags = append(p.Tags, Tag{Key: key, Value: unescape(value)})
+	}
+	fields, err := splitUnescaped(sections[1], ',')
+	if err != nil {
+		return p, err
+	}
+	for _, raw := range fields {
+		key, value, err := splitPair(raw)
+		if err != nil {
+			return p, err
+		}
+		field, err := parseFieldValue(value)
+		if err != nil {
+			return p, fmt.Errorf("field %q: %w", key, err)
+		}
+		field.Key = key
+		p.Fields = append(p.Fields, field)
+	}
+	if len(sections) == 3 {
+		p.Timestamp, err = strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("wrong timestamp %q", sections[2])
+		}
+		p.HasTime = true
+	}
+	return p, nil
+}
+
+// parseFieldValue определяет тип значения поля по его записи.
+func parseFieldValue(value string) (Field, error) {
+	f := Field{}
+	switch {
+	case value == "":
+		return f, errors.New("empty value")
+	case strings.HasPrefix(value, `"`):
+		if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+			return f, errUnclosedQuote
+		}
+		f.Type = FieldString
+		f.String = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		return f, nil
+	case strings.HasSuffix(value, "i"):
+		i, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("wrong integer %q", value)
+		}
+		f.Type = FieldInteger
+		f.Integer = i
+		return f, nil
+	case strings.HasSuffix(value, "u"):
+		u, err := strconv.ParseUint(value[:len(value)-1], 10, 63)
+		if err != nil {
+			return f, fmt.Errorf("wrong unsigned %q", value)
+		}
+		f.Type = FieldUnsigned
+		f.Integer = int64(u)
+		return f, nil
+	}
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		f.Type = FieldBoolean
+		f.Float = 1
+		return f, nil
+	case "f", "F", "false", "False", "FALSE":
+		f.Type = FieldBoolean
+		return f, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return f, fmt.Errorf("wrong float %q", value)
+	}
+	f.Type = FieldFloat
+	f.Float = v
+	return f, nil
+}
+
+// splitPair разделяет запись key=value по первому неэкранированному знаку "=".
+func splitPair(raw string) (string, string, error) {
+	parts, err := splitUnescaped(raw, '=')
+	if err != nil {
+		return "", "", err
+	}
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("wrong key=value pair %q", raw)
+	}
+	return unescape(parts[0]), strings.Join(parts[1:], "="), nil
+}
+
+// splitUnescaped разделяет строку по символу sep, пропуская экранированные обратной косой чертой
+// символы и содержимое строк в двойных кавычках.
+func splitUnescaped(s string, sep byte) ([]string, error) {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if quoted {
+		return nil, errUnclosedQuote
+	}
+	return append(parts, s[start:]), nil
+}
+
+// unescape убирает экранирование пробелов, запятых и знаков "=" в названиях и тегах.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\ `, ` `, `\,`, `,`, `\=`, `=`).Replace(s)
+}
@@ -0,0 +1,104 @@
+package lineproto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-playground/assert"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Point
+		wantErr bool
+	}{
+		{
+			name: "full line",
+			line: `cpu,host=server\ 1,region=eu usage_idle=98.5,procs=12i,up=t,note="a b, c" 1659312000000000000`,
+			want: Point{
+				Measurement: "cpu",
+				Tags:        []Tag{{Key: "host", Value: "server 1"}, {Key: "region", Value: "eu"}},
+				Fields: []Field{
+					{Key: "usage_idle", Type: FieldFloat, Float: 98.5},
+					{Key: "procs", Type: FieldInteger, Integer: 12},
+					{Key: "up", Type: FieldBoolean, Float: 1},
+					{Key: "note", Type: FieldString, String: "a b, c"},
+				},
+				Timestamp: 1659312000000000000,
+				HasTime:   true,
+			},
+		},
+		{
+			name: "no tags no time",
+			line: `mem free=1024u`,
+			want: Point{
+				Measurement: "mem",
+				Fields:      []Field{{Key: "free", Type: FieldUnsigned, Integer: 1024}},
+			},
+		},
+		{
+			name:    "no fields",
+			line:    "cpu,host=a",
+			wantErr: true,
+		},
+		{
+			name:    "bad integer",
+			line:    "cpu procs=1.5i",
+			wantErr: true,
+		},
+		{
+			name:    "bad float",
+			line:    "cpu usage=abc",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			line:    `cpu note="abc`,
+			wantErr: true,
+		},
+		{
+			name:    "bad tag",
+			line:    "cpu,host usage=1",
+			wantErr: true,
+		},
+		{
+			name:    "bad timestamp",
+			line:    "cpu usage=1 now",
+			wantErr: true,
+		},
+		{
+			name:    "extra section",
+			line:    "cpu usage=1 1 2",
+			wantErr: true,
+		},
+		{
+			name:    "no measurement",
+			line:    ",host=a usage=1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	body := "# comment\ncpu usage=1\n\nmem free=\ndisk used=3i\n"
+	points, errs := Parse(body)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, len(errs), 1)
+	assert.Equal(t, errs[0].Line, 4)
+	assert.Equal(t, points[1].Line, 5)
+	assert.NotEqual(t, errs[0].Error(), "")
+}
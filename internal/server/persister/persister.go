@@ -0,0 +1,210 @@
+// Package persister определяет компонент периодического сохранения хранилища метрик в файл. Сохранение
+// выполняется только если данные изменились с предыдущего сохранения, результат последних попыток доступен
+// через Status, а при остановке сервера выполняется последнее сохранение.
+package persister
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// Status состояние сохранения: время последнего успешного сохранения, последняя ошибка и время ее
+// появления, есть ли несохраненные изменения и сколько сохранений выполнено.
+type Status struct {
+	Interval    string     `json:"interval"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	Dirty       bool       `json:"dirty"`
+	Flushes     int64      `json:"flushes"`
+}
+
+// Healthy сохранение считается исправным, если после последней ошибки было успешное сохранение.
+func (s Status) Healthy() bool {
+	if s.LastErrorAt == nil {
+		return true
+	}
+	return s.LastSuccess != nil && s.LastSuccess.After(*s.LastErrorAt)
+}
+
+// Persister сохраняет хранилище Storage в файловое хранилище Files каждые Interval, если с предыдущего
+// сохранения были изменения. Об изменениях сообщает обертка, возвращаемая методом Track.
+type Persister struct {
+	Files    *storage.FileStorage
+	Storage  storage.IStorage
+	Interval time.Duration
+	// changes счетчик изменений, flushed - его значение на момент последнего успешного сохранения.
+	changes int64
+	flushed int64
+	started int32
+	status  Status
+	stop    chan struct{}
+	done    chan struct{}
+	flushMu sync.Mutex
+	mutex   sync.RWMutex
+}
+
+// NewPersister функция-конструктор для Persister.
+func NewPersister(files *storage.FileStorage, st storage.IStorage, interval time.Duration) *Persister {
+	return &Persister{
+		Files:    files,
+		Storage:  st,
+		Interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// MarkDirty отмечает, что хранилище изменилось.
+func (p *Persister) MarkDirty() {
+	atomic.AddInt64(&p.changes, 1)
+}
+
+// Dirty проверяет, есть ли несохраненные изменения.
+func (p *Persister) Dirty() bool {
+	return atomic.LoadInt64(&p.changes) != atomic.LoadInt64(&p.flushed)
+}
+
+// Flush сохраняет хранилище, если с предыдущего успешного сохранения были изменения, и записывает
+// результат в Status. Изменения, пришедшие во время сохранения, останутся отмеченными до следующего.
+func (p *Persister) Flush() error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+	changes := atomic.LoadInt64(&p.changes)
+	if changes == atomic.LoadInt64(&p.flushed) {
+		return nil
+	}
+	err := p.Files.Checkpoint(p.Storage)
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		p.status.LastError = err.Error()
+		p.status.LastErrorAt = &now
+		return err
+	}
+	atomic.StoreInt64(&p.flushed, changes)
+	p.status.LastSuccess = &now
+	p.status.Flushes++
+	return nil
+}
+
+// Status возвращает состояние сохранения. Для nil Persister возвращает пустое состояние.
+func (p *Persister) Status() Status {
+	if p == nil {
+		return Status{}
+	}
+	p.mutex.RLock()
+	status := p.status
+	p.mutex.RUnlock()
+	status.Interval = p.Interval.String()
+	status.Dirty = p.Dirty()
+	return status
+}
+
+// Start запускает периодическое сохранение до отмены ctx или вызова Stop.
+func (p *Persister) Start(ctx context.Context) {
+	atomic.StoreInt32(&p.started, 1)
+	go p.run(ctx)
+}
+
+func (p *Persister) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := p.Flush()
+			if err != nil {
+				log.Println("Persister flush failed:", err)
+			}
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Stop останавливает периодическое сохранение, дожидается завершения текущего и выполняет последнее
+// сохранение изменений. Вызывается при остановке сервера после того, как он перестал принимать метрики.
+func (p *Persister) Stop() error {
+	select {
+	case <-p.stop:
+		return errors.New("persister already stopped")
+	default:
+		close(p.stop)
+	}
+	// Если Start не вызывался, ждать нечего.
+	if atomic.LoadInt32(&p.started) == 1 {
+		<-p.done
+	}
+	return p.Flush()
+}
+
+// Track возвращает обертку над хранилищем, отмечающую изменения после каждого успешного обновления и
+// после любого списка обновлений, см. trackedStorage.InsertBatchMetric.
+func (p *Persister) Track(st storage.IStorage) storage.IStorage {
+	return &trackedStorage{IStorage: st, p: p}
+}
+
+// trackedStorage обертка над хранилищем, сообщающая Persister об изменениях.
+type trackedStorage struct {
+	storage.IStorage
+	p *Persister
+}
+
+func (t *trackedStorage) InsertMetric(m *storage.Metrics) error {
+	err := t.IStorage.InsertMetric(m)
+	if err == nil {
+		t.p.MarkDirty()
+	}
+	return err
+}
+
+// InsertBatchMetric отмечает изменения и при ошибке: хранилища в памяти применяют список до первой ошибки,
+// и примененное начало списка тоже нужно сохранить. Лишнее сохранение безопаснее потерянного.
+func (t *trackedStorage) InsertBatchMetric(metrics []storage.Metrics) error {
+	err := t.IStorage.InsertBatchMetric(metrics)
+	t.p.MarkDirty()
+	return err
+}
+
+func (t *trackedStorage) ParamsUpdate(metricType, metricName, metricValue string) (int, error) {
+	code, err := t.IStorage.ParamsUpdate(metricType, metricName, metricValue)
+	if err == nil && code == 200 {
+		t.p.MarkDirty()
+	}
+	return code, err
+}
+
+func (t *trackedStorage) DeleteMetrics(metrics []storage.Metrics) (int, error) {
+	deleted, err := t.IStorage.DeleteMetrics(metrics)
+	if err == nil && deleted > 0 {
+		t.p.MarkDirty()
+	}
+	return deleted, err
+}
+
+func (t *trackedStorage) ResetCounter(m *storage.Metrics) (bool, error) {
+	found, err := t.IStorage.ResetCounter(m)
+	if err == nil && found {
+		t.p.MarkDirty()
+	}
+	return found, err
+}
+
+func (t *trackedStorage) SweepStale(before time.Time, evict bool) ([]storage.Metrics, error) {
+	swept, err := t.IStorage.SweepStale(before, evict)
+	if err == nil && len(swept) > 0 {
+		t.p.MarkDirty()
+	}
+	return swept, err
+}
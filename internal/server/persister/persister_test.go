@@ -0,0 +1,132 @@
+package persister
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func newTestPersister(path string) (*Persister, storage.IStorage) {
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	fs := &storage.FileStorage{FilePath: path, StoreData: true}
+	p := NewPersister(fs, st, time.Hour)
+	return p, p.Track(st)
+}
+
+func TestPersister_Flush(t *testing.T) {
+	p, st := newTestPersister(filepath.Join(t.TempDir(), "metrics.json"))
+	assert.Equal(t, p.Dirty(), false)
+	// Без изменений сохранение не выполняется.
+	assert.Equal(t, p.Flush(), nil)
+	assert.Equal(t, p.Status().Flushes, int64(0))
+
+	v := 1.5
+	tests := []struct {
+		name   string
+		update func() error
+		dirty  bool
+	}{
+		{name: "insert", update: func() error {
+			return st.InsertMetric(&storage.Metrics{ID: "Alloc", MType: "gauge", Value: &v})
+		}, dirty: true},
+		{name: "batch", update: func() error {
+			return st.InsertBatchMetric([]storage.Metrics{{ID: "Alloc", MType: "gauge", Value: &v}})
+		}, dirty: true},
+		{name: "partial batch", update: func() error {
+			// Хранилище в памяти применяет список до первой ошибки, поэтому начало списка нужно сохранить.
+			st.InsertBatchMetric([]storage.Metrics{{ID: "Alloc", MType: "gauge", Value: &v}, {ID: "Summary", MType: "summary"}})
+			return nil
+		}, dirty: true},
+		{name: "params", update: func() error {
+			_, err := st.ParamsUpdate("counter", "PollCount", "1")
+			return err
+		}, dirty: true},
+		{name: "failed params", update: func() error {
+			st.ParamsUpdate("counter", "PollCount", "bad")
+			return nil
+		}, dirty: false},
+		{name: "reset", update: func() error {
+			_, err := st.ResetCounter(&storage.Metrics{ID: "PollCount", MType: "counter"})
+			return err
+		}, dirty: true},
+		{name: "delete", update: func() error {
+			_, err := st.DeleteMetrics([]storage.Metrics{{ID: "Alloc", MType: "gauge"}})
+			return err
+		}, dirty: true},
+		{name: "delete missing", update: func() error {
+			_, err := st.DeleteMetrics([]storage.Metrics{{ID: "Alloc", MType: "gauge"}})
+			return err
+		}, dirty: false},
+		{name: "read", update: func() error {
+			_, err := st.ReadAllMetrics()
+			return err
+		}, dirty: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.update(), nil)
+			assert.Equal(t, p.Dirty(), tt.dirty)
+			assert.Equal(t, p.Flush(), nil)
+			assert.Equal(t, p.Dirty(), false)
+		})
+	}
+	status := p.Status()
+	assert.Equal(t, status.Flushes, int64(6))
+	assert.Equal(t, status.Healthy(), true)
+	if status.LastSuccess == nil {
+		t.Error("Persister.Status() has no last success")
+	}
+}
+
+func TestPersister_FlushError(t *testing.T) {
+	p, st := newTestPersister(filepath.Join(t.TempDir(), "missing", "metrics.json"))
+	_, err := st.ParamsUpdate("gauge", "Alloc", "1")
+	assert.Equal(t, err, nil)
+	if err := p.Flush(); err == nil {
+		t.Fatal("Persister.Flush() expected error")
+	}
+	status := p.Status()
+	assert.Equal(t, status.Dirty, true)
+	assert.Equal(t, status.Healthy(), false)
+	if status.LastError == "" || status.LastErrorAt == nil {
+		t.Errorf("Persister.Status() = %+v, want last error", status)
+	}
+
+	// Исправленный путь восстанавливает состояние при следующем сохранении.
+	p.Files.FilePath = filepath.Join(t.TempDir(), "metrics.json")
+	assert.Equal(t, p.Flush(), nil)
+	assert.Equal(t, p.Status().Healthy(), true)
+}
+
+func TestPersister_Stop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	p, st := newTestPersister(path)
+	p.Interval = 10 * time.Millisecond
+	p.Start(context.Background())
+	_, err := st.ParamsUpdate("counter", "PollCount", "5")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, p.Stop(), nil)
+	assert.Equal(t, p.Dirty(), false)
+	if err := p.Stop(); err == nil {
+		t.Error("Persister.Stop() expected error on second call")
+	}
+
+	restored := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+	assert.Equal(t, p.Files.Restore(restored), nil)
+	assert.Equal(t, restored.CounterMetrics["PollCount"], int64(5))
+
+	// Stop без Start только сохраняет изменения.
+	idle, _ := newTestPersister(path)
+	assert.Equal(t, idle.Stop(), nil)
+}
@@ -0,0 +1,262 @@
+// Package remotewrite реализует разбор тел запросов Prometheus remote_write: сжатых snappy
+// сообщений protobuf WriteRequest. Разбираются только временные ряды (метки и точки) и метаданные,
+// остальные поля сообщений пропускаются.
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MetricNameLabel метка, в которой Prometheus передает название метрики.
+const MetricNameLabel = "__name__"
+
+// Типы метрик из MetricMetadata.MetricType.
+const (
+	MetricTypeUnknown = 0
+	MetricTypeCounter = 1
+	MetricTypeGauge   = 2
+)
+
+var errTruncated = errors.New("remote write: truncated message")
+
+// Label пара название-значение метки временного ряда.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample точка временного ряда: значение и время в миллисекундах unix time.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries временной ряд, состоящий из набора меток и точек.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Name возвращает название метрики ряда из метки __name__.
+func (ts *TimeSeries) Name() string {
+	for _, l := range ts.Labels {
+		if l.Name == MetricNameLabel {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// SeriesLabels возвращает метки ряда без метки __name__. Для ряда без других меток возвращает nil.
+func (ts *TimeSeries) SeriesLabels() map[string]string {
+	var labels map[string]string
+	for _, l := range ts.Labels {
+		if l.Name == MetricNameLabel {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string, len(ts.Labels))
+		}
+		labels[l.Name] = l.Value
+	}
+	return labels
+}
+
+// Latest возвращает самую позднюю точку ряда. Если точек нет, второе значение равно false.
+func (ts *TimeSeries) Latest() (Sample, bool) {
+	if len(ts.Samples) == 0 {
+		return Sample{}, false
+	}
+	latest := ts.Samples[0]
+	for _, s := range ts.Samples[1:] {
+		if s.Timestamp >= latest.Timestamp {
+			latest = s
+		}
+	}
+	return latest, true
+}
+
+// MetricMetadata метаданные семейства метрик: тип и название.
+type MetricMetadata struct {
+	Type             int
+	MetricFamilyName string
+}
+
+// WriteRequest сообщение remote_write со списком рядов и метаданных.
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+// Decode распаковывает тело запроса, сжатое snappy, и разбирает из него WriteRequest.
+func Decode(body []byte) (*WriteRequest, error) {
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("remote write: snappy decode: %w", err)
+	}
+	return Unmarshal(data)
+}
+
+// Unmarshal разбирает protobuf сообщение WriteRequest.
+func Unmarshal(data []byte) (*WriteRequest, error) {
+	wr := &WriteRequest{}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ts, err := unmarshalTimeSeries(field)
+			if err != nil {
+				return err
+			}
+			wr.Timeseries = append(wr.Timeseries, ts)
+		case num == 3 && typ == protowire.BytesType:
+			md, err := unmarshalMetadata(field)
+			if err != nil {
+				return err
+			}
+			wr.Metadata = append(wr.Metadata, md)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+func unmarshalTimeSeries(data []byte) (TimeSeries, error) {
+	ts := TimeSeries{}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			l := Label{}
+			err := walk(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				if typ != protowire.BytesType {
+					return nil
+				}
+				switch num {
+				case 1:
+					l.Name = string(field)
+				case 2:
+					l.Value = string(field)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case num == 2 && typ == protowire.BytesType:
+			s := Sample{}
+			err := walk(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					v, _ := protowire.ConsumeFixed64(field)
+					s.Value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(field)
+					s.Timestamp = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+func unmarshalMetadata(data []byte) (MetricMetadata, error) {
+	md := MetricMetadata{}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(field)
+			md.Type = int(v)
+		case num == 2 && typ == protowire.BytesType:
+			md.MetricFamilyName = string(field)
+		}
+		return nil
+	})
+	return md, err
+}
+
+// Encode кодирует WriteRequest в protobuf сообщение и сжимает его snappy.
+func Encode(wr *WriteRequest) []byte {
+	return snappy.Encode(nil, Marshal(wr))
+}
+
+// Marshal кодирует WriteRequest в protobuf сообщение.
+func Marshal(wr *WriteRequest) []byte {
+	var b []byte
+	for _, ts := range wr.Timeseries {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+			tsb = protowire.AppendTag(tsb, 1, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+		for _, s := range ts.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+			tsb = protowire.AppendTag(tsb, 2, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, sb)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsb)
+	}
+	for _, md := range wr.Metadata {
+		var mdb []byte
+		mdb = protowire.AppendTag(mdb, 1, protowire.VarintType)
+		mdb = protowire.AppendVarint(mdb, uint64(md.Type))
+		mdb = protowire.AppendTag(mdb, 2, protowire.BytesType)
+		mdb = protowire.AppendString(mdb, md.MetricFamilyName)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, mdb)
+	}
+	return b
+}
+
+// walk последовательно разбирает поля protobuf сообщения и вызывает для каждого fn. Для полей типа
+// bytes в fn передается содержимое поля, для остальных типов - его закодированное значение.
+func walk(data []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errTruncated
+		}
+		data = data[n:]
+		var field []byte
+		if typ == protowire.BytesType {
+			field, n = protowire.ConsumeBytes(data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n >= 0 {
+				field = data[:n]
+			}
+		}
+		if n < 0 {
+			return errTruncated
+		}
+		data = data[n:]
+		err := fn(num, typ, field)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
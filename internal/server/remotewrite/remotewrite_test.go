@@ -0,0 +1,85 @@
+package remotewrite
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-playground/assert"
+	"github.com/golang/snappy"
+)
+
+func TestDecode(t *testing.T) {
+	wr := &WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels: []Label{
+					{Name: MetricNameLabel, Value: "up"},
+					{Name: "job", Value: "node"},
+				},
+				Samples: []Sample{
+					{Value: 1, Timestamp: 1659312000000},
+					{Value: 0, Timestamp: 1659312015000},
+				},
+			},
+		},
+		Metadata: []MetricMetadata{
+			{Type: MetricTypeGauge, MetricFamilyName: "up"},
+		},
+	}
+	tests := []struct {
+		name    string
+		body    []byte
+		want    *WriteRequest
+		wantErr bool
+	}{
+		{
+			name:    "round trip",
+			body:    Encode(wr),
+			want:    wr,
+			wantErr: false,
+		},
+		{
+			name:    "not snappy",
+			body:    []byte{255, 255, 255, 255, 255},
+			wantErr: true,
+		},
+		{
+			name:    "truncated snappy",
+			body:    Encode(wr)[:10],
+			wantErr: true,
+		},
+		{
+			name:    "truncated protobuf",
+			body:    snappy.Encode(nil, Marshal(wr)[:10]),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeSeries_Latest(t *testing.T) {
+	ts := TimeSeries{
+		Labels: []Label{{Name: MetricNameLabel, Value: "up"}},
+		Samples: []Sample{
+			{Value: 3, Timestamp: 30},
+			{Value: 1, Timestamp: 10},
+		},
+	}
+	latest, ok := ts.Latest()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, latest.Value, float64(3))
+	assert.Equal(t, ts.Name(), "up")
+	_, ok = (&TimeSeries{}).Latest()
+	assert.Equal(t, ok, false)
+}
@@ -0,0 +1,90 @@
+package rollup
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// DefaultInterval интервал построения агрегатов по умолчанию.
+const DefaultInterval = time.Minute
+
+// Compactor периодически дополняет Store агрегатами закончившихся интервалов, читая точки истории
+// gauge и counter из хранилища. Если задан Path, после каждого построения агрегаты сохраняются в файл.
+type Compactor struct {
+	Storage storage.IStorage
+	Store   *Store
+	Path    string
+}
+
+// NewCompactor функция-конструктор для Compactor.
+func NewCompactor(st storage.IStorage, store *Store, path string) *Compactor {
+	return &Compactor{
+		Storage: st,
+		Store:   store,
+		Path:    path,
+	}
+}
+
+// Run строит агрегаты с интервалом interval до ctx.Done.
+func (c *Compactor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			err := c.Compact(now)
+			if err != nil {
+				log.Println("Rollup compaction: ", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Compact строит минутные агрегаты из точек истории, записанных с прошлого построения до начала текущей
+// минуты, а из них - часовые и дневные агрегаты закончившихся интервалов. Затем удаляет агрегаты старше
+// глубины хранения. Накопленные значения counter удаленных, вытесненных и сброшенных рядов забываются.
+// При ошибке чтения истории построенные агрегаты не отмечаются, и следующий запуск повторит интервал.
+func (c *Compactor) Compact(now time.Time) error {
+	from, to := c.Store.pending(now)
+	if to.After(from) {
+		metrics, err := c.Storage.ReadAllMetrics()
+		if err != nil {
+			return err
+		}
+		// Точки истории читаются по интервалу [from, to], а to уже относится к следующему построению.
+		last := to.Add(-time.Nanosecond)
+		type series struct {
+			mType, key string
+			samples    []storage.Sample
+		}
+		var read []series
+		counters := make(map[string]int64)
+		for _, m := range metrics {
+			if m.MType != "gauge" && m.MType != "counter" {
+				continue
+			}
+			samples, err := c.Storage.ReadHistory(m.MType, m.Key(), from, last, 0)
+			if err != nil {
+				return err
+			}
+			read = append(read, series{mType: m.MType, key: m.Key(), samples: samples})
+			if m.MType == "counter" && m.Delta != nil {
+				counters[seriesKey(m.MType, m.Key())] = *m.Delta
+			}
+		}
+		for _, s := range read {
+			c.Store.addSamples(s.mType, s.key, s.samples)
+		}
+		c.Store.pruneCounters(counters)
+	}
+	c.Store.advance(to, now)
+	if c.Path == "" {
+		return nil
+	}
+	return c.Store.Save(c.Path)
+}
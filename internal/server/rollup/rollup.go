@@ -0,0 +1,156 @@
+// Package rollup строит из истории значений метрик агрегаты с разрешением 1 минута, 1 час и 1 день
+// и хранит их с отдельной глубиной хранения для каждого разрешения. Для gauge агрегат содержит минимум,
+// максимум, среднее и последнее значение, для counter - прирост за интервал и скорость в секунду.
+// Минутные агрегаты строятся из точек истории хранилища, часовые - из минутных, дневные - из часовых.
+// Запрос интервала сам выбирает самое подробное разрешение, глубина хранения которого его покрывает.
+package rollup
+
+import (
+	"math"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// Разрешения агрегатов.
+const (
+	Minute = time.Minute
+	Hour   = time.Hour
+	Day    = 24 * time.Hour
+)
+
+// Resolutions поддерживаемые разрешения от подробного к грубому. Каждое следующее строится из предыдущего.
+var Resolutions = []time.Duration{Minute, Hour, Day}
+
+// Rollup агрегат ряда метрики за интервал [Time, Time + разрешение). У gauge заполнены Min, Max, Avg и Last,
+// у counter - Delta, Sum и Rate.
+type Rollup struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+	Min   *float64  `json:"min,omitempty"`
+	Max   *float64  `json:"max,omitempty"`
+	Avg   *float64  `json:"avg,omitempty"`
+	Last  *float64  `json:"last,omitempty"`
+	Delta *int64    `json:"delta,omitempty"` // накопленное значение counter на конец интервала
+	Sum   *int64    `json:"sum,omitempty"`   // прирост counter за интервал
+	Rate  *float64  `json:"rate,omitempty"`  // прирост counter в секунду
+}
+
+// fromSamples строит агрегат интервала [t, t + res) из упорядоченных по времени точек истории.
+// Прирост counter считается от накопленного значения prev, уменьшение значения считается сбросом
+// counter в ноль. Если prev равен nil, прирост считается от первой точки. Возвращает агрегат и
+// накопленное значение counter на конец интервала.
+func fromSamples(mType string, t time.Time, res time.Duration, samples []storage.Sample, prev *int64) (Rollup, *int64) {
+	r := Rollup{Time: t}
+	switch mType {
+	case "gauge":
+		var sum float64
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, s := range samples {
+			if s.Value == nil {
+				continue
+			}
+			v := *s.Value
+			r.Count++
+			sum += v
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+			r.Last = &v
+		}
+		if r.Count == 0 {
+			return r, prev
+		}
+		avg := sum / float64(r.Count)
+		r.Min, r.Max, r.Avg = &min, &max, &avg
+	case "counter":
+		var increase int64
+		for _, s := range samples {
+			if s.Delta == nil {
+				continue
+			}
+			d := *s.Delta
+			r.Count++
+			switch {
+			case prev == nil:
+			case d >= *prev:
+				increase += d - *prev
+			default:
+				increase += d
+			}
+			prev = &d
+		}
+		if r.Count == 0 {
+			return r, prev
+		}
+		r.Delta = prev
+		r.setIncrease(increase, res)
+	}
+	return r, prev
+}
+
+// setIncrease заполняет прирост counter и скорость его роста за интервал длительностью res.
+func (r *Rollup) setIncrease(increase int64, res time.Duration) {
+	rate := float64(increase) / res.Seconds()
+	r.Sum, r.Rate = &increase, &rate
+}
+
+// merge объединяет упорядоченные по времени агрегаты в один агрегат интервала [t, t + res).
+func merge(t time.Time, res time.Duration, parts []Rollup) Rollup {
+	r := Rollup{Time: t}
+	var (
+		sum      float64
+		increase int64
+		counter  bool
+	)
+	for _, p := range parts {
+		if p.Count == 0 {
+			continue
+		}
+		r.Count += p.Count
+		if p.Avg != nil {
+			sum += *p.Avg * float64(p.Count)
+			if r.Min == nil || *p.Min < *r.Min {
+				r.Min = p.Min
+			}
+			if r.Max == nil || *p.Max > *r.Max {
+				r.Max = p.Max
+			}
+			r.Last = p.Last
+		}
+		if p.Sum != nil {
+			counter = true
+			increase += *p.Sum
+			r.Delta = p.Delta
+		}
+	}
+	if r.Min != nil {
+		avg := sum / float64(r.Count)
+		r.Avg = &avg
+	}
+	if counter {
+		r.setIncrease(increase, res)
+	}
+	return r
+}
+
+// group объединяет упорядоченные по времени агрегаты в интервалы длительностью step, отсчитываемые от from.
+func group(rollups []Rollup, from time.Time, step time.Duration) []Rollup {
+	result := []Rollup{}
+	var (
+		parts  []Rollup
+		bucket time.Time
+	)
+	for _, r := range rollups {
+		b := from.Add(r.Time.Sub(from) / step * step)
+		if len(parts) > 0 && !b.Equal(bucket) {
+			result = append(result, merge(bucket, step, parts))
+			parts = nil
+		}
+		bucket = b
+		parts = append(parts, r)
+	}
+	if len(parts) > 0 {
+		result = append(result, merge(bucket, step, parts))
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func gaugeSample(t time.Time, v float64) storage.Sample {
+	return storage.Sample{Time: t, Value: &v}
+}
+
+func counterSample(t time.Time, d int64) storage.Sample {
+	return storage.Sample{Time: t, Delta: &d}
+}
+
+func Test_fromSamples(t *testing.T) {
+	base := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	r, prev := fromSamples("gauge", base, Minute, []storage.Sample{
+		gaugeSample(base, 1), gaugeSample(base.Add(10*time.Second), 5), gaugeSample(base.Add(20*time.Second), 3),
+	}, nil)
+	assert.Equal(t, prev, nil)
+	assert.Equal(t, r.Count, int64(3))
+	assert.Equal(t, *r.Min, 1.0)
+	assert.Equal(t, *r.Max, 5.0)
+	assert.Equal(t, *r.Avg, 3.0)
+	assert.Equal(t, *r.Last, 3.0)
+	assert.Equal(t, r.Sum, nil)
+
+	tests := []struct {
+		name      string
+		prev      *int64
+		samples   []int64
+		wantSum   int64
+		wantDelta int64
+	}{
+		{name: "no previous", samples: []int64{2, 5, 9}, wantSum: 7, wantDelta: 9},
+		{name: "previous", prev: func() *int64 { d := int64(1); return &d }(), samples: []int64{2, 5}, wantSum: 4, wantDelta: 5},
+		{name: "reset", samples: []int64{2, 5, 0, 3}, wantSum: 6, wantDelta: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := []storage.Sample{}
+			for i, d := range tt.samples {
+				samples = append(samples, counterSample(base.Add(time.Duration(i)*time.Second), d))
+			}
+			r, prev := fromSamples("counter", base, Minute, samples, tt.prev)
+			assert.Equal(t, r.Count, int64(len(tt.samples)))
+			assert.Equal(t, *r.Sum, tt.wantSum)
+			assert.Equal(t, *r.Delta, tt.wantDelta)
+			assert.Equal(t, *prev, tt.wantDelta)
+			assert.Equal(t, *r.Rate, float64(tt.wantSum)/60)
+			assert.Equal(t, r.Avg, nil)
+		})
+	}
+}
+
+func Test_group(t *testing.T) {
+	base := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	var rollups []Rollup
+	for i, v := range []float64{4, 2, 6, 8} {
+		r, _ := fromSamples("gauge", base.Add(time.Duration(i)*Minute), Minute,
+			[]storage.Sample{gaugeSample(base.Add(time.Duration(i)*Minute), v)}, nil)
+		rollups = append(rollups, r)
+	}
+	got := group(rollups, base, 3*Minute)
+	assert.Equal(t, len(got), 2)
+	assert.Equal(t, got[0].Time, base)
+	assert.Equal(t, got[0].Count, int64(3))
+	assert.Equal(t, *got[0].Min, 2.0)
+	assert.Equal(t, *got[0].Max, 6.0)
+	assert.Equal(t, *got[0].Avg, 4.0)
+	assert.Equal(t, *got[0].Last, 6.0)
+	assert.Equal(t, got[1].Time, base.Add(3*Minute))
+	assert.Equal(t, *got[1].Last, 8.0)
+
+	var counters []Rollup
+	prev := func() *int64 { d := int64(0); return &d }()
+	for i, d := range []int64{3, 10} {
+		var r Rollup
+		r, prev = fromSamples("counter", base.Add(time.Duration(i)*Minute), Minute,
+			[]storage.Sample{counterSample(base.Add(time.Duration(i)*Minute), d)}, prev)
+		counters = append(counters, r)
+	}
+	got = group(counters, base, Hour)
+	assert.Equal(t, len(got), 1)
+	assert.Equal(t, *got[0].Sum, int64(10))
+	assert.Equal(t, *got[0].Delta, int64(10))
+	assert.Equal(t, *got[0].Rate, 10.0/3600)
+}
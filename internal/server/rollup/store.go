@@ -0,0 +1,253 @@
+package rollup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// FileSuffix суффикс файла, в который сохраняются агрегаты рядом с основным файлом хранилища.
+const FileSuffix = ".rollups"
+
+// MaxPoints наибольшее количество агрегатов, которое отдает запрос без шага при автоматическом выборе
+// разрешения.
+const MaxPoints = 1500
+
+// level агрегаты рядов одного разрешения. Агрегаты всех интервалов, закончившихся до Compacted, уже построены.
+type level struct {
+	Resolution time.Duration       `json:"resolution"`
+	Retention  time.Duration       `json:"-"`
+	Compacted  time.Time           `json:"compacted"`
+	Series     map[string][]Rollup `json:"series"`
+}
+
+// storeFile содержимое файла агрегатов.
+type storeFile struct {
+	Levels   []*level         `json:"levels"`
+	Counters map[string]int64 `json:"counters"`
+}
+
+// Store хранилище агрегатов рядов метрик в памяти. Ряды хранятся по ключу тип:ключ ряда. Агрегаты,
+// начавшиеся раньше глубины хранения своего разрешения, удаляются при построении новых.
+type Store struct {
+	levels   []*level
+	counters map[string]int64 // накопленное значение counter, от которого считается следующий прирост
+	mutex    sync.RWMutex
+}
+
+// NewStore функция-конструктор для Store. retention задает глубину хранения для каждого разрешения
+// из Resolutions, 0 или отсутствие разрешения - хранить без ограничения.
+func NewStore(retention map[time.Duration]time.Duration) *Store {
+	s := &Store{counters: make(map[string]int64)}
+	for _, res := range Resolutions {
+		s.levels = append(s.levels, &level{
+			Resolution: res,
+			Retention:  retention[res],
+			Series:     make(map[string][]Rollup),
+		})
+	}
+	return s
+}
+
+func seriesKey(mType, key string) string {
+	return mType + ":" + key
+}
+
+// pending возвращает интервал [from, to), точки истории из которого еще не вошли в минутные агрегаты.
+// Если агрегаты еще не строились, интервал начинается с начала unix time.
+func (s *Store) pending(now time.Time) (time.Time, time.Time) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	from := s.levels[0].Compacted
+	if from.IsZero() {
+		from = time.Unix(0, 0)
+	}
+	return from, now.Truncate(s.levels[0].Resolution)
+}
+
+// addSamples строит минутные агрегаты ряда метрики из упорядоченных по времени точек истории.
+func (s *Store) addSamples(mType, key string, samples []storage.Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	sk := seriesKey(mType, key)
+	res := s.levels[0].Resolution
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var prev *int64
+	if d, ok := s.counters[sk]; ok {
+		prev = &d
+	}
+	start := 0
+	for i := 1; i <= len(samples); i++ {
+		bucket := samples[start].Time.Truncate(res)
+		if i < len(samples) && samples[i].Time.Truncate(res).Equal(bucket) {
+			continue
+		}
+		var r Rollup
+		r, prev = fromSamples(mType, bucket, res, samples[start:i], prev)
+		if r.Count > 0 {
+			s.levels[0].Series[sk] = append(s.levels[0].Series[sk], r)
+		}
+		start = i
+	}
+	if prev != nil {
+		s.counters[sk] = *prev
+	}
+}
+
+// pruneCounters удаляет накопленные значения counter, от которых считается прирост, для рядов, которых
+// больше нет в хранилище (ряд удален или вытеснен как устаревший), и для рядов, текущее значение которых
+// current меньше накопленного, то есть counter был сброшен без точки в истории. Иначе прирост
+// пересозданного или сброшенного ряда считался бы от значения, которого у него уже нет.
+func (s *Store) pruneCounters(current map[string]int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for sk, prev := range s.counters {
+		if d, ok := current[sk]; !ok || d < prev {
+			delete(s.counters, sk)
+		}
+	}
+}
+
+// advance отмечает, что точки истории до to вошли в минутные агрегаты, строит из них агрегаты более
+// грубых разрешений для закончившихся интервалов и удаляет агрегаты старше глубины хранения на момент now.
+func (s *Store) advance(to, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.levels[0].Compacted = to
+	for i := 1; i < len(s.levels); i++ {
+		lower, l := s.levels[i-1], s.levels[i]
+		end := lower.Compacted.Truncate(l.Resolution)
+		for sk, rollups := range lower.Series {
+			var parts []Rollup
+			for _, r := range rollups {
+				if !r.Time.Before(l.Compacted) && r.Time.Before(end) {
+					parts = append(parts, r)
+				}
+			}
+			if len(parts) > 0 {
+				l.Series[sk] = append(l.Series[sk], group(parts, time.Unix(0, 0), l.Resolution)...)
+			}
+		}
+		l.Compacted = end
+	}
+	for _, l := range s.levels {
+		if l.Retention <= 0 {
+			continue
+		}
+		border := now.Add(-l.Retention)
+		for sk, rollups := range l.Series {
+			i := 0
+			for i < len(rollups) && rollups[i].Time.Before(border) {
+				i++
+			}
+			if i == len(rollups) {
+				delete(l.Series, sk)
+				continue
+			}
+			l.Series[sk] = rollups[i:]
+		}
+	}
+}
+
+// Resolution выбирает разрешение для запроса интервала [from, to] с шагом step на момент now. Из разрешений,
+// глубина хранения которых покрывает from, берется самое грубое не крупнее step, а без шага - самое
+// подробное, дающее не больше MaxPoints агрегатов. Если from не покрывает ни одно разрешение,
+// возвращается самое грубое.
+func (s *Store) Resolution(from, to time.Time, step time.Duration, now time.Time) time.Duration {
+	var chosen time.Duration
+	for _, l := range s.levels {
+		if l.Retention > 0 && from.Before(now.Add(-l.Retention)) {
+			continue
+		}
+		if step > 0 {
+			if l.Resolution > step && chosen != 0 {
+				break
+			}
+			chosen = l.Resolution
+			continue
+		}
+		chosen = l.Resolution
+		if int64(to.Sub(from)/l.Resolution) <= MaxPoints {
+			break
+		}
+	}
+	if chosen == 0 {
+		chosen = s.levels[len(s.levels)-1].Resolution
+	}
+	return chosen
+}
+
+// Query возвращает агрегаты ряда метрики с разрешением res, начавшиеся в интервале [from, to]. Если step
+// больше разрешения, агрегаты объединяются в интервалы длительностью step, отсчитываемые от from.
+func (s *Store) Query(mType, key string, res time.Duration, from, to time.Time, step time.Duration) ([]Rollup, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, l := range s.levels {
+		if l.Resolution != res {
+			continue
+		}
+		rollups := []Rollup{}
+		for _, r := range l.Series[seriesKey(mType, key)] {
+			if r.Time.Before(from) || r.Time.After(to) {
+				continue
+			}
+			rollups = append(rollups, r)
+		}
+		if step > res {
+			return group(rollups, from, step), nil
+		}
+		return rollups, nil
+	}
+	return nil, fmt.Errorf("unsupported rollup resolution %v", res)
+}
+
+// Save атомарно сохраняет агрегаты в файл по указанному пути в формате json, так что сбой во время записи
+// не оставляет поврежденный файл.
+func (s *Store) Save(path string) error {
+	s.mutex.RLock()
+	data, err := json.Marshal(storeFile{Levels: s.levels, Counters: s.counters})
+	s.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(path, data)
+}
+
+// Load заполняет хранилище агрегатами из файла по указанному пути. Глубина хранения остается заданной
+// в NewStore. Отсутствие файла ошибкой не считается.
+func (s *Store) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file storeFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, loaded := range file.Levels {
+		for _, l := range s.levels {
+			if l.Resolution == loaded.Resolution && loaded.Series != nil {
+				l.Compacted = loaded.Compacted
+				l.Series = loaded.Series
+			}
+		}
+	}
+	if file.Counters != nil {
+		s.counters = file.Counters
+	}
+	return nil
+}
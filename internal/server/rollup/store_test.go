@@ -0,0 +1,161 @@
+package rollup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// newTestStorage создает хранилище в памяти с историей gauge Alloc и counter PollCount, записанной
+// в первые две минуты и на второй час после base.
+func newTestStorage(base time.Time) *storage.MemoryStorage {
+	st := &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{"Alloc": 7},
+		CounterMetrics: map[string]int64{"PollCount": 4},
+		History:        storage.NewHistory(0),
+	}
+	for _, s := range []struct {
+		offset time.Duration
+		value  float64
+	}{{10 * time.Second, 1}, {20 * time.Second, 3}, {70 * time.Second, 5}, {2 * time.Hour, 7}} {
+		v := s.value
+		st.History.Record("gauge", "Alloc", &v, nil, base.Add(s.offset))
+	}
+	for _, s := range []struct {
+		offset time.Duration
+		delta  int64
+	}{{10 * time.Second, 2}, {30 * time.Second, 5}, {80 * time.Second, 1}, {90 * time.Second, 4}} {
+		d := s.delta
+		st.History.Record("counter", "PollCount", nil, &d, base.Add(s.offset))
+	}
+	return st
+}
+
+func TestCompactor_Compact(t *testing.T) {
+	base := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	store := NewStore(map[time.Duration]time.Duration{Minute: 24 * time.Hour})
+	path := filepath.Join(t.TempDir(), "metrics.json"+FileSuffix)
+	c := NewCompactor(newTestStorage(base), store, path)
+
+	assert.Equal(t, c.Compact(base.Add(2*time.Hour+30*time.Minute)), nil)
+	minutes, err := store.Query("gauge", "Alloc", Minute, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(minutes), 3)
+	assert.Equal(t, minutes[0].Count, int64(2))
+	assert.Equal(t, *minutes[0].Avg, 2.0)
+	assert.Equal(t, *minutes[2].Last, 7.0)
+	counters, err := store.Query("counter", "PollCount", Minute, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(counters), 2)
+	assert.Equal(t, *counters[0].Sum, int64(3))
+	assert.Equal(t, *counters[1].Sum, int64(4))
+
+	// Час, в котором компактор еще не закончил минуты, не строится.
+	hours, err := store.Query("gauge", "Alloc", Hour, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(hours), 1)
+	assert.Equal(t, hours[0].Count, int64(3))
+	assert.Equal(t, *hours[0].Min, 1.0)
+	assert.Equal(t, *hours[0].Max, 5.0)
+	assert.Equal(t, *hours[0].Avg, 3.0)
+	hours, err = store.Query("counter", "PollCount", Hour, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *hours[0].Sum, int64(7))
+	assert.Equal(t, *hours[0].Rate, 7.0/3600)
+
+	grouped, err := store.Query("gauge", "Alloc", Minute, base, base.Add(3*time.Hour), Hour)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(grouped), 2)
+	assert.Equal(t, grouped[0].Count, int64(3))
+
+	// Повторное построение не дублирует агрегаты, минуты старше глубины хранения удаляются.
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, c.Compact(base.Add(25*time.Hour)), nil)
+	}
+	minutes, err = store.Query("gauge", "Alloc", Minute, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(minutes), 1)
+	hours, err = store.Query("gauge", "Alloc", Hour, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(hours), 2)
+	days, err := store.Query("gauge", "Alloc", Day, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(days), 1)
+	assert.Equal(t, days[0].Count, int64(4))
+	assert.Equal(t, *days[0].Avg, 4.0)
+	assert.Equal(t, *days[0].Last, 7.0)
+	days, err = store.Query("counter", "PollCount", Day, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *days[0].Sum, int64(7))
+
+	restored := NewStore(nil)
+	assert.Equal(t, restored.Load(path), nil)
+	got, err := restored.Query("gauge", "Alloc", Day, base, base.Add(3*time.Hour), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *got[0].Avg, 4.0)
+	assert.Equal(t, restored.Load(filepath.Join(t.TempDir(), "missing")), nil)
+
+	_, err = store.Query("gauge", "Alloc", 5*time.Minute, base, base.Add(3*time.Hour), 0)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestCompactor_PruneCounters(t *testing.T) {
+	base := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	st := newTestStorage(base)
+	d := int64(10)
+	st.CounterMetrics["Requests"] = d
+	st.History.Record("counter", "Requests", nil, &d, base.Add(10*time.Second))
+	store := NewStore(nil)
+	c := NewCompactor(st, store, "")
+	assert.Equal(t, c.Compact(base.Add(2*time.Minute)), nil)
+	assert.Equal(t, store.counters, map[string]int64{"counter:PollCount": 4, "counter:Requests": 10})
+
+	// Удаленный ряд забывается, а counter, сброшенный без точки в истории, считается заново.
+	deleted, err := st.DeleteMetrics([]storage.Metrics{{ID: "Requests", MType: "counter"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, 1)
+	st.CounterMetrics["PollCount"] = 1
+	assert.Equal(t, c.Compact(base.Add(3*time.Minute)), nil)
+	assert.Equal(t, store.counters, map[string]int64{})
+}
+
+func TestCompactor_NoHistory(t *testing.T) {
+	st := &storage.MemoryStorage{GaugeMetrics: map[string]float64{"Alloc": 1}, CounterMetrics: map[string]int64{}}
+	store := NewStore(nil)
+	now := time.Now()
+	assert.NotEqual(t, NewCompactor(st, store, "").Compact(now), nil)
+	from, _ := store.pending(now)
+	assert.Equal(t, from, time.Unix(0, 0))
+}
+
+func TestStore_Resolution(t *testing.T) {
+	store := NewStore(map[time.Duration]time.Duration{Minute: 24 * time.Hour, Hour: 30 * Day})
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		from time.Duration
+		step time.Duration
+		want time.Duration
+	}{
+		{name: "last hour", from: time.Hour, want: Minute},
+		{name: "last day", from: 23 * time.Hour, want: Minute},
+		{name: "beyond minutes", from: 2 * Day, want: Hour},
+		{name: "beyond hours", from: 60 * Day, want: Day},
+		{name: "step finer than minute", from: time.Hour, step: 10 * time.Second, want: Minute},
+		{name: "5m step", from: time.Hour, step: 5 * time.Minute, want: Minute},
+		{name: "2h step", from: time.Hour, step: 2 * time.Hour, want: Hour},
+		{name: "step finer than covering", from: 2 * Day, step: 30 * time.Second, want: Hour},
+		{name: "week step", from: 2 * Day, step: 7 * Day, want: Day},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, store.Resolution(now.Add(-tt.from), now, tt.step, now), tt.want)
+		})
+	}
+	unlimited := NewStore(nil)
+	assert.Equal(t, unlimited.Resolution(now.Add(-2*Day), now, 0, now), Hour)
+}
@@ -0,0 +1,68 @@
+// Package staleness реализует очистку рядов метрик, которые перестали обновляться, например gauge
+// выведенных из работы агентов. Ряды, не обновлявшиеся дольше TTL, помечаются устаревшими и скрываются
+// из списков метрик, а в режиме Evict удаляются из хранилища вместе с историей.
+package staleness
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// Sweeper периодически помечает или удаляет ряды метрик, не обновлявшиеся дольше TTL.
+type Sweeper struct {
+	Storage storage.IStorage
+	TTL     time.Duration
+	Evict   bool
+}
+
+// NewSweeper функция-конструктор для Sweeper.
+func NewSweeper(st storage.IStorage, ttl time.Duration, evict bool) *Sweeper {
+	return &Sweeper{
+		Storage: st,
+		TTL:     ttl,
+		Evict:   evict,
+	}
+}
+
+// Interval возвращает интервал запуска очистки: половина TTL, чтобы ряд не оставался
+// в списках дольше полутора TTL.
+func (s *Sweeper) Interval() time.Duration {
+	return s.TTL / 2
+}
+
+// Run выполняет очистку с интервалом Interval до ctx.Done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			_, err := s.Sweep(now)
+			if err != nil {
+				log.Println("Stale metrics sweep: ", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep помечает устаревшими или удаляет ряды, не обновлявшиеся с момента now - TTL, и возвращает
+// их количество.
+func (s *Sweeper) Sweep(now time.Time) (int, error) {
+	swept, err := s.Storage.SweepStale(now.Add(-s.TTL), s.Evict)
+	if err != nil {
+		return 0, err
+	}
+	if len(swept) > 0 {
+		action := "marked stale"
+		if s.Evict {
+			action = "evicted"
+		}
+		log.Println("Stale metrics sweep:", len(swept), "series", action)
+	}
+	return len(swept), nil
+}
@@ -0,0 +1,76 @@
+package staleness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestSweeper_Sweep(t *testing.T) {
+	tests := []struct {
+		name      string
+		evict     bool
+		wantSwept []int
+		wantLeft  int
+	}{
+		{name: "mark", wantSwept: []int{2, 0}, wantLeft: 2},
+		{name: "evict", evict: true, wantSwept: []int{2, 0}, wantLeft: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &storage.MemoryStorage{GaugeMetrics: map[string]float64{}, CounterMetrics: map[string]int64{}}
+			v, d := 1.5, int64(1)
+			assert.Equal(t, st.InsertBatchMetric([]storage.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: &v},
+				{ID: "PollCount", MType: "counter", Delta: &d},
+			}), nil)
+			s := NewSweeper(st, time.Minute, tt.evict)
+
+			swept, err := s.Sweep(time.Now())
+			assert.Equal(t, err, nil)
+			assert.Equal(t, swept, 0)
+			for _, want := range tt.wantSwept {
+				swept, err = s.Sweep(time.Now().Add(2 * time.Minute))
+				assert.Equal(t, err, nil)
+				assert.Equal(t, swept, want)
+			}
+			all, err := st.ReadAllMetrics()
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(all), tt.wantLeft)
+			for _, m := range all {
+				assert.Equal(t, m.Stale, true)
+			}
+		})
+	}
+}
+
+func TestSweeper_Run(t *testing.T) {
+	st := &storage.MemoryStorage{GaugeMetrics: map[string]float64{}, CounterMetrics: map[string]int64{}}
+	v := 1.5
+	assert.Equal(t, st.InsertMetric(&storage.Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+	s := NewSweeper(st, 10*time.Millisecond, true)
+	assert.Equal(t, s.Interval(), 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if all, _ := st.ReadAllMetrics(); len(all) == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	all, err := st.ReadAllMetrics()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(all), 0)
+}
@@ -0,0 +1,277 @@
+// Package statsd реализует прием метрик в формате StatsD по UDP и их запись в хранилище сервера.
+// Поддерживаются counter (c) с учетом частоты семплирования, gauge (g) в том числе с относительными
+// изменениями +/-, и таймеры (ms, h), которые агрегируются за интервал и сохраняются производными gauge.
+// Теги DogStatsD сохраняются метками ряда.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// DefaultFlushInterval интервал агрегации таймеров по умолчанию.
+const DefaultFlushInterval = 10 * time.Second
+
+// maxPacketSize максимальный размер UDP пакета.
+const maxPacketSize = 65535
+
+var errFormat = errors.New("statsd: wrong line format")
+
+// Line разобранная строка протокола StatsD вида name:value|type[|@rate][|#tag:value,...].
+type Line struct {
+	Name       string
+	Type       string
+	Value      float64
+	SampleRate float64
+	Relative   bool              // значение gauge начинается со знака и изменяет текущее значение
+	Labels     map[string]string // теги DogStatsD
+}
+
+// ParseLine разбирает одну строку протокола StatsD. Теги в формате DogStatsD (|#tag:value,tag) становятся
+// метками ряда, тег без значения - меткой с пустым значением. Названия меток проверяются так же, как
+// метки агентов.
+func ParseLine(line string) (Line, error) {
+	l := Line{SampleRate: 1}
+	sep := strings.Index(line, ":")
+	if sep <= 0 {
+		return l, errFormat
+	}
+	l.Name = line[:sep]
+	parts := strings.Split(line[sep+1:], "|")
+	if len(parts) < 2 {
+		return l, errFormat
+	}
+	l.Type = parts[1]
+	switch l.Type {
+	case "c", "g", "ms", "h":
+	default:
+		return l, fmt.Errorf("statsd: unsupported type %q", l.Type)
+	}
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return l, fmt.Errorf("statsd: wrong value %q", parts[0])
+	}
+	l.Value = value
+	l.Relative = l.Type == "g" && (strings.HasPrefix(parts[0], "+") || strings.HasPrefix(parts[0], "-"))
+	for _, part := range parts[2:] {
+		if strings.HasPrefix(part, "#") {
+			l.Labels, err = parseTags(part[1:])
+			if err != nil {
+				return l, err
+			}
+			continue
+		}
+		if !strings.HasPrefix(part, "@") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(part[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return l, fmt.Errorf("statsd: wrong sample rate %q", part)
+		}
+		l.SampleRate = rate
+	}
+	return l, nil
+}
+
+// parseTags разбирает теги DogStatsD вида tag:value,tag в метки.
+func parseTags(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(tag, ":")
+		labels[name] = value
+	}
+	err := storage.ValidateLabels(labels)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
+
+// timer накопленные за интервал значения таймера ряда и их количество с учетом семплирования.
+type timer struct {
+	name   string
+	labels map[string]string
+	values []float64
+	count  float64
+}
+
+// Listener принимает пакеты StatsD и записывает метрики в хранилище. При необходимости запись
+// дублируется в файл через FileStorage.
+type Listener struct {
+	Storage       storage.IStorage
+	FileStorage   *storage.FileStorage
+	FlushInterval time.Duration
+	timers        map[string]*timer
+	mutex         sync.Mutex
+}
+
+// NewListener функция-конструктор для Listener с интервалом агрегации таймеров по умолчанию.
+func NewListener(st storage.IStorage, fs *storage.FileStorage) *Listener {
+	return &Listener{
+		Storage:       st,
+		FileStorage:   fs,
+		FlushInterval: DefaultFlushInterval,
+		timers:        make(map[string]*timer),
+	}
+}
+
+// ListenAndServe открывает UDP сокет по адресу addr и принимает пакеты до ctx.Done.
+func (l *Listener) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ctx, conn)
+}
+
+// Serve принимает пакеты из conn до ctx.Done, после чего закрывает соединение и сбрасывает
+// накопленные таймеры в хранилище.
+func (l *Listener) Serve(ctx context.Context, conn net.PacketConn) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(l.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Flush()
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				l.Flush()
+				return nil
+			}
+			return err
+		}
+		for _, err := range l.HandlePacket(buf[:n]) {
+			log.Println(err)
+		}
+	}
+}
+
+// HandlePacket разбирает пакет, который может содержать несколько строк, и применяет каждую из них.
+// Возвращает ошибки для строк, которые не удалось разобрать или сохранить.
+func (l *Listener) HandlePacket(packet []byte) []error {
+	var errs []error
+	updated := false
+	for _, raw := range strings.Split(string(packet), "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		line, err := ParseLine(raw)
+		if err == nil {
+			err = l.apply(line)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w; line: %s", err, raw))
+			continue
+		}
+		updated = true
+	}
+	if updated && l.FileStorage != nil && l.FileStorage.Synchronize {
+		err := l.FileStorage.SaveStorageToFile(l.Storage)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// apply записывает разобранную строку в хранилище или накапливает значение таймера.
+func (l *Listener) apply(line Line) error {
+	switch line.Type {
+	case "c":
+		delta := int64(math.Round(line.Value / line.SampleRate))
+		return l.Storage.InsertMetric(&storage.Metrics{ID: line.Name, MType: "counter", Labels: line.Labels, Delta: &delta})
+	case "g":
+		value := line.Value
+		if line.Relative {
+			current, err := l.Storage.ReadMetric(&storage.Metrics{ID: line.Name, MType: "gauge", Labels: line.Labels})
+			if err == nil && current.Value != nil {
+				value += *current.Value
+			}
+		}
+		return l.Storage.InsertMetric(&storage.Metrics{ID: line.Name, MType: "gauge", Labels: line.Labels, Value: &value})
+	default:
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		key := storage.SeriesKey(line.Name, line.Labels)
+		t, ok := l.timers[key]
+		if !ok {
+			t = &timer{name: line.Name, labels: line.Labels}
+			l.timers[key] = t
+		}
+		t.values = append(t.values, line.Value)
+		t.count += 1 / line.SampleRate
+	}
+	return nil
+}
+
+// Flush сохраняет накопленные за интервал таймеры в виде gauge с суффиксами .count, .min, .max,
+// .mean и .p90, после чего очищает их.
+func (l *Listener) Flush() {
+	l.mutex.Lock()
+	timers := l.timers
+	l.timers = make(map[string]*timer)
+	l.mutex.Unlock()
+	if len(timers) == 0 {
+		return
+	}
+	metricsBatch := []storage.Metrics{}
+	for _, t := range timers {
+		for suffix, value := range t.stats() {
+			v := value
+			metricsBatch = append(metricsBatch, storage.Metrics{ID: t.name + suffix, MType: "gauge", Labels: t.labels, Value: &v})
+		}
+	}
+	err := l.Storage.InsertBatchMetric(metricsBatch)
+	if err != nil {
+		log.Println("Statsd timers flush failed", err)
+	}
+}
+
+// stats считает производные значения таймера.
+func (t *timer) stats() map[string]float64 {
+	sort.Float64s(t.values)
+	sum := 0.0
+	for _, v := range t.values {
+		sum += v
+	}
+	n := len(t.values)
+	return map[string]float64{
+		".count": t.count,
+		".min":   t.values[0],
+		".max":   t.values[n-1],
+		".mean":  sum / float64(n),
+		".p90":   t.values[int(math.Ceil(0.9*float64(n)))-1],
+	}
+}
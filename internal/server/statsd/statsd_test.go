@@ -0,0 +1,156 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func newMemoryStorage() *storage.MemoryStorage {
+	return &storage.MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Line
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			line: "requests:1|c",
+			want: Line{Name: "requests", Type: "c", Value: 1, SampleRate: 1},
+		},
+		{
+			name: "counter with rate and tags",
+			line: "requests:2|c|@0.5|#env:prod,canary",
+			want: Line{Name: "requests", Type: "c", Value: 2, SampleRate: 0.5,
+				Labels: map[string]string{"env": "prod", "canary": ""}},
+		},
+		{
+			name:    "bad tag",
+			line:    "requests:2|c|#env-name:prod",
+			wantErr: true,
+		},
+		{
+			name: "relative gauge",
+			line: "queue:-3|g",
+			want: Line{Name: "queue", Type: "g", Value: -3, SampleRate: 1, Relative: true},
+		},
+		{
+			name: "timer",
+			line: "latency:320|ms",
+			want: Line{Name: "latency", Type: "ms", Value: 320, SampleRate: 1},
+		},
+		{
+			name:    "no value",
+			line:    "requests",
+			wantErr: true,
+		},
+		{
+			name:    "no type",
+			line:    "requests:1",
+			wantErr: true,
+		},
+		{
+			name:    "set type",
+			line:    "users:42|s",
+			wantErr: true,
+		},
+		{
+			name:    "bad value",
+			line:    "requests:one|c",
+			wantErr: true,
+		},
+		{
+			name:    "bad rate",
+			line:    "requests:1|c|@2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_HandlePacket(t *testing.T) {
+	st := newMemoryStorage()
+	l := NewListener(st, &storage.FileStorage{})
+	errs := l.HandlePacket([]byte("requests:1|c|@0.1\nqueue:10|g\nqueue:+5|g\nqueue:-3|g\nbroken\n" +
+		"latency:10|ms\nlatency:30|ms\nlatency:20|ms|@0.5"))
+	assert.Equal(t, len(errs), 1)
+	assert.Equal(t, st.CounterMetrics["requests"], int64(10))
+	assert.Equal(t, st.GaugeMetrics["queue"], float64(12))
+	l.Flush()
+	assert.Equal(t, st.GaugeMetrics["latency.count"], float64(4))
+	assert.Equal(t, st.GaugeMetrics["latency.min"], float64(10))
+	assert.Equal(t, st.GaugeMetrics["latency.max"], float64(30))
+	assert.Equal(t, st.GaugeMetrics["latency.mean"], float64(20))
+	assert.Equal(t, st.GaugeMetrics["latency.p90"], float64(30))
+}
+
+func TestListener_HandlePacketTags(t *testing.T) {
+	st := newMemoryStorage()
+	l := NewListener(st, &storage.FileStorage{})
+	errs := l.HandlePacket([]byte("requests:1|c|#host:a\nrequests:2|c|#host:b\nqueue:10|g|#host:a\n" +
+		"queue:+5|g|#host:a\nqueue:7|g|#host:b\nlatency:10|ms|#host:a\nlatency:30|ms|#host:b"))
+	assert.Equal(t, len(errs), 0)
+	l.Flush()
+	assert.Equal(t, st.CounterMetrics, map[string]int64{`requests{host="a"}`: 1, `requests{host="b"}`: 2})
+	assert.Equal(t, st.GaugeMetrics[`queue{host="a"}`], float64(15))
+	assert.Equal(t, st.GaugeMetrics[`queue{host="b"}`], float64(7))
+	assert.Equal(t, st.GaugeMetrics[`latency.max{host="a"}`], float64(10))
+	assert.Equal(t, st.GaugeMetrics[`latency.max{host="b"}`], float64(30))
+}
+
+func TestListener_Serve(t *testing.T) {
+	st := newMemoryStorage()
+	l := NewListener(st, &storage.FileStorage{})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Serve(ctx, conn)
+	}()
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Write([]byte("requests:3|c\nlatency:15|ms"))
+	if err != nil {
+		t.Error(err)
+	}
+	<-time.NewTimer(100 * time.Millisecond).C
+	cancel()
+	select {
+	case <-time.NewTimer(500 * time.Millisecond).C:
+		t.Error("Listener timeout error")
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	assert.Equal(t, st.CounterMetrics["requests"], int64(3))
+	assert.Equal(t, st.GaugeMetrics["latency.max"], float64(15))
+}
@@ -4,23 +4,54 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"log"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage/migrations"
 )
 
+// DefaultMaxConnections размер пула подключений к БД по умолчанию.
+const DefaultMaxConnections = 10
+
+// dbPruneInterval как часто из истории в БД удаляются устаревшие точки.
+const dbPruneInterval = time.Minute
+
+// Connection общие методы одиночного подключения *pgx.Conn и пула подключений *pgx.ConnPool, которые
+// использует DBStorage.
+type Connection interface {
+	Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error)
+	ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error)
+	QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*pgx.Rows, error)
+	QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row
+	BeginEx(ctx context.Context, txOptions *pgx.TxOptions) (*pgx.Tx, error)
+}
+
+// dbExecutor методы, общие для Connection и транзакции *pgx.Tx, которыми выполняется слияние гистограмм.
+type dbExecutor interface {
+	ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error)
+	QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row
+}
+
 // DBStorage - структура реализующая интерфейс IStorage, которая содержит в себе подключение к БД, конфигурацию этого подключения
-// и переданный ей контекст.
+// и переданный ей контекст. DBConnectStorage создает пул из MaxConnections подключений, которые обработчики используют
+// параллельно. Если Retention больше нуля, точки истории старше Retention удаляются после записи новых.
 type DBStorage struct {
-	Context    context.Context
-	Connection *pgx.Conn
-	ConnConfig pgx.ConnConfig
+	Context        context.Context
+	Connection     Connection
+	ConnConfig     pgx.ConnConfig
+	MaxConnections int
+	Retention      time.Duration
+	lastPrune      time.Time
+	mutex          sync.Mutex
 }
 
 // InsertMetric исполняет sql запрос к бд добавляющий или обновляющий (при конфликте) значение метрики типа gauge
-// или counter. Данные метрики получаются из аргумента - структуры Metrics.
+// или counter. Гистограмма складывается с сохраненной в отдельной транзакции. Данные метрики получаются из аргумента - структуры Metrics, ряд определяется названием и метками.
 func (d *DBStorage) InsertMetric(m *Metrics) error {
 	if d.Connection == nil {
 		return errNoDB
@@ -28,41 +59,141 @@ func (d *DBStorage) InsertMetric(m *Metrics) error {
 	switch m.MType {
 	case "gauge":
 		_, err := d.Connection.ExecEx(d.Context,
-			`INSERT INTO rt_metrics (id, mtype, value, hash)
-				VALUES ($1, $2, $3, $4)
-				ON CONFLICT (id) DO UPDATE
-				SET value = excluded.value, hash = excluded.hash;`,
-			nil, m.ID, m.MType, m.Value, m.Hash)
+			`WITH upd AS (
+				INSERT INTO rt_metrics (id, mtype, value, hash, labels)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (id, labels) DO UPDATE
+				SET value = excluded.value, hash = excluded.hash, updated_at = now(), stale = false
+				RETURNING id, mtype, labels, delta, value)
+			INSERT INTO rt_metrics_history (id, mtype, labels, delta, value)
+				SELECT id, mtype, labels, delta, value FROM upd;`,
+			nil, m.ID, m.MType, m.Value, m.Hash, FormatLabels(m.Labels))
 		if err != nil {
 			return err
 		}
+		d.prune(time.Now())
 	case "counter":
 		_, err := d.Connection.ExecEx(d.Context,
-			`INSERT INTO rt_metrics (id, mtype, delta, hash)
-				VALUES ($1, $2, $3, $4)
-				ON CONFLICT (id) DO UPDATE
-				SET delta = excluded.delta + rt_metrics.delta, hash = excluded.hash;`,
-			nil, m.ID, m.MType, m.Delta, m.Hash)
+			`WITH upd AS (
+				INSERT INTO rt_metrics (id, mtype, delta, hash, labels)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (id, labels) DO UPDATE
+				SET delta = excluded.delta + rt_metrics.delta, hash = excluded.hash, updated_at = now(), stale = false
+				RETURNING id, mtype, labels, delta, value)
+			INSERT INTO rt_metrics_history (id, mtype, labels, delta, value)
+				SELECT id, mtype, labels, delta, value FROM upd;`,
+			nil, m.ID, m.MType, m.Delta, m.Hash, FormatLabels(m.Labels))
+		if err != nil {
+			return err
+		}
+		d.prune(time.Now())
+	case "histogram":
+		tx, err := d.Connection.BeginEx(d.Context, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.RollbackEx(d.Context)
+		err = d.mergeHistogram(tx, m, false)
 		if err != nil {
 			return err
 		}
+		return tx.CommitEx(d.Context)
 	}
 	return nil
 }
 
-// ReadAllMetrics запрос всех метрик из базы, который возвращает список структур Metric
+// mergeHistogram складывает гистограмму метрики m с сохраненной в базе, а если replace - заменяет ее.
+// Строка метрики блокируется до конца транзакции, поэтому параллельные обновления не теряются. Истории
+// у гистограмм нет.
+func (d *DBStorage) mergeHistogram(db dbExecutor, m *Metrics, replace bool) error {
+	if m.Histogram == nil {
+		return errNoValue
+	}
+	err := m.Histogram.Validate()
+	if err != nil {
+		return err
+	}
+	labels := FormatLabels(m.Labels)
+	_, err = db.ExecEx(d.Context,
+		`INSERT INTO rt_metrics (id, mtype, labels) VALUES ($1, 'histogram', $2)
+			ON CONFLICT (id, labels) DO NOTHING;`,
+		nil, m.ID, labels)
+	if err != nil {
+		return err
+	}
+	var mType, stored string
+	err = db.QueryRowEx(d.Context,
+		`SELECT mtype, COALESCE(histogram::text, '') FROM rt_metrics WHERE id = $1 AND labels = $2 FOR UPDATE;`,
+		nil, m.ID, labels).Scan(&mType, &stored)
+	if err != nil {
+		return err
+	}
+	if mType != "histogram" {
+		return errWrType
+	}
+	merged := m.Histogram.Clone()
+	if stored != "" && !replace {
+		merged = &Histogram{}
+		err = json.Unmarshal([]byte(stored), merged)
+		if err != nil {
+			return err
+		}
+		err = merged.Merge(m.Histogram)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecEx(d.Context,
+		`UPDATE rt_metrics SET histogram = $3::jsonb, hash = $4, updated_at = now(), stale = false WHERE id = $1 AND labels = $2;`,
+		nil, m.ID, labels, string(data), m.Hash)
+	return err
+}
+
+// decodeHistogram разбирает гистограмму, сохраненную в базе, и добавляет к ней оценки квантилей.
+// Пустая строка означает, что у метрики нет гистограммы.
+func decodeHistogram(s string) (*Histogram, error) {
+	if s == "" {
+		return nil, nil
+	}
+	h := &Histogram{}
+	err := json.Unmarshal([]byte(s), h)
+	if err != nil {
+		return nil, err
+	}
+	return h.withQuantiles(), nil
+}
+
+// ReadAllMetrics запрос всех метрик из базы, который возвращает список структур Metric вместе со временем
+// их последнего обновления и признаком устаревания.
 func (d *DBStorage) ReadAllMetrics() ([]Metrics, error) {
 	if d.Connection == nil {
 		return nil, errNoDB
 	}
 	var metricsSlice []Metrics
-	rows, err := d.Connection.QueryEx(d.Context, "SELECT * FROM rt_metrics;", nil)
+	rows, err := d.Connection.QueryEx(d.Context,
+		`SELECT id, mtype, labels, delta, value, COALESCE(histogram::text, ''), hash, updated_at, stale
+			FROM rt_metrics;`, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		metric := Metrics{}
-		err = rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value, &metric.Hash)
+		var (
+			metric    Metrics
+			labels    string
+			histogram string
+		)
+		err = rows.Scan(&metric.ID, &metric.MType, &labels, &metric.Delta, &metric.Value, &histogram, &metric.Hash,
+			&metric.Updated, &metric.Stale)
+		if err != nil {
+			return nil, err
+		}
+		metric.Labels = decodeLabels(labels)
+		metric.Histogram, err = decodeHistogram(histogram)
 		if err != nil {
 			return nil, err
 		}
@@ -88,14 +219,19 @@ func (d *DBStorage) ParamsUpdate(metricType, metricID, metricValue string) (int,
 			return 400, err
 		}
 		_, err = d.Connection.ExecEx(d.Context,
-			`INSERT INTO rt_metrics (id, mtype, value)
+			`WITH upd AS (
+				INSERT INTO rt_metrics (id, mtype, value)
 				VALUES ($1, $2, $3)
-				ON CONFLICT (id) DO UPDATE
-				SET value = excluded.value;`,
+				ON CONFLICT (id, labels) DO UPDATE
+				SET value = excluded.value, updated_at = now(), stale = false
+				RETURNING id, mtype, labels, delta, value)
+			INSERT INTO rt_metrics_history (id, mtype, labels, delta, value)
+				SELECT id, mtype, labels, delta, value FROM upd;`,
 			nil, metricID, metricType, value)
 		if err != nil {
 			return 400, err
 		}
+		d.prune(time.Now())
 		return 200, nil
 	case "counter":
 		delta, err := strconv.Atoi(metricValue)
@@ -103,19 +239,129 @@ func (d *DBStorage) ParamsUpdate(metricType, metricID, metricValue string) (int,
 			return 400, err
 		}
 		_, err = d.Connection.ExecEx(d.Context,
-			`INSERT INTO rt_metrics (id, mtype, delta)
+			`WITH upd AS (
+				INSERT INTO rt_metrics (id, mtype, delta)
 				VALUES ($1, $2, $3)
-				ON CONFLICT (id) DO UPDATE
-				SET delta = excluded.delta + rt_metrics.delta;`,
+				ON CONFLICT (id, labels) DO UPDATE
+				SET delta = excluded.delta + rt_metrics.delta, updated_at = now(), stale = false
+				RETURNING id, mtype, labels, delta, value)
+			INSERT INTO rt_metrics_history (id, mtype, labels, delta, value)
+				SELECT id, mtype, labels, delta, value FROM upd;`,
 			nil, metricID, metricType, delta)
 		if err != nil {
 			return 400, err
 		}
+		d.prune(time.Now())
 		return 200, nil
 	}
 	return 501, errors.New("Wrong metric type - " + metricType)
 }
 
+// DeleteMetrics в одной транзакции удаляет из базы ряды метрик по типу, названию и меткам вместе с их историей.
+// Возвращает количество удаленных рядов.
+func (d *DBStorage) DeleteMetrics(metrics []Metrics) (int, error) {
+	if d.Connection == nil {
+		return 0, errNoDB
+	}
+	tx, err := d.Connection.BeginEx(d.Context, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.RollbackEx(d.Context)
+	deleted := 0
+	for _, m := range metrics {
+		labels := FormatLabels(m.Labels)
+		tag, err := tx.ExecEx(d.Context,
+			`DELETE FROM rt_metrics WHERE id = $1 AND mtype = $2 AND labels = $3;`,
+			nil, m.ID, m.MType, labels)
+		if err != nil {
+			return 0, err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		deleted++
+		_, err = tx.ExecEx(d.Context,
+			`DELETE FROM rt_metrics_history WHERE id = $1 AND mtype = $2 AND labels = $3;`,
+			nil, m.ID, m.MType, labels)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return deleted, tx.CommitEx(d.Context)
+}
+
+// ResetCounter обнуляет значение counter в базе и записывает его в историю. Если ряда нет, возвращает false.
+func (d *DBStorage) ResetCounter(m *Metrics) (bool, error) {
+	if d.Connection == nil {
+		return false, errNoDB
+	}
+	tag, err := d.Connection.ExecEx(d.Context,
+		`WITH upd AS (
+			UPDATE rt_metrics SET delta = 0, updated_at = now(), stale = false
+			WHERE id = $1 AND mtype = 'counter' AND labels = $2
+			RETURNING id, mtype, labels, delta, value)
+		INSERT INTO rt_metrics_history (id, mtype, labels, delta, value)
+			SELECT id, mtype, labels, delta, value FROM upd;`,
+		nil, m.ID, FormatLabels(m.Labels))
+	if err != nil {
+		return false, err
+	}
+	d.prune(time.Now())
+	return tag.RowsAffected() > 0, nil
+}
+
+// SweepStale в одной транзакции помечает устаревшими ряды, не обновлявшиеся с момента before, а если evict,
+// удаляет их вместе с историей. Возвращает ряды, которые были помечены или удалены этим вызовом.
+func (d *DBStorage) SweepStale(before time.Time, evict bool) ([]Metrics, error) {
+	if d.Connection == nil {
+		return nil, errNoDB
+	}
+	tx, err := d.Connection.BeginEx(d.Context, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackEx(d.Context)
+	query := `UPDATE rt_metrics SET stale = true WHERE updated_at < $1 AND NOT stale RETURNING id, mtype, labels;`
+	if evict {
+		query = `DELETE FROM rt_metrics WHERE updated_at < $1 RETURNING id, mtype, labels;`
+	}
+	rows, err := tx.QueryEx(d.Context, query, nil, before)
+	if err != nil {
+		return nil, err
+	}
+	swept := []Metrics{}
+	for rows.Next() {
+		var (
+			metric Metrics
+			labels string
+		)
+		err = rows.Scan(&metric.ID, &metric.MType, &labels)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		metric.Labels = decodeLabels(labels)
+		swept = append(swept, metric)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	if evict {
+		for _, m := range swept {
+			_, err = tx.ExecEx(d.Context,
+				`DELETE FROM rt_metrics_history WHERE id = $1 AND mtype = $2 AND labels = $3;`,
+				nil, m.ID, m.MType, FormatLabels(m.Labels))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return swept, tx.CommitEx(d.Context)
+}
+
 // SaveToFile читает все метрики из базы, а затем сохраняет их в файл (аргумент функции).
 func (d *DBStorage) SaveToFile(f *os.File) error {
 	metrics, err := d.ReadAllMetrics()
@@ -133,10 +379,11 @@ func (d *DBStorage) SaveToFile(f *os.File) error {
 	return nil
 }
 
-// UploadFromFile заполняет базу метрик значениями, полученными из файла.
+// UploadFromFile заполняет базу метрик значениями, полученными из файла, вместе со временем обновления
+// и признаком устаревания, см. restoreState.
 func (d *DBStorage) UploadFromFile(path string) error {
 	var metricsSlice []Metrics
-	data, err := ioutil.ReadFile(path)
+	data, err := readSnapshot(path)
 	if err != nil {
 		return err
 	}
@@ -153,60 +400,240 @@ func (d *DBStorage) UploadFromFile(path string) error {
 			}
 		case "counter":
 			_, err := d.Connection.ExecEx(d.Context,
-				`INSERT INTO rt_metrics (id, mtype, delta, hash)
-						VALUES ($1, $2, $3, $4)
-						ON CONFLICT (id) DO UPDATE
+				`INSERT INTO rt_metrics (id, mtype, delta, hash, labels)
+						VALUES ($1, $2, $3, $4, $5)
+						ON CONFLICT (id, labels) DO UPDATE
 						SET delta = excluded.delta, hash = excluded.hash;`,
-				nil, metric.ID, metric.MType, metric.Delta, metric.Hash)
+				nil, metric.ID, metric.MType, metric.Delta, metric.Hash, FormatLabels(metric.Labels))
+			if err != nil {
+				return err
+			}
+		case "histogram":
+			// Как и counter, гистограмма из файла заменяет сохраненную в базе.
+			err := d.mergeHistogram(d.Connection, &metric, true)
 			if err != nil {
 				return err
 			}
+		default:
+			continue
+		}
+		err = d.restoreState(&metric)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// restoreState записывает в строку метрики время обновления и признак устаревания из снимка. Если времени
+// в снимке нет, как в снимках предыдущих версий, ряд считается обновленным при загрузке, как в MemoryStorage.
+func (d *DBStorage) restoreState(m *Metrics) error {
+	_, err := d.Connection.ExecEx(d.Context,
+		`UPDATE rt_metrics SET updated_at = COALESCE($3::timestamptz, now()), stale = $4
+			WHERE id = $1 AND labels = $2;`,
+		nil, m.ID, FormatLabels(m.Labels), m.Updated, m.Stale)
+	return err
+}
+
 // ReadMetric sql запрос в базу для получения значения метрики, тип и название которой получены из структуры Metrics.
 func (d *DBStorage) ReadMetric(rm *Metrics) (*Metrics, error) {
 	// Read specific metric from db
+	var histogram string
 	row := d.Connection.QueryRowEx(d.Context,
-		`SELECT delta, value, hash FROM rt_metrics 
-			WHERE rt_metrics.id = $1 AND rt_metrics.mtype = $2`,
-		nil, rm.ID, rm.MType)
-	err := row.Scan(&rm.Delta, &rm.Value, &rm.Hash)
+		`SELECT delta, value, COALESCE(histogram::text, ''), hash FROM rt_metrics 
+			WHERE rt_metrics.id = $1 AND rt_metrics.mtype = $2 AND rt_metrics.labels = $3`,
+		nil, rm.ID, rm.MType, FormatLabels(rm.Labels))
+	err := row.Scan(&rm.Delta, &rm.Value, &histogram, &rm.Hash)
+	if err != nil {
+		return nil, err
+	}
+	rm.Histogram, err = decodeHistogram(histogram)
 	if err != nil {
 		return nil, err
 	}
 	return rm, nil
 }
 
-// InsertBatchMetric запросы на обновление метрик в цикле, полученных из списка []Metrics методом InsertMetric.
+// ReadHistory sql запрос в базу для получения истории значений ряда метрики с ключом key в интервале [from, to].
+// Полученный ряд прореживается с шагом step.
+func (d *DBStorage) ReadHistory(mType, key string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	if d.Connection == nil {
+		return nil, errNoDB
+	}
+	id, labels := ParseSeriesKey(key)
+	rows, err := d.Connection.QueryEx(d.Context,
+		`SELECT ts, delta, value FROM rt_metrics_history
+			WHERE id = $1 AND mtype = $2 AND labels = $3 AND ts BETWEEN $4 AND $5
+			ORDER BY ts;`,
+		nil, id, mType, FormatLabels(labels), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	samples := []Sample{}
+	for rows.Next() {
+		sample := Sample{}
+		err = rows.Scan(&sample.Time, &sample.Delta, &sample.Value)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return downsample(samples, from, step), nil
+}
+
+// InsertBatchMetric применяет список метрик в одной транзакции: по одному многострочному upsert для gauge и для
+// counter. Перед записью список сводится так, чтобы каждая метрика встречалась один раз: для gauge остается последнее
+// значение, приросты counter суммируются. Гистограммы складываются с сохраненными по одной. При любой ошибке транзакция откатывается и база остается без изменений.
 func (d *DBStorage) InsertBatchMetric(metrics []Metrics) error {
-	for _, metric := range metrics {
-		err := d.InsertMetric(&metric)
+	if d.Connection == nil {
+		return errNoDB
+	}
+	gauges, counters := aggregateBatch(metrics)
+	histograms := []Metrics{}
+	for _, m := range metrics {
+		if m.MType == "histogram" && m.Histogram != nil {
+			histograms = append(histograms, m)
+		}
+	}
+	if len(gauges.ids) == 0 && len(counters.ids) == 0 && len(histograms) == 0 {
+		return nil
+	}
+	tx, err := d.Connection.BeginEx(d.Context, nil)
+	if err != nil {
+		return err
+	}
+	// После успешного Commit откат ничего не делает.
+	defer tx.RollbackEx(d.Context)
+	if len(gauges.ids) != 0 {
+		_, err = tx.ExecEx(d.Context,
+			`WITH upd AS (
+				INSERT INTO rt_metrics (id, mtype, value, hash, labels)
+				SELECT b.id, 'gauge', b.value, b.hash, b.labels
+					FROM unnest($1::text[], $2::double precision[], $3::text[], $4::text[]) AS b(id, value, hash, labels)
+				ON CONFLICT (id, labels) DO UPDATE
+				SET value = excluded.value, hash = excluded.hash, updated_at = now(), stale = false
+				RETURNING id, mtype, labels, delta, value)
+			INSERT INTO rt_metrics_history (id, mtype, labels, delta, value)
+				SELECT id, mtype, labels, delta, value FROM upd;`,
+			nil, gauges.ids, gauges.values, gauges.hashes, gauges.labels)
 		if err != nil {
 			return err
 		}
 	}
+	if len(counters.ids) != 0 {
+		_, err = tx.ExecEx(d.Context,
+			`WITH upd AS (
+				INSERT INTO rt_metrics (id, mtype, delta, hash, labels)
+				SELECT b.id, 'counter', b.delta, b.hash, b.labels
+					FROM unnest($1::text[], $2::bigint[], $3::text[], $4::text[]) AS b(id, delta, hash, labels)
+				ON CONFLICT (id, labels) DO UPDATE
+				SET delta = excluded.delta + rt_metrics.delta, hash = excluded.hash, updated_at = now(), stale = false
+				RETURNING id, mtype, labels, delta, value)
+			INSERT INTO rt_metrics_history (id, mtype, labels, delta, value)
+				SELECT id, mtype, labels, delta, value FROM upd;`,
+			nil, counters.ids, counters.deltas, counters.hashes, counters.labels)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range histograms {
+		err = d.mergeHistogram(tx, &histograms[i], false)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.CommitEx(d.Context)
+	if err != nil {
+		return err
+	}
+	d.prune(time.Now())
 	return nil
 }
 
-// Ping проверка состояния подключения бд встроенным в pgx.Connection методом.
+// prune удаляет из истории точки старше Retention, но не чаще раза в dbPruneInterval. Вызывается после
+// записи в историю, ошибка удаления только логируется, так как сами значения уже записаны.
+func (d *DBStorage) prune(now time.Time) {
+	if d.Retention <= 0 {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if now.Sub(d.lastPrune) < dbPruneInterval {
+		return
+	}
+	_, err := d.Connection.ExecEx(d.Context, "DELETE FROM rt_metrics_history WHERE ts < $1;",
+		nil, now.Add(-d.Retention))
+	if err != nil {
+		log.Println("History prune failed:", err)
+		return
+	}
+	d.lastPrune = now
+}
+
+// batchColumns столбцы многострочного upsert, по одному элементу на ряд метрики.
+type batchColumns struct {
+	ids    []string
+	values []float64
+	deltas []int64
+	hashes []string
+	labels []string
+}
+
+// aggregateBatch сводит список метрик к столбцам upsert с сохранением порядка первого появления ряда метрики.
+// Для gauge остается последнее значение, приросты counter суммируются, хеш берется из последней записи.
+// Метрики без значения и неизвестных типов пропускаются.
+func aggregateBatch(metrics []Metrics) (gauges, counters batchColumns) {
+	gaugeIdx := make(map[string]int)
+	counterIdx := make(map[string]int)
+	for _, m := range metrics {
+		switch {
+		case m.MType == "gauge" && m.Value != nil:
+			if i, ok := gaugeIdx[m.Key()]; ok {
+				gauges.values[i] = *m.Value
+				gauges.hashes[i] = m.Hash
+				continue
+			}
+			gaugeIdx[m.Key()] = len(gauges.ids)
+			gauges.ids = append(gauges.ids, m.ID)
+			gauges.values = append(gauges.values, *m.Value)
+			gauges.hashes = append(gauges.hashes, m.Hash)
+			gauges.labels = append(gauges.labels, FormatLabels(m.Labels))
+		case m.MType == "counter" && m.Delta != nil:
+			if i, ok := counterIdx[m.Key()]; ok {
+				counters.deltas[i] += *m.Delta
+				counters.hashes[i] = m.Hash
+				continue
+			}
+			counterIdx[m.Key()] = len(counters.ids)
+			counters.ids = append(counters.ids, m.ID)
+			counters.deltas = append(counters.deltas, *m.Delta)
+			counters.hashes = append(counters.hashes, m.Hash)
+			counters.labels = append(counters.labels, FormatLabels(m.Labels))
+		}
+	}
+	return gauges, counters
+}
+
+// Ping проверка состояния подключения к бд пустым запросом, так же как это делает pgx.Conn.Ping.
 func (d *DBStorage) Ping() error {
 	if d.Connection == nil {
 		return errNoDB
 	}
-	err := d.Connection.Ping(d.Context)
+	_, err := d.Connection.ExecEx(d.Context, ";", nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// DBConnectStorage парсит строку для подключения к базе и пытается к ней подключиться.
-// В случае успеха, создает таблицу rt_metrics, если она не существует и принимает переданный
-// в качестве аргумента функции контекст.
-func (d *DBStorage) DBConnectStorage(ctx context.Context, auth string) error {
+// DBConnect парсит строку для подключения к базе и создает пул подключений размером MaxConnections,
+// не изменяя схему базы. Принимает переданный в качестве аргумента функции контекст.
+func (d *DBStorage) DBConnect(ctx context.Context, auth string) error {
 	var err error
 	if auth == "" {
 		return nil
@@ -215,27 +642,42 @@ func (d *DBStorage) DBConnectStorage(ctx context.Context, auth string) error {
 	if err != nil {
 		return errors.New("DB auth uri parse failed")
 	}
-	d.Connection, err = pgx.Connect(d.ConnConfig)
+	if d.MaxConnections <= 0 {
+		d.MaxConnections = DefaultMaxConnections
+	}
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{
+		ConnConfig:     d.ConnConfig,
+		MaxConnections: d.MaxConnections,
+	})
 	if err != nil {
 		return errors.New("WARNING! DB connection failed")
 	}
-	if d.Connection != nil {
-		_, err := d.Connection.Exec(
-			`CREATE TABLE IF NOT EXISTS rt_metrics (
-				id TEXT UNIQUE,
-				mtype TEXT,
-				delta BIGINT,
-				value DOUBLE PRECISION,
-				hash TEXT
-			);`)
-		if err != nil {
-			return err
-		}
-	}
+	d.Connection = pool
 	d.Context = ctx
 	return nil
 }
 
+// DBConnectStorage подключается к базе методом DBConnect и применяет к ней все еще не примененные
+// миграции схемы из пакета migrations.
+func (d *DBStorage) DBConnectStorage(ctx context.Context, auth string) error {
+	err := d.DBConnect(ctx, auth)
+	if err != nil || d.Connection == nil {
+		return err
+	}
+	migrator, err := migrations.NewMigrator(d.Connection)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		log.Println("DB migrations applied:", applied)
+	}
+	return nil
+}
+
 // DBFlushTable очищает таблицу rt_metrics.
 func (d *DBStorage) DBFlushTable() error {
 	// Empty table
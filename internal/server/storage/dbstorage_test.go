@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"os"
 	"reflect"
 	"testing"
@@ -298,17 +299,108 @@ func TestDBStorage_ReadMetric(t *testing.T) {
 	}
 }
 
-func TestDBStorage_InsertBatchMetric(t *testing.T) {
-	var v float64
+func TestDBStorage_ReadHistory(t *testing.T) {
 	tests := []struct {
 		name    string
 		d       *DBStorage
-		metrics []Metrics
 		wantErr bool
+	}{
+		{
+			name:    "connection nil",
+			d:       &DBStorage{},
+			wantErr: true,
+		},
+		{
+			name:    "db err",
+			d:       &DBStorage{Connection: &pgx.Conn{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeContext, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			tt.d.Context = timeContext
+			_, err := tt.d.ReadHistory("gauge", "Alloc", time.Now().Add(-time.Hour), time.Now(), 0)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DBStorage.ReadHistory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// failingConnection подключение, все запросы которого завершаются ошибкой.
+type failingConnection struct {
+	*pgx.Conn
+	begins int
+}
+
+func (f *failingConnection) BeginEx(ctx context.Context, txOptions *pgx.TxOptions) (*pgx.Tx, error) {
+	f.begins++
+	return nil, errors.New("begin failed")
+}
+
+// recordingConnection подключение, запоминающее аргументы ExecEx вместо выполнения запросов.
+type recordingConnection struct {
+	*pgx.Conn
+	args [][]interface{}
+}
+
+func (r *recordingConnection) ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions,
+	arguments ...interface{}) (pgx.CommandTag, error) {
+	r.args = append(r.args, arguments)
+	return "", nil
+}
+
+func TestDBStorage_prune(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		retention  time.Duration
+		lastPrune  time.Time
+		wantCutoff []interface{}
+	}{
+		{name: "disabled", retention: 0},
+		{name: "prune", retention: time.Hour, wantCutoff: []interface{}{now.Add(-time.Hour)}},
+		{name: "too soon", retention: time.Hour, lastPrune: now.Add(-dbPruneInterval / 2)},
+		{name: "interval passed", retention: time.Hour, lastPrune: now.Add(-dbPruneInterval),
+			wantCutoff: []interface{}{now.Add(-time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConnection{Conn: &pgx.Conn{}}
+			d := &DBStorage{Connection: conn, Context: context.Background(), Retention: tt.retention, lastPrune: tt.lastPrune}
+			d.prune(now)
+			// Повторный вызов в пределах интервала ничего не удаляет.
+			d.prune(now.Add(time.Second))
+			if tt.wantCutoff == nil {
+				if len(conn.args) != 0 {
+					t.Errorf("DBStorage.prune() executed %d queries, want 0", len(conn.args))
+				}
+				return
+			}
+			if !reflect.DeepEqual(conn.args, [][]interface{}{tt.wantCutoff}) {
+				t.Errorf("DBStorage.prune() args = %v, want %v", conn.args, tt.wantCutoff)
+			}
+			if !d.lastPrune.Equal(now) {
+				t.Errorf("DBStorage.prune() lastPrune = %v, want %v", d.lastPrune, now)
+			}
+		})
+	}
+}
+
+func TestDBStorage_InsertBatchMetric(t *testing.T) {
+	var v float64
+	tests := []struct {
+		name       string
+		d          *DBStorage
+		metrics    []Metrics
+		wantBegins int
+		wantErr    bool
 	}{
 		{
 			name: "db err",
-			d:    &DBStorage{Connection: &pgx.Conn{}},
+			d:    &DBStorage{Connection: &failingConnection{Conn: &pgx.Conn{}}},
 			metrics: []Metrics{
 				{
 					ID:    "Alloc",
@@ -316,6 +408,25 @@ func TestDBStorage_InsertBatchMetric(t *testing.T) {
 					Value: &v,
 				},
 			},
+			wantBegins: 1,
+			wantErr:    true,
+		},
+		{
+			name: "empty batch",
+			d:    &DBStorage{Connection: &failingConnection{Conn: &pgx.Conn{}}},
+			metrics: []Metrics{
+				{
+					ID:    "Alloc",
+					MType: "gauge",
+				},
+			},
+			wantBegins: 0,
+			wantErr:    false,
+		},
+		{
+			name:    "no db",
+			d:       &DBStorage{},
+			metrics: []Metrics{},
 			wantErr: true,
 		},
 	}
@@ -327,10 +438,45 @@ func TestDBStorage_InsertBatchMetric(t *testing.T) {
 			if err := tt.d.InsertBatchMetric(tt.metrics); (err != nil) != tt.wantErr {
 				t.Errorf("DBStorage.InsertBatchMetric() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if f, ok := tt.d.Connection.(*failingConnection); ok && f.begins != tt.wantBegins {
+				t.Errorf("DBStorage.InsertBatchMetric() began %d transactions, want %d", f.begins, tt.wantBegins)
+			}
 		})
 	}
 }
 
+func Test_aggregateBatch(t *testing.T) {
+	d1, d2, d3 := int64(1), int64(2), int64(5)
+	v1, v2 := 1.5, 2.5
+	metrics := []Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &v1, Hash: "a"},
+		{ID: "PollCount", MType: "counter", Delta: &d1},
+		{ID: "Heap", MType: "gauge", Value: &v1},
+		{ID: "PollCount", MType: "counter", Delta: &d2, Hash: "p"},
+		{ID: "Alloc", MType: "gauge", Value: &v2, Hash: "b"},
+		{ID: "Other", MType: "counter", Delta: &d3},
+		{ID: "Alloc", MType: "gauge", Labels: map[string]string{"host": "a"}, Value: &v1},
+		{ID: "Empty", MType: "gauge"},
+		{ID: "Unknown", MType: "histogram", Value: &v1},
+	}
+	gauges, counters := aggregateBatch(metrics)
+	wantGauges := batchColumns{
+		ids:    []string{"Alloc", "Heap", "Alloc"},
+		values: []float64{2.5, 1.5, 1.5},
+		hashes: []string{"b", "", ""},
+		labels: []string{"", "", `host="a"`},
+	}
+	wantCounters := batchColumns{
+		ids:    []string{"PollCount", "Other"},
+		deltas: []int64{3, 5},
+		hashes: []string{"p", ""},
+		labels: []string{"", ""},
+	}
+	if !reflect.DeepEqual(gauges, wantGauges) || !reflect.DeepEqual(counters, wantCounters) {
+		t.Errorf("aggregateBatch() = %v, %v, want %v, %v", gauges, counters, wantGauges, wantCounters)
+	}
+}
+
 func TestDBStorage_Ping(t *testing.T) {
 	tests := []struct {
 		name    string
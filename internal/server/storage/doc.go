@@ -1,4 +1,7 @@
 // Package storage описывает интерфейсный тип IStorage и его реализации 
-// для хранилищ работающих в памяти, работающих с postgres. А также файловое
-// хранилище для синхронной или по требованию записи и загрузки всех метрик.
+// для хранилищ работающих в памяти, работающих с postgres и с файлом базы SQLite. А также файловое
+// хранилище для синхронной или по требованию записи и загрузки всех метрик
+// и хранилище истории значений метрик History. Ряды метрик различаются названием и набором меток,
+// все хранилища ведут их по ключу ряда SeriesKey. Кроме gauge и counter хранятся метрики histogram,
+// которые складываются по корзинам, а квантили оцениваются при чтении.
 package storage
\ No newline at end of file
@@ -1,22 +1,31 @@
 package storage
 
 import (
-	"context"
+	"log"
 	"os"
 	"time"
 
 	"github.com/dsft54/rt-metrics/config/server/settings"
 )
 
+// DefaultCheckpointInterval интервал снятия снимков при включенном журнале и нулевом StoreInterval.
+const DefaultCheckpointInterval = 5 * time.Minute
+
 // FileStorage стуктура, описывающая файл куда/откуда будут сохранены/загружены метрики, путь до него и
 // логические переменные, определяющие необходимость загрузки метрик в хранилище при старте сервера
 // и сохранении их при остановке - StoreData, и определяющие необходимость синхронной записи метрик не только
 // в хранилище, но и в файл - Synchronize.
+// Если задан WALPath, вместо синхронной записи снимка обновления дописываются в журнал WAL, а снимок
+// снимается методом Checkpoint, после чего журнал обрезается. Keep - сколько последних снимков хранить.
 type FileStorage struct {
-	File        *os.File
-	FilePath    string
-	StoreData   bool
-	Synchronize bool
+	File            *os.File
+	FilePath        string
+	StoreData       bool
+	Synchronize     bool
+	Keep            int
+	WALPath         string
+	WALSyncInterval time.Duration
+	WAL             *WAL
 }
 
 // NewFileStorage функция-конструктор для структуры FileStorage. В зависимости от конфигурации запуска сервера,
@@ -30,6 +39,12 @@ func NewFileStorage(cfg settings.Config) *FileStorage {
 	}
 	fs.FilePath = cfg.StoreFile
 	fs.StoreData = true
+	fs.Keep = cfg.StoreKeep
+	if cfg.WALFile != "" {
+		fs.WALPath = cfg.WALFile
+		fs.WALSyncInterval = cfg.WALSyncInterval
+		return fs
+	}
 	if cfg.StoreInterval == 0 {
 		fs.Synchronize = true
 	}
@@ -48,30 +63,105 @@ func (f *FileStorage) OpenToWrite(path string) (err error) {
 	return nil
 }
 
-// SaveStorageToFile сохраняет текущий активный storage в файл.
+// SaveStorageToFile атомарно сохраняет текущий активный storage в файл, см. writeSnapshot.
 func (f *FileStorage) SaveStorageToFile(s IStorage) error {
-	err := f.OpenToWrite(f.FilePath)
-	defer f.File.Close()
+	return f.writeSnapshot(s)
+}
+
+// OpenWAL открывает журнал WALPath. Перед записью в него обновлений нужно снять снимок методом Checkpoint.
+func (f *FileStorage) OpenWAL() (err error) {
+	f.WAL, err = OpenWAL(f.WALPath, f.WALSyncInterval)
+	return err
+}
+
+// Restore загружает в хранилище s самый новый целый снимок и воспроизводит поверх него обновления,
+// удаления и обнуления из журнала WALPath, если он задан. Если журнал записан для другого снимка,
+// воспроизводится журнал, подготовленный Checkpoint, но не успевший заменить текущий.
+func (f *FileStorage) Restore(s IStorage) error {
+	path, err := f.restoreSnapshot(s)
+	if f.WALPath == "" {
+		return err
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	base := walNoSnapshot
+	if path != "" {
+		base, err = snapshotID(path)
+		if err != nil {
+			return err
+		}
+	}
+	replayed, err := ReplayWAL(f.WALPath, base, s)
+	if err == nil && replayed == 0 {
+		// Сбой между сохранением снимка и переименованием нового журнала: журнал снимка лежит рядом.
+		replayed, err = ReplayWAL(f.WALPath+walNextSuffix, base, s)
+	}
+	if replayed > 0 {
+		log.Println("WAL updates replayed:", replayed)
+	}
+	return err
+}
+
+// Checkpoint сохраняет снимок хранилища s и начинает журнал заново. Снимок записывается атомарно, а журнал
+// начинается с идентификатора нового снимка, поэтому сбой на любом шаге не приводит к повторному применению
+// обновлений при восстановлении. Без журнала работает как SaveStorageToFile.
+// Под блокировкой журнала снимается только копия хранилища в памяти и отмечается конец журнала. Копия
+// сериализуется и сбрасывается на диск без блокировки, а записи, попавшие в журнал за это время, переносятся
+// в новый журнал. Хранилища без копии сохраняются целиком под блокировкой.
+func (f *FileStorage) Checkpoint(s IStorage) error {
+	if f.WAL == nil {
+		return f.SaveStorageToFile(s)
+	}
+	if ws, ok := s.(*WALStorage); ok {
+		s = ws.IStorage
+	}
+	fr, ok := s.(freezer)
+	if !ok {
+		f.WAL.gate.Lock()
+		defer f.WAL.gate.Unlock()
+		return f.checkpoint(s)
+	}
+	f.WAL.gate.Lock()
+	frozen := fr.freeze()
+	from, err := f.WAL.offset()
+	f.WAL.gate.Unlock()
 	if err != nil {
 		return err
 	}
-	err = s.SaveToFile(f.File)
+	tmp, err := f.prepareSnapshot(frozen)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer removeSnapshot(tmp)
+	base, err := snapshotID(tmp)
+	if err != nil {
+		return err
+	}
+	f.WAL.gate.Lock()
+	defer f.WAL.gate.Unlock()
+	// Новый журнал готовится до переименования снимка: после сбоя между переименованиями Restore
+	// найдет его по идентификатору снимка.
+	err = f.WAL.prepareNext(base, from)
+	if err != nil {
+		return err
+	}
+	err = f.installSnapshot(tmp)
+	if err != nil {
+		return err
+	}
+	return f.WAL.switchNext()
 }
 
-// IntervalUpdate создает тикер и в бесконечном цикле ожидает либо срабатывания тикера для того,
-// чтобы сохранить текущий storage в файл, либо ctx.Done, для того, чтобы завершить срабатывание цикла.
-func (f *FileStorage) IntervalUpdate(ctx context.Context, dur time.Duration, s IStorage) {
-	intervalTicker := time.NewTicker(dur)
-	for {
-		select {
-		case <-intervalTicker.C:
-			s.SaveToFile(f.File)
-		case <-ctx.Done():
-			return
-		}
+// checkpoint сохраняет снимок хранилища s и обрезает журнал. Вызывается под gate.Lock.
+func (f *FileStorage) checkpoint(s IStorage) error {
+	err := f.writeSnapshot(s)
+	if err != nil {
+		return err
+	}
+	base, err := snapshotID(f.FilePath)
+	if err != nil {
+		return err
 	}
+	return f.WAL.Reset(base)
 }
@@ -1,10 +1,10 @@
 package storage
 
 import (
-	"context"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -70,6 +70,22 @@ func TestNewFileStorage(t *testing.T) {
 				Synchronize: false,
 			},
 		},
+		{
+			name: "Path, wal instead of sync",
+			cfg: settings.Config{
+				StoreInterval:   0,
+				StoreFile:       "test",
+				WALFile:         "test.wal",
+				WALSyncInterval: time.Second,
+			},
+			want: &FileStorage{
+				FilePath:        "test",
+				StoreData:       true,
+				Synchronize:     false,
+				WALPath:         "test.wal",
+				WALSyncInterval: time.Second,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -149,7 +165,14 @@ func TestFileStorage_SaveStorageToFile(t *testing.T) {
 			if err := tt.f.SaveStorageToFile(tt.s); (err != nil) != tt.wantErr {
 				t.Errorf("FileStorage.SaveStorageToFile() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			data, err := ioutil.ReadFile(tt.f.FilePath)
+			raw, err := ioutil.ReadFile(tt.f.FilePath)
+			if err != nil {
+				t.Error(err)
+			}
+			if !strings.HasPrefix(string(raw), snapshotMagic) {
+				t.Errorf("snapshot has no checksum header: %s", raw)
+			}
+			data, err := readSnapshot(tt.f.FilePath)
 			if err != nil {
 				t.Error(err)
 			}
@@ -163,56 +186,3 @@ func TestFileStorage_SaveStorageToFile(t *testing.T) {
 		})
 	}
 }
-
-func TestFileStorage_IntervalUpdate(t *testing.T) {
-	tests := []struct {
-		f    *FileStorage
-		s    IStorage
-		ctx  context.Context
-		dur  time.Duration
-		name string
-	}{
-		{
-			name: "context exit",
-			f: NewFileStorage(settings.Config{
-				StoreFile: "test",
-			}),
-			dur: 500 * time.Millisecond,
-			s: &MemoryStorage{
-				GaugeMetrics:   map[string]float64{"Alloc": 3.14},
-				CounterMetrics: map[string]int64{},
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			var (
-				cancel context.CancelFunc
-				err    error
-			)
-			tt.ctx, cancel = context.WithCancel(context.Background())
-			tt.f.File, err = os.OpenFile(tt.f.FilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-			if err != nil {
-				t.Error(err)
-			}
-			go tt.f.IntervalUpdate(tt.ctx, tt.dur, tt.s)
-			<-time.NewTimer(700 * time.Millisecond).C
-			cancel()
-			data, err := ioutil.ReadFile("test")
-			if err != nil {
-				t.Error("File was not created")
-			}
-			if string(data) != "[{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":3.14}]" {
-				t.Error("Data in file not correct", string(data))
-			}
-			err = tt.f.File.Close()
-			if err != nil {
-				t.Error(err)
-			}
-			err = os.Remove(tt.f.FilePath)
-			if err != nil {
-				t.Error(err)
-			}
-		})
-	}
-}
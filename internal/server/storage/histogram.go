@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultQuantiles квантили, которые оцениваются при чтении метрик histogram.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+var errHistogramBounds = errors.New("histogram bounds mismatch")
+
+// Histogram распределение значений метрики histogram по корзинам. Bounds - верхние границы корзин
+// по возрастанию, корзина +Inf подразумевается последней, поэтому Counts на один элемент длиннее Bounds.
+// Counts - количество значений в каждой корзине (не накопленное), Sum - сумма всех значений. Гистограммы
+// с одинаковыми границами складываются. Quantiles заполняется только при чтении оценками DefaultQuantiles.
+type Histogram struct {
+	Bounds    []float64          `json:"bounds"`
+	Counts    []int64            `json:"counts"`
+	Sum       float64            `json:"sum"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// Validate проверяет, что границы корзин конечны и возрастают, а количество значений в корзинах
+// неотрицательно и задано для каждой корзины.
+func (h *Histogram) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram has %d counts for %d bounds, want %d", len(h.Counts), len(h.Bounds),
+			len(h.Bounds)+1)
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("histogram bound %v is not finite", b)
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return fmt.Errorf("histogram bounds are not increasing")
+		}
+	}
+	for _, c := range h.Counts {
+		if c < 0 {
+			return fmt.Errorf("histogram count %d is negative", c)
+		}
+	}
+	return nil
+}
+
+// Count возвращает общее количество значений.
+func (h *Histogram) Count() int64 {
+	var count int64
+	for _, c := range h.Counts {
+		count += c
+	}
+	return count
+}
+
+// Clone возвращает копию гистограммы без оценок квантилей.
+func (h *Histogram) Clone() *Histogram {
+	return &Histogram{
+		Bounds: append([]float64{}, h.Bounds...),
+		Counts: append([]int64{}, h.Counts...),
+		Sum:    h.Sum,
+	}
+}
+
+// Merge добавляет к гистограмме значения other. Границы корзин должны совпадать.
+func (h *Histogram) Merge(other *Histogram) error {
+	if len(h.Bounds) != len(other.Bounds) || len(h.Counts) != len(other.Counts) {
+		return errHistogramBounds
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return errHistogramBounds
+		}
+	}
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	return nil
+}
+
+// Quantile оценивает квантиль q так же, как histogram_quantile в Prometheus: находит корзину, в которую
+// попадает значение ранга q и интерполирует его линейно между границами корзины. Нижней границей первой
+// корзины считается 0, если ее верхняя граница положительна. Для корзины +Inf возвращается последняя
+// конечная граница. Для пустой гистограммы возвращает NaN.
+func (h *Histogram) Quantile(q float64) float64 {
+	count := h.Count()
+	if count == 0 || len(h.Counts) != len(h.Bounds)+1 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	rank := q * float64(count)
+	var cumulative int64
+	for i, c := range h.Counts {
+		if float64(cumulative+c) < rank || c == 0 {
+			cumulative += c
+			continue
+		}
+		if i == len(h.Bounds) {
+			if i == 0 {
+				return math.NaN()
+			}
+			return h.Bounds[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		} else if h.Bounds[0] <= 0 {
+			return h.Bounds[0]
+		}
+		return lower + (h.Bounds[i]-lower)*(rank-float64(cumulative))/float64(c)
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// withQuantiles возвращает копию гистограммы с оценками DefaultQuantiles. Квантили, которые оценить
+// нельзя (например, у пустой гистограммы), не добавляются.
+func (h *Histogram) withQuantiles() *Histogram {
+	out := h.Clone()
+	for _, q := range DefaultQuantiles {
+		v := h.Quantile(q)
+		if math.IsNaN(v) {
+			continue
+		}
+		if out.Quantiles == nil {
+			out.Quantiles = make(map[string]float64, len(DefaultQuantiles))
+		}
+		out.Quantiles[strconv.FormatFloat(q, 'g', -1, 64)] = v
+	}
+	return out
+}
+
+// HistogramHashInput часть строки, от которой считается хеш метрики histogram: границы корзин, количество
+// значений в них и сумма, например "0.1,1:2,3,0:1.500000".
+func HistogramHashInput(h *Histogram) string {
+	bounds := make([]string, len(h.Bounds))
+	for i, b := range h.Bounds {
+		bounds[i] = strconv.FormatFloat(b, 'g', -1, 64)
+	}
+	counts := make([]string, len(h.Counts))
+	for i, c := range h.Counts {
+		counts[i] = strconv.FormatInt(c, 10)
+	}
+	return fmt.Sprintf("%s:%s:%f", strings.Join(bounds, ","), strings.Join(counts, ","), h.Sum)
+}
+
+// Validate проверяет метрику перед записью: допустимость названий меток, а для histogram - наличие
+// и корректность гистограммы.
+func (m *Metrics) Validate() error {
+	err := ValidateLabels(m.Labels)
+	if err != nil {
+		return err
+	}
+	if m.MType != "histogram" {
+		return nil
+	}
+	if m.Histogram == nil {
+		return errNoValue
+	}
+	return m.Histogram.Validate()
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestHistogram_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       Histogram
+		wantErr bool
+	}{
+		{name: "valid", h: Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5}},
+		{name: "only +Inf", h: Histogram{Counts: []int64{3}, Sum: 3}},
+		{name: "missing +Inf", h: Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2}}, wantErr: true},
+		{name: "not increasing", h: Histogram{Bounds: []float64{1, 1}, Counts: []int64{1, 2, 0}}, wantErr: true},
+		{name: "infinite bound", h: Histogram{Bounds: []float64{math.Inf(1)}, Counts: []int64{1, 0}}, wantErr: true},
+		{name: "negative count", h: Histogram{Bounds: []float64{1}, Counts: []int64{-1, 0}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.h.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Histogram.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := &Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5}
+	assert.Equal(t, h.Merge(&Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{0, 1, 1}, Sum: 3}), nil)
+	assert.Equal(t, h.Counts, []int64{1, 3, 1})
+	assert.Equal(t, h.Sum, 4.5)
+	assert.Equal(t, h.Count(), int64(5))
+
+	err := h.Merge(&Histogram{Bounds: []float64{0.2, 1}, Counts: []int64{0, 1, 1}})
+	assert.Equal(t, err, errHistogramBounds)
+	err = h.Merge(&Histogram{Bounds: []float64{1}, Counts: []int64{0, 1}})
+	assert.Equal(t, err, errHistogramBounds)
+	assert.Equal(t, h.Counts, []int64{1, 3, 1})
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	h := &Histogram{Bounds: []float64{1, 2, 4}, Counts: []int64{2, 2, 4, 2}}
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{q: 0, want: 0},
+		{q: 0.1, want: 0.5},
+		{q: 0.3, want: 1.5},
+		{q: 0.6, want: 3},
+		{q: 0.95, want: 4},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, h.Quantile(tt.q), tt.want)
+	}
+	assert.Equal(t, math.IsNaN(h.Quantile(1.5)), true)
+	assert.Equal(t, math.IsNaN((&Histogram{Bounds: []float64{1}, Counts: []int64{0, 0}}).Quantile(0.5)), true)
+
+	withQ := h.withQuantiles()
+	assert.Equal(t, withQ.Quantiles["0.5"], 2.5)
+	assert.Equal(t, len(h.Quantiles), 0)
+}
+
+func TestHistogramHashInput(t *testing.T) {
+	h := &Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{2, 3, 0}, Sum: 1.5}
+	assert.Equal(t, HistogramHashInput(h), "0.1,1:2,3,0:1.500000")
+}
+
+func TestMetrics_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       Metrics
+		wantErr bool
+	}{
+		{name: "gauge", m: Metrics{ID: "Alloc", MType: "gauge"}},
+		{name: "bad label", m: Metrics{ID: "Alloc", MType: "gauge", Labels: map[string]string{"1a": "b"}}, wantErr: true},
+		{name: "histogram", m: Metrics{ID: "GCPauseSeconds", MType: "histogram",
+			Histogram: &Histogram{Bounds: []float64{1}, Counts: []int64{1, 0}}}},
+		{name: "no histogram", m: Metrics{ID: "GCPauseSeconds", MType: "histogram"}, wantErr: true},
+		{name: "bad histogram", m: Metrics{ID: "GCPauseSeconds", MType: "histogram",
+			Histogram: &Histogram{Bounds: []float64{1}, Counts: []int64{1}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Metrics.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStorage_Histogram(t *testing.T) {
+	storages := []struct {
+		name string
+		st   func(t *testing.T) IStorage
+	}{
+		{name: "memory", st: func(t *testing.T) IStorage {
+			return &MemoryStorage{GaugeMetrics: map[string]float64{}, CounterMetrics: map[string]int64{}}
+		}},
+		{name: "sharded", st: func(t *testing.T) IStorage { return NewShardedStorage(4, nil) }},
+		{name: "sqlite", st: func(t *testing.T) IStorage { return newTestSQLiteStorage(t, time.Hour) }},
+	}
+	bounds := []float64{0.001, 0.01, 0.1}
+	hostA := map[string]string{"host": "a"}
+	for _, ts := range storages {
+		t.Run(ts.name, func(t *testing.T) {
+			st := ts.st(t)
+			err := st.InsertBatchMetric([]Metrics{
+				{ID: "GCPauseSeconds", MType: "histogram", Labels: hostA,
+					Histogram: &Histogram{Bounds: bounds, Counts: []int64{1, 2, 0, 0}, Sum: 0.011}},
+				{ID: "GCPauseSeconds", MType: "histogram", Labels: hostA,
+					Histogram: &Histogram{Bounds: bounds, Counts: []int64{0, 1, 1, 0}, Sum: 0.055}},
+			})
+			assert.Equal(t, err, nil)
+			err = st.InsertMetric(&Metrics{ID: "GCPauseSeconds", MType: "histogram", Labels: hostA,
+				Histogram: &Histogram{Bounds: []float64{1}, Counts: []int64{1, 0}}})
+			assert.NotEqual(t, err, nil)
+
+			got, err := st.ReadMetric(&Metrics{ID: "GCPauseSeconds", MType: "histogram", Labels: hostA})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, got.Histogram.Counts, []int64{1, 3, 1, 0})
+			assert.Equal(t, math.Abs(got.Histogram.Sum-0.066) < 1e-9, true)
+			assert.Equal(t, len(got.Histogram.Quantiles), len(DefaultQuantiles))
+
+			all, err := st.ReadAllMetrics()
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(all), 1)
+			assert.Equal(t, all[0].Labels, hostA)
+			assert.Equal(t, all[0].Histogram.Count(), int64(5))
+
+			fs := &FileStorage{FilePath: filepath.Join(t.TempDir(), "metrics.json")}
+			assert.Equal(t, fs.SaveStorageToFile(st), nil)
+			if _, err := os.Stat(fs.FilePath); err != nil {
+				t.Fatal(err)
+			}
+			restored := ts.st(t)
+			assert.Equal(t, restored.UploadFromFile(fs.FilePath), nil)
+			got, err = restored.ReadMetric(&Metrics{ID: "GCPauseSeconds", MType: "histogram", Labels: hostA})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, got.Histogram.Counts, []int64{1, 3, 1, 0})
+		})
+	}
+}
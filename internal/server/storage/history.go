@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyFileSuffix суффикс файла, в который сохраняется история рядом с основным файлом хранилища.
+const historyFileSuffix = ".history"
+
+// HistoryPruneInterval интервал удаления устаревших точек из всех рядов истории.
+const HistoryPruneInterval = time.Minute
+
+var errNoHistory = fmt.Errorf("history is disabled")
+
+// Sample точка временного ряда: время записи и значение метрики после применения обновления.
+// Для counter хранится накопленное значение, для gauge - установленное.
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Delta *int64    `json:"delta,omitempty"`
+	Value *float64  `json:"value,omitempty"`
+}
+
+// History хранилище временных рядов метрик в памяти. Ряды хранятся по ключу тип:название,
+// точки старше Retention отбрасываются при записи новых, а в рядах без новых точек - методом Prune. Ряды разбиты на сегменты по хешу ключа так же,
+// как метрики в ShardedStorage, и каждый сегмент блокируется отдельно, поэтому запись в историю разных
+// рядов не ждет друг друга.
+type History struct {
+	Retention time.Duration
+	segments  []*historySegment
+}
+
+// historySegment сегмент History со своей блокировкой.
+type historySegment struct {
+	series map[string][]Sample
+	mutex  sync.RWMutex
+}
+
+// NewHistory функция-конструктор для History с заданной глубиной хранения и DefaultShards сегментами.
+func NewHistory(retention time.Duration) *History {
+	h := &History{
+		Retention: retention,
+		segments:  make([]*historySegment, DefaultShards),
+	}
+	for i := range h.segments {
+		h.segments[i] = &historySegment{series: make(map[string][]Sample)}
+	}
+	return h
+}
+
+func historyKey(mType, id string) string {
+	return mType + ":" + id
+}
+
+// splitHistoryKey разбирает ключ, полученный из historyKey, на тип метрики и ключ ряда.
+func splitHistoryKey(key string) (string, string) {
+	mType, id, _ := strings.Cut(key, ":")
+	return mType, id
+}
+
+// segment возвращает сегмент ряда с ключом key из historyKey.
+func (h *History) segment(key string) *historySegment {
+	return h.segments[shardIndex(key, len(h.segments))]
+}
+
+// Record потокобезопасно добавляет точку в ряд метрики и удаляет из него устаревшие точки.
+func (h *History) Record(mType, id string, value *float64, delta *int64, ts time.Time) {
+	sample := Sample{Time: ts}
+	if value != nil {
+		v := *value
+		sample.Value = &v
+	}
+	if delta != nil {
+		d := *delta
+		sample.Delta = &d
+	}
+	key := historyKey(mType, id)
+	seg := h.segment(key)
+	seg.mutex.Lock()
+	defer seg.mutex.Unlock()
+	series := append(seg.series[key], sample)
+	if h.Retention > 0 {
+		border := ts.Add(-h.Retention)
+		i := 0
+		for i < len(series) && series[i].Time.Before(border) {
+			i++
+		}
+		series = series[i:]
+	}
+	seg.series[key] = series
+}
+
+// Prune потокобезопасно удаляет точки старше Retention относительно now из всех рядов, в том числе из рядов,
+// в которые больше не пишут, и удаляет опустевшие ряды. Возвращает количество удаленных точек.
+func (h *History) Prune(now time.Time) int {
+	if h.Retention <= 0 {
+		return 0
+	}
+	border := now.Add(-h.Retention)
+	pruned := 0
+	for _, seg := range h.segments {
+		seg.mutex.Lock()
+		for key, series := range seg.series {
+			i := 0
+			for i < len(series) && series[i].Time.Before(border) {
+				i++
+			}
+			if i == 0 {
+				continue
+			}
+			pruned += i
+			if i == len(series) {
+				delete(seg.series, key)
+				continue
+			}
+			seg.series[key] = series[i:]
+		}
+		seg.mutex.Unlock()
+	}
+	return pruned
+}
+
+// PruneLoop вызывает Prune каждые interval до отмены ctx.
+func (h *History) PruneLoop(ctx context.Context, interval time.Duration) {
+	if h.Retention <= 0 || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			h.Prune(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Query возвращает точки ряда метрики в интервале [from, to], прореженные с шагом step.
+func (h *History) Query(mType, id string, from, to time.Time, step time.Duration) []Sample {
+	key := historyKey(mType, id)
+	seg := h.segment(key)
+	seg.mutex.RLock()
+	defer seg.mutex.RUnlock()
+	samples := []Sample{}
+	for _, s := range seg.series[key] {
+		if s.Time.Before(from) || s.Time.After(to) {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return downsample(samples, from, step)
+}
+
+// Last возвращает время последней записанной точки ряда метрики. Если ряд пуст, второе значение равно false.
+func (h *History) Last(mType, id string) (time.Time, bool) {
+	key := historyKey(mType, id)
+	seg := h.segment(key)
+	seg.mutex.RLock()
+	defer seg.mutex.RUnlock()
+	series := seg.series[key]
+	if len(series) == 0 {
+		return time.Time{}, false
+	}
+	return series[len(series)-1].Time, true
+}
+
+// Delete потокобезопасно удаляет ряд метрики.
+func (h *History) Delete(mType, id string) {
+	key := historyKey(mType, id)
+	seg := h.segment(key)
+	seg.mutex.Lock()
+	defer seg.mutex.Unlock()
+	delete(seg.series, key)
+}
+
+// Save сохраняет все ряды в файл по указанному пути в формате json. Сегменты блокируются по одному,
+// точки уже записанных рядов не изменяются, поэтому сериализация выполняется без блокировок.
+func (h *History) Save(path string) error {
+	all := make(map[string][]Sample)
+	for _, seg := range h.segments {
+		seg.mutex.RLock()
+		for key, series := range seg.series {
+			all[key] = series
+		}
+		seg.mutex.RUnlock()
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Load заменяет ряды значениями из файла по указанному пути. Отсутствие файла ошибкой не считается.
+func (h *History) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	series := make(map[string][]Sample)
+	err = json.Unmarshal(data, &series)
+	if err != nil {
+		return err
+	}
+	loaded := make([]map[string][]Sample, len(h.segments))
+	for i := range loaded {
+		loaded[i] = make(map[string][]Sample)
+	}
+	for key, samples := range series {
+		loaded[shardIndex(key, len(h.segments))][key] = samples
+	}
+	for i, seg := range h.segments {
+		seg.mutex.Lock()
+		seg.series = loaded[i]
+		seg.mutex.Unlock()
+	}
+	return nil
+}
+
+// downsample прореживает упорядоченный по времени ряд, оставляя последнюю точку в каждом
+// интервале длительностью step, отсчитываемом от from. При step <= 0 ряд возвращается как есть.
+func downsample(samples []Sample, from time.Time, step time.Duration) []Sample {
+	if step <= 0 {
+		return samples
+	}
+	result := []Sample{}
+	bucket := int64(-1)
+	for _, s := range samples {
+		b := int64(s.Time.Sub(from) / step)
+		if b == bucket {
+			result[len(result)-1] = s
+			continue
+		}
+		bucket = b
+		result = append(result, s)
+	}
+	return result
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestHistory_Record(t *testing.T) {
+	start := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		retention time.Duration
+		points    int
+		want      int
+	}{
+		{
+			name:      "keep all",
+			retention: 0,
+			points:    10,
+			want:      10,
+		},
+		{
+			name:      "drop outdated",
+			retention: 5 * time.Minute,
+			points:    10,
+			want:      6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistory(tt.retention)
+			for i := 0; i < tt.points; i++ {
+				v := float64(i)
+				h.Record("gauge", "Alloc", &v, nil, start.Add(time.Duration(i)*time.Minute))
+			}
+			assert.Equal(t, len(h.Query("gauge", "Alloc", start, start.Add(time.Hour), 0)), tt.want)
+		})
+	}
+}
+
+func TestHistory_Prune(t *testing.T) {
+	start := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(5 * time.Minute)
+	for i := 0; i < 10; i++ {
+		v := float64(i)
+		h.Record("gauge", "Alloc", &v, nil, start.Add(time.Duration(i)*time.Minute))
+	}
+	d := int64(1)
+	// Ряд перестал обновляться: без Prune его точки остались бы навсегда.
+	h.Record("counter", "PollCount", nil, &d, start)
+
+	assert.Equal(t, h.Prune(start.Add(12*time.Minute)), 4)
+	assert.Equal(t, len(h.Query("gauge", "Alloc", start, start.Add(time.Hour), 0)), 3)
+	_, ok := h.Last("counter", "PollCount")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, NewHistory(0).Prune(start), 0)
+}
+
+func TestHistory_Query(t *testing.T) {
+	start := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(0)
+	for i := 0; i < 60; i++ {
+		d := int64(i)
+		h.Record("counter", "PollCount", nil, &d, start.Add(time.Duration(i)*time.Second))
+	}
+	tests := []struct {
+		name      string
+		mType     string
+		from      time.Time
+		to        time.Time
+		step      time.Duration
+		want      int
+		wantDelta int64
+	}{
+		{
+			name:      "raw interval",
+			mType:     "counter",
+			from:      start.Add(10 * time.Second),
+			to:        start.Add(19 * time.Second),
+			want:      10,
+			wantDelta: 19,
+		},
+		{
+			name:      "downsampled",
+			mType:     "counter",
+			from:      start,
+			to:        start.Add(time.Hour),
+			step:      10 * time.Second,
+			want:      6,
+			wantDelta: 59,
+		},
+		{
+			name:  "unknown series",
+			mType: "gauge",
+			from:  start,
+			to:    start.Add(time.Hour),
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := h.Query(tt.mType, "PollCount", tt.from, tt.to, tt.step)
+			assert.Equal(t, len(got), tt.want)
+			if tt.want > 0 {
+				assert.Equal(t, *got[len(got)-1].Delta, tt.wantDelta)
+			}
+		})
+	}
+}
+
+func TestHistory_SaveLoad(t *testing.T) {
+	v := 3.14
+	h := NewHistory(0)
+	h.Record("gauge", "Alloc", &v, nil, time.Now())
+	err := h.Save("test" + historyFileSuffix)
+	if err != nil {
+		t.Error(err)
+	}
+	loaded := NewHistory(0)
+	err = loaded.Load("test" + historyFileSuffix)
+	if err != nil {
+		t.Error(err)
+	}
+	samples := loaded.Query("gauge", "Alloc", time.Time{}, time.Now(), 0)
+	assert.Equal(t, len(samples), 1)
+	assert.Equal(t, *samples[0].Value, v)
+	err = loaded.Load("not_exists" + historyFileSuffix)
+	if err != nil {
+		t.Error(err)
+	}
+	err = os.Remove("test" + historyFileSuffix)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryStorage_ReadHistory(t *testing.T) {
+	v := 3.14
+	tests := []struct {
+		m       *MemoryStorage
+		name    string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "history disabled",
+			m: &MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "history recorded",
+			m: &MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+				History:        NewHistory(time.Hour),
+			},
+			want:    2,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.m.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v})
+			if err != nil {
+				t.Error(err)
+			}
+			_, err = tt.m.ParamsUpdate("gauge", "Alloc", "6.28")
+			if err != nil {
+				t.Error(err)
+			}
+			got, err := tt.m.ReadHistory("gauge", "Alloc", time.Now().Add(-time.Minute), time.Now(), 0)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MemoryStorage.ReadHistory() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, len(got), tt.want)
+		})
+	}
+}
+
+func TestMemoryStorage_ReadAllMetricsUpdated(t *testing.T) {
+	v := 3.14
+	m := &MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+		History:        NewHistory(time.Hour),
+	}
+	_, ok := m.History.Last("gauge", "Alloc")
+	assert.Equal(t, ok, false)
+	err := m.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v})
+	assert.Equal(t, err, nil)
+	last, ok := m.History.Last("gauge", "Alloc")
+	assert.Equal(t, ok, true)
+	metrics, err := m.ReadAllMetrics()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(metrics), 1)
+	assert.NotEqual(t, metrics[0].Updated, nil)
+	assert.Equal(t, metrics[0].Updated.Equal(last), true)
+}
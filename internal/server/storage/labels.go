@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// labelNamePattern допустимое название метки, как и в Prometheus.
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabels проверяет, что названия всех меток допустимы.
+func ValidateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// FormatLabels возвращает набор меток в каноническом виде name="value",... с метками, упорядоченными
+// по названию. Для пустого набора возвращает пустую строку.
+func FormatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	return b.String()
+}
+
+// LabelsHashSuffix дополнение строки, от которой считается хеш метрики: ":" и метки в каноническом
+// виде. Для метрики без меток дополнение пустое и хеш считается как прежде.
+func LabelsHashSuffix(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return ":" + FormatLabels(labels)
+}
+
+// SeriesKey ключ ряда метрики: название, а при наличии меток - название и метки в каноническом виде
+// в фигурных скобках, например CPUutilization1{host="a"}.
+func SeriesKey(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+	return id + "{" + FormatLabels(labels) + "}"
+}
+
+// Key возвращает ключ ряда метрики.
+func (m *Metrics) Key() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+// ParseSeriesKey разбирает ключ ряда, полученный из SeriesKey, на название и метки. Ключ, который
+// не разбирается как название с метками, целиком считается названием.
+func ParseSeriesKey(key string) (string, map[string]string) {
+	i := strings.IndexByte(key, '{')
+	if i < 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+	labels, err := parseLabelSet(key[i+1 : len(key)-1])
+	if err != nil || len(labels) == 0 {
+		return key, nil
+	}
+	return key[:i], labels
+}
+
+// parseLabelSet разбирает набор меток в каноническом виде, полученном из FormatLabels.
+func parseLabelSet(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("bad label set %q", s)
+		}
+		name := s[:eq]
+		quoted, err := strconv.QuotedPrefix(s[eq+1:])
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, err
+		}
+		labels[name] = value
+		s = s[eq+1+len(quoted):]
+		if s == "" {
+			break
+		}
+		if s[0] != ',' {
+			return nil, fmt.Errorf("bad label set %q", s)
+		}
+		s = s[1:]
+	}
+	return labels, nil
+}
+
+// decodeLabels разбирает набор меток в каноническом виде, сохраненный в базе. Пустая или поврежденная
+// строка дает метрику без меток.
+func decodeLabels(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	labels, err := parseLabelSet(s)
+	if err != nil {
+		return nil
+	}
+	return labels
+}
+
+// ParseLabels разбирает набор меток вида name=value,name=value из параметра запроса или настроек.
+func ParseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("bad label %q, want name=value", pair)
+		}
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return labels, ValidateLabels(labels)
+}
+
+// MatchLabels проверяет, что метки labels содержат все метки selector с теми же значениями.
+func MatchLabels(labels, selector map[string]string) bool {
+	for name, value := range selector {
+		if v, ok := labels[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterMetrics возвращает метрики, метки которых подходят под selector. Пустой selector подходит
+// под все метрики.
+func FilterMetrics(metrics []Metrics, selector map[string]string) []Metrics {
+	if len(selector) == 0 {
+		return metrics
+	}
+	filtered := []Metrics{}
+	for _, m := range metrics {
+		if MatchLabels(m.Labels, selector) {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		labels map[string]string
+		want   string
+	}{
+		{name: "no labels", id: "Alloc", want: "Alloc"},
+		{name: "sorted labels", id: "CPUutilization1", labels: map[string]string{"region": "eu", "host": "a"},
+			want: `CPUutilization1{host="a",region="eu"}`},
+		{name: "quoted value", id: "Alloc", labels: map[string]string{"path": `a,"b"=c}`},
+			want: `Alloc{path="a,\"b\"=c}"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := SeriesKey(tt.id, tt.labels)
+			assert.Equal(t, key, tt.want)
+			id, labels := ParseSeriesKey(key)
+			assert.Equal(t, id, tt.id)
+			assert.Equal(t, labels, tt.labels)
+		})
+	}
+}
+
+func TestParseSeriesKey_Unlabeled(t *testing.T) {
+	for _, key := range []string{"Alloc{", "Alloc{}", "Alloc{x}", `Alloc{a="b"`, `Alloc{a="b"c}`} {
+		id, labels := ParseSeriesKey(key)
+		assert.Equal(t, id, key)
+		assert.Equal(t, len(labels), 0)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "pairs", s: "host=a, region=eu", want: map[string]string{"host": "a", "region": "eu"}},
+		{name: "empty value", s: "host=", want: map[string]string{"host": ""}},
+		{name: "no value", s: "host", wantErr: true},
+		{name: "bad name", s: "1host=a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLabels(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMetrics(t *testing.T) {
+	metrics := []Metrics{
+		{ID: "Alloc"},
+		{ID: "Alloc", Labels: map[string]string{"host": "a"}},
+		{ID: "Alloc", Labels: map[string]string{"host": "a", "region": "eu"}},
+		{ID: "Alloc", Labels: map[string]string{"host": "b"}},
+	}
+	assert.Equal(t, len(FilterMetrics(metrics, nil)), 4)
+	assert.Equal(t, len(FilterMetrics(metrics, map[string]string{"host": "a"})), 2)
+	assert.Equal(t, len(FilterMetrics(metrics, map[string]string{"host": "a", "region": "eu"})), 1)
+	assert.Equal(t, len(FilterMetrics(metrics, map[string]string{"host": "c"})), 0)
+}
+
+func TestStorage_Labels(t *testing.T) {
+	storages := []struct {
+		name string
+		st   func(t *testing.T) IStorage
+	}{
+		{name: "memory", st: func(t *testing.T) IStorage {
+			return &MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+				History:        NewHistory(time.Hour),
+			}
+		}},
+		{name: "sharded", st: func(t *testing.T) IStorage { return NewShardedStorage(4, NewHistory(time.Hour)) }},
+		{name: "sqlite", st: func(t *testing.T) IStorage { return newTestSQLiteStorage(t, time.Hour) }},
+	}
+	hostA := map[string]string{"host": "a"}
+	hostB := map[string]string{"host": "b"}
+	for _, ts := range storages {
+		t.Run(ts.name, func(t *testing.T) {
+			st := ts.st(t)
+			va, vb, d := 1.5, 2.5, int64(3)
+			err := st.InsertBatchMetric([]Metrics{
+				{ID: "CPUutilization1", MType: "gauge", Labels: hostA, Value: &va},
+				{ID: "CPUutilization1", MType: "gauge", Labels: hostB, Value: &vb},
+				{ID: "PollCount", MType: "counter", Labels: hostA, Delta: &d},
+				{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "PollCount", MType: "counter", Delta: &d},
+			})
+			assert.Equal(t, err, nil)
+
+			got, err := st.ReadMetric(&Metrics{ID: "CPUutilization1", MType: "gauge", Labels: hostB})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, *got.Value, vb)
+			got, err = st.ReadMetric(&Metrics{ID: "PollCount", MType: "counter", Labels: hostA})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, *got.Delta, d)
+			got, err = st.ReadMetric(&Metrics{ID: "PollCount", MType: "counter"})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, *got.Delta, 2*d)
+			_, err = st.ReadMetric(&Metrics{ID: "CPUutilization1", MType: "gauge"})
+			assert.NotEqual(t, err, nil)
+
+			all, err := st.ReadAllMetrics()
+			assert.Equal(t, err, nil)
+			keys := []string{}
+			for _, m := range all {
+				keys = append(keys, m.MType+":"+m.Key())
+			}
+			sort.Strings(keys)
+			assert.Equal(t, keys, []string{
+				`counter:PollCount`,
+				`counter:PollCount{host="a"}`,
+				`gauge:CPUutilization1{host="a"}`,
+				`gauge:CPUutilization1{host="b"}`,
+			})
+
+			samples, err := st.ReadHistory("gauge", SeriesKey("CPUutilization1", hostA),
+				time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 0)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(samples), 1)
+			assert.Equal(t, *samples[0].Value, va)
+		})
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strconv"
 	"sync"
+	"time"
 )
 
 var (
@@ -16,30 +16,90 @@ var (
 	errNotFound = fmt.Errorf("not found in memory storage")
 )
 
-// MemoryStorage структура, состоящая из двух массивов для двух типов метрик gauge и counter,
+// MemoryStorage структура, состоящая из массивов для типов метрик gauge, counter и histogram,
 // а также RWMutex для конкурентного доступа к ним. Реализует интерфейсный тип IStorage.
+// Если задана History, каждое принятое обновление gauge и counter дополнительно записывается в историю.
+// Для каждого ряда хранится время последнего обновления и признак устаревания, выставляемый SweepStale.
 type MemoryStorage struct {
-	GaugeMetrics   map[string]float64 // хранилище для gauge
-	CounterMetrics map[string]int64   // хранилище для counter
-	mutex          sync.RWMutex
+	GaugeMetrics     map[string]float64    // хранилище для gauge
+	CounterMetrics   map[string]int64      // хранилище для counter
+	HistogramMetrics map[string]*Histogram // хранилище для histogram, создается при первой записи
+	History          *History              // история значений, nil если отключена
+	updated          map[string]time.Time  // время последнего обновления по historyKey ряда
+	stale            map[string]bool       // устаревшие ряды по historyKey
+	mutex            sync.RWMutex
 }
 
-// InsertMetric потокобезопасно добавляет значения полученные из аргумента Metrics в массивы.
-// Для gauge заменяет существующий, для counter добавляет к уже существующему значению в базе.
+// InsertMetric потокобезопасно добавляет значения полученные из аргумента Metrics в массивы по ключу ряда.
+// Для gauge заменяет существующий, для counter и histogram добавляет к уже существующему значению в базе.
 func (m *MemoryStorage) InsertMetric(met *Metrics) error {
+	key := met.Key()
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	switch met.MType {
 	case "gauge":
-		m.GaugeMetrics[met.ID] = *met.Value
+		m.GaugeMetrics[key] = *met.Value
 	case "counter":
-		m.CounterMetrics[met.ID] += *met.Delta
+		m.CounterMetrics[key] += *met.Delta
+	case "histogram":
+		err := m.mergeHistogram(key, met.Histogram)
+		if err != nil {
+			return err
+		}
 	default:
 		return errWrType
 	}
+	m.touch(met.MType, key, time.Now())
 	return nil
 }
 
+// mergeHistogram добавляет гистограмму h к гистограмме ряда key. Вызывается под блокировкой mutex.
+func (m *MemoryStorage) mergeHistogram(key string, h *Histogram) error {
+	if h == nil {
+		return errNoValue
+	}
+	err := h.Validate()
+	if err != nil {
+		return err
+	}
+	if m.HistogramMetrics == nil {
+		m.HistogramMetrics = make(map[string]*Histogram)
+	}
+	if existing, ok := m.HistogramMetrics[key]; ok {
+		return existing.Merge(h)
+	}
+	m.HistogramMetrics[key] = h.Clone()
+	return nil
+}
+
+// touch запоминает время обновления ряда метрики, снимает с него признак устаревания и записывает
+// текущее значение в историю. Вызывается под блокировкой mutex.
+func (m *MemoryStorage) touch(mType, id string, ts time.Time) {
+	if m.updated == nil {
+		m.updated = make(map[string]time.Time)
+		m.stale = make(map[string]bool)
+	}
+	m.updated[historyKey(mType, id)] = ts
+	delete(m.stale, historyKey(mType, id))
+	m.recordHistory(mType, id, ts)
+}
+
+// recordHistory записывает текущее значение ряда метрики в историю, если она включена.
+// Вызывается под блокировкой mutex.
+func (m *MemoryStorage) recordHistory(mType, id string, ts time.Time) {
+	if m.History == nil {
+		return
+	}
+	switch mType {
+	case "gauge":
+		value := m.GaugeMetrics[id]
+		m.History.Record(mType, id, &value, nil, ts)
+	case "counter":
+		delta := m.CounterMetrics[id]
+		m.History.Record(mType, id, nil, &delta, ts)
+	}
+}
+
 // InsertBatchMetric в цикле выполняет InsertMetric из значений, полученных в качестве аргумента
 // в виде списка []Metrics.
 func (m *MemoryStorage) InsertBatchMetric(metrics []Metrics) error {
@@ -52,53 +112,181 @@ func (m *MemoryStorage) InsertBatchMetric(metrics []Metrics) error {
 	return nil
 }
 
+// DeleteMetrics потокобезопасно удаляет ряды метрик по типу, названию и меткам вместе с их историей.
+// Возвращает количество удаленных рядов, отсутствующие ряды пропускаются.
+func (m *MemoryStorage) DeleteMetrics(metrics []Metrics) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	deleted := 0
+	for _, met := range metrics {
+		key := met.Key()
+		found := false
+		switch met.MType {
+		case "gauge":
+			_, found = m.GaugeMetrics[key]
+			delete(m.GaugeMetrics, key)
+		case "counter":
+			_, found = m.CounterMetrics[key]
+			delete(m.CounterMetrics, key)
+		case "histogram":
+			_, found = m.HistogramMetrics[key]
+			delete(m.HistogramMetrics, key)
+		}
+		if !found {
+			continue
+		}
+		deleted++
+		m.forget(met.MType, key)
+	}
+	return deleted, nil
+}
+
+// forget удаляет время обновления, признак устаревания и историю удаленного ряда.
+// Вызывается под блокировкой mutex.
+func (m *MemoryStorage) forget(mType, id string) {
+	delete(m.updated, historyKey(mType, id))
+	delete(m.stale, historyKey(mType, id))
+	if m.History != nil {
+		m.History.Delete(mType, id)
+	}
+}
+
+// SweepStale потокобезопасно помечает устаревшими ряды, не обновлявшиеся с момента before, а если evict,
+// удаляет их вместе с историей. Возвращает ряды, которые были помечены или удалены этим вызовом.
+func (m *MemoryStorage) SweepStale(before time.Time, evict bool) ([]Metrics, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	swept := []Metrics{}
+	sweep := func(mType, key string) bool {
+		hk := historyKey(mType, key)
+		ts, ok := m.updated[hk]
+		if !ok || !ts.Before(before) || (!evict && m.stale[hk]) {
+			return false
+		}
+		id, labels := ParseSeriesKey(key)
+		swept = append(swept, Metrics{ID: id, MType: mType, Labels: labels})
+		if evict {
+			m.forget(mType, key)
+		} else {
+			m.stale[hk] = true
+		}
+		return evict
+	}
+	for key := range m.GaugeMetrics {
+		if sweep("gauge", key) {
+			delete(m.GaugeMetrics, key)
+		}
+	}
+	for key := range m.CounterMetrics {
+		if sweep("counter", key) {
+			delete(m.CounterMetrics, key)
+		}
+	}
+	for key := range m.HistogramMetrics {
+		if sweep("histogram", key) {
+			delete(m.HistogramMetrics, key)
+		}
+	}
+	return swept, nil
+}
+
+// ResetCounter потокобезопасно обнуляет значение counter и записывает его в историю. Если ряда нет,
+// возвращает false.
+func (m *MemoryStorage) ResetCounter(met *Metrics) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := met.Key()
+	if _, found := m.CounterMetrics[key]; !found {
+		return false, nil
+	}
+	m.CounterMetrics[key] = 0
+	m.touch("counter", key, time.Now())
+	return true, nil
+}
+
 // ReadMetric потокобезопасно получает значение искомой метрики и возвращает в виде структуры Metrics.
 // В случае если искомой метрики не существует, возвращает nil и ошибку.
 func (m *MemoryStorage) ReadMetric(rm *Metrics) (*Metrics, error) {
+	key := rm.Key()
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 	switch rm.MType {
 	case "gauge":
-		if value, found := m.GaugeMetrics[rm.ID]; found {
+		if value, found := m.GaugeMetrics[key]; found {
 			rm.Value = &value
 		} else {
 			return nil, errNotFound
 		}
 	case "counter":
-		if value, found := m.CounterMetrics[rm.ID]; found {
+		if value, found := m.CounterMetrics[key]; found {
 			rm.Delta = &value
 		} else {
 			return nil, errNotFound
 		}
+	case "histogram":
+		if h, found := m.HistogramMetrics[key]; found {
+			rm.Histogram = h.withQuantiles()
+		} else {
+			return nil, errNotFound
+		}
 	}
 	return rm, nil
 }
 
 // ReadAllMetrics потокобезопасно получает значение всех метрик и возвращает в виде списка структур []Metrics.
-// В случае если метрик неn, возвращает пустой список и nil.
+// В случае если метрик неn, возвращает пустой список и nil. Для каждой метрики заполняется время
+// последнего обновления и признак устаревания.
 func (m *MemoryStorage) ReadAllMetrics() ([]Metrics, error) {
 	metricsSlice := []Metrics{}
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 	for key, value := range m.GaugeMetrics {
+		v := value
+		id, labels := ParseSeriesKey(key)
 		metric := Metrics{
-			MType: "gauge",
-			ID:    key,
-			Value: &value,
+			MType:  "gauge",
+			ID:     id,
+			Labels: labels,
+			Value:  &v,
 		}
+		m.fillUpdated(&metric, key)
 		metricsSlice = append(metricsSlice, metric)
 	}
 	for key, value := range m.CounterMetrics {
+		v := value
+		id, labels := ParseSeriesKey(key)
 		metric := Metrics{
-			MType: "counter",
-			ID:    key,
-			Delta: &value,
+			MType:  "counter",
+			ID:     id,
+			Labels: labels,
+			Delta:  &v,
 		}
+		m.fillUpdated(&metric, key)
+		metricsSlice = append(metricsSlice, metric)
+	}
+	for key, h := range m.HistogramMetrics {
+		id, labels := ParseSeriesKey(key)
+		metric := Metrics{
+			MType:     "histogram",
+			ID:        id,
+			Labels:    labels,
+			Histogram: h.withQuantiles(),
+		}
+		m.fillUpdated(&metric, key)
 		metricsSlice = append(metricsSlice, metric)
 	}
 	return metricsSlice, nil
 }
 
+// fillUpdated заполняет время последнего обновления и признак устаревания метрики ряда key.
+// Вызывается под блокировкой mutex.
+func (m *MemoryStorage) fillUpdated(metric *Metrics, key string) {
+	if ts, ok := m.updated[historyKey(metric.MType, key)]; ok {
+		metric.Updated = &ts
+	}
+	metric.Stale = m.stale[historyKey(metric.MType, key)]
+}
+
 // ParamsUpdate потокобезопасно добавляет значения полученные из строчных аргументов в массивы.
 // Для gauge заменяет существующий, для counter добавляет к уже существующему значению в базе.
 // А также возвращает код, в зависимости от успешности операции для передачи его в handler.
@@ -112,6 +300,7 @@ func (m *MemoryStorage) ParamsUpdate(metricType, metricName, metricValue string)
 			return 400, err
 		}
 		m.GaugeMetrics[metricName] = floatFromString
+		m.touch(metricType, metricName, time.Now())
 		return 200, nil
 	case "counter":
 		intFromString, err := strconv.Atoi(metricValue)
@@ -119,19 +308,30 @@ func (m *MemoryStorage) ParamsUpdate(metricType, metricName, metricValue string)
 			return 400, err
 		}
 		m.CounterMetrics[metricName] += int64(intFromString)
+		m.touch(metricType, metricName, time.Now())
 		return 200, nil
 	default:
 		return 501, errors.New("wrong metric type - " + metricType)
 	}
 }
 
+// ReadHistory возвращает историю значений метрики в интервале [from, to] с шагом step.
+// Если история отключена, возвращает ошибку.
+func (m *MemoryStorage) ReadHistory(mType, id string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	if m.History == nil {
+		return nil, errNoHistory
+	}
+	return m.History.Query(mType, id, from, to, step), nil
+}
+
 // UploadFromFile потокобезопасно заполняет массивы метриками, полученными
-// из файла по пути.
+// из файла по пути. Время обновления и признак устаревания берутся из файла, а если их там нет,
+// метрика считается обновленной сейчас. Если история включена, она загружается из соседнего файла.
 func (m *MemoryStorage) UploadFromFile(path string) error {
 	var metricsSlice []Metrics
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	data, err := ioutil.ReadFile(path)
+	data, err := readSnapshot(path)
 	if err != nil {
 		return err
 	}
@@ -142,44 +342,81 @@ func (m *MemoryStorage) UploadFromFile(path string) error {
 	for _, val := range metricsSlice {
 		switch val.MType {
 		case "gauge":
-			m.GaugeMetrics[val.ID] = *val.Value
+			m.GaugeMetrics[val.Key()] = *val.Value
 		case "counter":
-			m.CounterMetrics[val.ID] += *val.Delta
+			m.CounterMetrics[val.Key()] += *val.Delta
+		case "histogram":
+			err = m.mergeHistogram(val.Key(), val.Histogram)
+			if err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		ts := time.Now()
+		if val.Updated != nil {
+			ts = *val.Updated
 		}
+		m.touch(val.MType, val.Key(), ts)
+		if val.Stale {
+			m.stale[historyKey(val.MType, val.Key())] = true
+		}
+	}
+	if m.History != nil {
+		// Загрузка метрик уже добавила точки в историю, сохраненная история их заменяет.
+		return m.History.Load(path + historyFileSuffix)
 	}
 	return nil
 }
 
-// SaveToFile сохраняет значения метрик в файл, собирая их в список структур Metrics.
+// SaveToFile сохраняет значения метрик в файл, собирая их в список структур Metrics. Список собирается
+// под блокировкой на чтение, сериализация выполняется уже без нее.
+// Если история включена, она сохраняется в соседний файл.
 func (m *MemoryStorage) SaveToFile(file *os.File) error {
+	return m.freeze().SaveToFile(file)
+}
+
+// freeze собирает копию метрик под блокировкой на чтение.
+func (m *MemoryStorage) freeze() *storageCopy {
 	var metricsSlice []Metrics
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	m.mutex.RLock()
 	for key, value := range m.GaugeMetrics {
 		v := value
-		metricsSlice = append(metricsSlice, Metrics{
-			ID:    key,
-			MType: "gauge",
-			Value: &v,
-		})
+		id, labels := ParseSeriesKey(key)
+		metric := Metrics{
+			ID:     id,
+			MType:  "gauge",
+			Labels: labels,
+			Value:  &v,
+		}
+		m.fillUpdated(&metric, key)
+		metricsSlice = append(metricsSlice, metric)
 	}
 	for key, value := range m.CounterMetrics {
 		v := value
-		metricsSlice = append(metricsSlice, Metrics{
-			ID:    key,
-			MType: "counter",
-			Delta: &v,
-		})
-	}
-	data, err := json.Marshal(metricsSlice)
-	if err != nil {
-		return err
+		id, labels := ParseSeriesKey(key)
+		metric := Metrics{
+			ID:     id,
+			MType:  "counter",
+			Labels: labels,
+			Delta:  &v,
+		}
+		m.fillUpdated(&metric, key)
+		metricsSlice = append(metricsSlice, metric)
 	}
-	_, err = file.Write(data)
-	if err != nil {
-		return err
+	for key, h := range m.HistogramMetrics {
+		id, labels := ParseSeriesKey(key)
+		metric := Metrics{
+			ID:        id,
+			MType:     "histogram",
+			Labels:    labels,
+			Histogram: h.Clone(),
+		}
+		m.fillUpdated(&metric, key)
+		metricsSlice = append(metricsSlice, metric)
 	}
-	return nil
+	m.mutex.RUnlock()
+	return &storageCopy{metrics: metricsSlice, history: m.History}
 }
 
 // Ping всегда возвращает ошибку. Необходим для реализации интерфейса.
@@ -42,7 +42,9 @@ func TestMemoryStorage_InsertMetric(t *testing.T) {
 			if err := tt.m.InsertMetric(tt.met); (err != nil) != tt.wantErr {
 				t.Errorf("MemoryStorage.InsertMetric() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			assert.Equal(t, tt.m, tt.wantMemSt)
+			assert.Equal(t, tt.m.GaugeMetrics, tt.wantMemSt.GaugeMetrics)
+			assert.Equal(t, tt.m.CounterMetrics, tt.wantMemSt.CounterMetrics)
+			assert.Equal(t, tt.m.HistogramMetrics, tt.wantMemSt.HistogramMetrics)
 		})
 	}
 }
@@ -116,7 +118,9 @@ func TestMemoryStorage_InsertBatchMetric(t *testing.T) {
 				t.Errorf("MemoryStorage.InsertMetric() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if err == nil {
-				assert.Equal(t, tt.m, tt.wantMemSt)
+				assert.Equal(t, tt.m.GaugeMetrics, tt.wantMemSt.GaugeMetrics)
+				assert.Equal(t, tt.m.CounterMetrics, tt.wantMemSt.CounterMetrics)
+				assert.Equal(t, tt.m.HistogramMetrics, tt.wantMemSt.HistogramMetrics)
 			}
 		})
 	}
@@ -375,7 +379,9 @@ func TestMemoryStorage_ParamsUpdate(t *testing.T) {
 			if got != tt.want {
 				t.Errorf("MemoryStorage.ParamsUpdate() = %v, want %v", got, tt.want)
 			}
-			assert.Equal(t, tt.m, tt.wantMemSt)
+			assert.Equal(t, tt.m.GaugeMetrics, tt.wantMemSt.GaugeMetrics)
+			assert.Equal(t, tt.m.CounterMetrics, tt.wantMemSt.CounterMetrics)
+			assert.Equal(t, tt.m.HistogramMetrics, tt.wantMemSt.HistogramMetrics)
 		})
 	}
 }
@@ -447,7 +453,9 @@ func TestMemoryStorage_UploadFromFile(t *testing.T) {
 				t.Errorf("MemoryStorage.UploadFromFile() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if err == nil {
-				assert.Equal(t, tt.m, tt.wantMemSt)
+				assert.Equal(t, tt.m.GaugeMetrics, tt.wantMemSt.GaugeMetrics)
+				assert.Equal(t, tt.m.CounterMetrics, tt.wantMemSt.CounterMetrics)
+				assert.Equal(t, tt.m.HistogramMetrics, tt.wantMemSt.HistogramMetrics)
 			}
 		})
 	}
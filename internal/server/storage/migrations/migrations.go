@@ -0,0 +1,296 @@
+// Package migrations определяет версионированные миграции схемы базы метрик. Миграции встроены в бинарный файл
+// сервера, каждая из них задается парой файлов sql/NNNN_name.up.sql и sql/NNNN_name.down.sql. Примененные
+// версии хранятся в таблице schema_migrations.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey ключ advisory блокировки, под которой применяются миграции, чтобы несколько одновременно
+// запущенных серверов не применили одну миграцию дважды.
+const lockKey = 7305921
+
+// Conn методы подключения к базе, которые использует Migrator. Им удовлетворяют *pgx.Conn и *pgx.ConnPool.
+type Conn interface {
+	ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error)
+	QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*pgx.Rows, error)
+	BeginEx(ctx context.Context, txOptions *pgx.TxOptions) (*pgx.Tx, error)
+}
+
+// Migration одна версия схемы: запросы для ее применения и отката.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status состояние миграции в базе. AppliedAt заполнено только для примененных миграций.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator применяет и откатывает миграции Migrations, упорядоченные по возрастанию версии.
+type Migrator struct {
+	Conn       Conn
+	Migrations []Migration
+}
+
+// NewMigrator функция-конструктор, создающая Migrator со встроенными в сервер миграциями.
+func NewMigrator(conn Conn) (*Migrator, error) {
+	sqlFiles, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(sqlFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		Conn:       conn,
+		Migrations: migrations,
+	}, nil
+}
+
+// Load читает миграции из корня fsys. Файлы должны называться NNNN_name.up.sql или NNNN_name.down.sql,
+// у каждой версии обязателен up файл. Возвращает миграции, упорядоченные по версии.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		version, name, direction, err := parseFileName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// parseFileName разбирает имя файла миграции NNNN_name.up.sql на версию, название и направление.
+func parseFileName(fileName string) (int, string, string, error) {
+	base := strings.TrimSuffix(fileName, ".sql")
+	dot := strings.LastIndex(base, ".")
+	if dot < 0 {
+		return 0, "", "", fmt.Errorf("migration %s: no up or down suffix", fileName)
+	}
+	direction := base[dot+1:]
+	if direction != "up" && direction != "down" {
+		return 0, "", "", fmt.Errorf("migration %s: unknown direction %s", fileName, direction)
+	}
+	parts := strings.SplitN(base[:dot], "_", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", "", fmt.Errorf("migration %s: name must be NNNN_name", fileName)
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil || version <= 0 {
+		return 0, "", "", fmt.Errorf("migration %s: invalid version %s", fileName, parts[0])
+	}
+	return version, parts[1], direction, nil
+}
+
+// ensureTable создает таблицу примененных версий, если она не существует.
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	if m.Conn == nil {
+		return errors.New("no database connection")
+	}
+	_, err := m.Conn.ExecEx(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`, nil)
+	return err
+}
+
+// applied возвращает примененные версии и время их применения.
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.Conn.QueryEx(ctx, "SELECT version, applied_at FROM schema_migrations;", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		versions[int(version)] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// Up применяет все еще не примененные миграции по возрастанию версии, каждую в своей транзакции.
+// Возвращает количество примененных миграций.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	err := m.ensureTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+	done, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, migration := range m.Migrations {
+		if _, ok := done[migration.Version]; ok {
+			continue
+		}
+		applied, err := m.apply(ctx, migration, true)
+		if err != nil {
+			return count, fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+		}
+		if applied {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Down откатывает steps последних примененных миграций по убыванию версии. Возвращает количество
+// откаченных миграций.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	err := m.ensureTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+	done, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := len(m.Migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.Migrations[i]
+		if _, ok := done[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return count, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		reverted, err := m.apply(ctx, migration, false)
+		if err != nil {
+			return count, fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+		}
+		if reverted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// apply применяет (up) или откатывает миграцию в транзакции под advisory блокировкой. Состояние версии
+// перепроверяется после получения блокировки, если его уже изменил другой сервер, возвращает false.
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) (bool, error) {
+	tx, err := m.Conn.BeginEx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	// После успешного Commit откат ничего не делает.
+	defer tx.RollbackEx(ctx)
+	_, err = tx.ExecEx(ctx, "SELECT pg_advisory_xact_lock($1);", nil, int64(lockKey))
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = tx.QueryRowEx(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1);",
+		nil, int64(migration.Version)).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists == up {
+		return false, nil
+	}
+	if up {
+		_, err = tx.ExecEx(ctx, migration.Up, nil)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.ExecEx(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2);",
+			nil, int64(migration.Version), migration.Name)
+	} else {
+		_, err = tx.ExecEx(ctx, migration.Down, nil)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.ExecEx(ctx, "DELETE FROM schema_migrations WHERE version = $1;", nil, int64(migration.Version))
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.CommitEx(ctx)
+}
+
+// Status возвращает состояние всех известных серверу миграций по возрастанию версии.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	err := m.ensureTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		appliedAt, ok := done[migration.Version]
+		statuses = append(statuses, Status{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,119 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-playground/assert"
+	"github.com/jackc/pgx"
+)
+
+func TestNewMigrator(t *testing.T) {
+	m, err := NewMigrator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range m.Migrations {
+		assert.Equal(t, migration.Version, i+1)
+		if migration.Up == "" || migration.Down == "" {
+			t.Errorf("migration %d_%s must have up and down files", migration.Version, migration.Name)
+		}
+	}
+	if len(m.Migrations) < 3 {
+		t.Errorf("embedded migrations = %d, want at least 3", len(m.Migrations))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"0002_second.up.sql":  {Data: []byte("up 2")},
+				"0001_first.up.sql":   {Data: []byte("up 1")},
+				"0001_first.down.sql": {Data: []byte("down 1")},
+				"README.md":           {Data: []byte("skipped")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+				{Version: 2, Name: "second", Up: "up 2"},
+			},
+		},
+		{
+			name:    "no up file",
+			fsys:    fstest.MapFS{"0001_first.down.sql": {Data: []byte("down 1")}},
+			wantErr: true,
+		},
+		{
+			name: "different names",
+			fsys: fstest.MapFS{
+				"0001_first.up.sql":   {Data: []byte("up 1")},
+				"0001_other.down.sql": {Data: []byte("down 1")},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no direction",
+			fsys:    fstest.MapFS{"0001_first.sql": {Data: []byte("up 1")}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown direction",
+			fsys:    fstest.MapFS{"0001_first.sideways.sql": {Data: []byte("up 1")}},
+			wantErr: true,
+		},
+		{
+			name:    "no name",
+			fsys:    fstest.MapFS{"0001.up.sql": {Data: []byte("up 1")}},
+			wantErr: true,
+		},
+		{
+			name:    "bad version",
+			fsys:    fstest.MapFS{"first_migration.up.sql": {Data: []byte("up 1")}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrator_dbErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *Migrator
+	}{
+		{name: "no connection", m: &Migrator{}},
+		{name: "db err", m: &Migrator{Conn: &pgx.Conn{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if _, err := tt.m.Up(ctx); err == nil {
+				t.Error("Migrator.Up() expected error")
+			}
+			if _, err := tt.m.Down(ctx, 1); err == nil {
+				t.Error("Migrator.Down() expected error")
+			}
+			if _, err := tt.m.Status(ctx); err == nil {
+				t.Error("Migrator.Status() expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rt_metrics (
+	id TEXT UNIQUE,
+	mtype TEXT,
+	delta BIGINT,
+	value DOUBLE PRECISION,
+	hash TEXT
+);
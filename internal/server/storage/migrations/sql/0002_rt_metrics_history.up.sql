@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS rt_metrics_history (
+	id TEXT,
+	mtype TEXT,
+	delta BIGINT,
+	value DOUBLE PRECISION,
+	ts TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS rt_metrics_history_idx ON rt_metrics_history (mtype, id, ts);
@@ -0,0 +1 @@
+ALTER TABLE rt_metrics DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,7 @@
+ALTER TABLE rt_metrics ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE rt_metrics m SET updated_at = h.ts
+	FROM (SELECT id, mtype, max(ts) AS ts FROM rt_metrics_history GROUP BY id, mtype) h
+	WHERE h.id = m.id AND h.mtype = m.mtype AND m.updated_at IS NULL;
+UPDATE rt_metrics SET updated_at = now() WHERE updated_at IS NULL;
+ALTER TABLE rt_metrics ALTER COLUMN updated_at SET DEFAULT now();
+ALTER TABLE rt_metrics ALTER COLUMN updated_at SET NOT NULL;
@@ -0,0 +1,8 @@
+DELETE FROM rt_metrics WHERE labels <> '';
+DELETE FROM rt_metrics_history WHERE labels <> '';
+DROP INDEX IF EXISTS rt_metrics_history_idx;
+CREATE INDEX rt_metrics_history_idx ON rt_metrics_history (mtype, id, ts);
+ALTER TABLE rt_metrics_history DROP COLUMN IF EXISTS labels;
+ALTER TABLE rt_metrics DROP CONSTRAINT IF EXISTS rt_metrics_id_labels_key;
+ALTER TABLE rt_metrics ADD CONSTRAINT rt_metrics_id_key UNIQUE (id);
+ALTER TABLE rt_metrics DROP COLUMN IF EXISTS labels;
@@ -0,0 +1,6 @@
+ALTER TABLE rt_metrics ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT '';
+ALTER TABLE rt_metrics DROP CONSTRAINT IF EXISTS rt_metrics_id_key;
+ALTER TABLE rt_metrics ADD CONSTRAINT rt_metrics_id_labels_key UNIQUE (id, labels);
+ALTER TABLE rt_metrics_history ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS rt_metrics_history_idx;
+CREATE INDEX rt_metrics_history_idx ON rt_metrics_history (mtype, id, labels, ts);
@@ -0,0 +1,2 @@
+DELETE FROM rt_metrics WHERE mtype = 'histogram';
+ALTER TABLE rt_metrics DROP COLUMN IF EXISTS histogram;
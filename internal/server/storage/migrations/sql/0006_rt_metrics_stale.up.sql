@@ -0,0 +1 @@
+ALTER TABLE rt_metrics ADD COLUMN IF NOT EXISTS stale BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS rt_metrics_history_ts_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS rt_metrics_history_ts_idx ON rt_metrics_history (ts);
@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultShards количество сегментов ShardedStorage по умолчанию.
+const DefaultShards = 32
+
+// memoryShard сегмент ShardedStorage со своей блокировкой.
+type memoryShard struct {
+	gauges     map[string]float64
+	counters   map[string]int64
+	histograms map[string]*Histogram
+	updated    map[string]time.Time // время последнего обновления по historyKey ряда
+	stale      map[string]bool      // устаревшие ряды по historyKey
+	mutex      sync.RWMutex
+}
+
+// touch запоминает время обновления ряда метрики и снимает с него признак устаревания.
+// Вызывается под блокировкой сегмента.
+func (sh *memoryShard) touch(mType, key string, ts time.Time) {
+	sh.updated[historyKey(mType, key)] = ts
+	delete(sh.stale, historyKey(mType, key))
+}
+
+// fillUpdated заполняет время последнего обновления и признак устаревания метрики ряда key.
+// Вызывается под блокировкой сегмента.
+func (sh *memoryShard) fillUpdated(metric *Metrics, key string) {
+	if ts, ok := sh.updated[historyKey(metric.MType, key)]; ok {
+		metric.Updated = &ts
+	}
+	metric.Stale = sh.stale[historyKey(metric.MType, key)]
+}
+
+// ShardedStorage хранилище метрик в памяти, разбитое на сегменты по хешу ключа ряда метрики. Каждый сегмент
+// блокируется отдельно, поэтому обновления разных метрик не ждут друг друга. Семантика та же, что и у
+// MemoryStorage: gauge заменяется, к counter добавляется прирост. Списки метрик и снимки собираются из копий
+// сегментов, так что сериализация не блокирует запись. Если задана History, каждое обновление записывается
+// в историю.
+type ShardedStorage struct {
+	History *History
+	shards  []*memoryShard
+}
+
+// NewShardedStorage функция-конструктор для ShardedStorage с заданным количеством сегментов.
+func NewShardedStorage(shards int, history *History) *ShardedStorage {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	s := &ShardedStorage{
+		History: history,
+		shards:  make([]*memoryShard, shards),
+	}
+	for i := range s.shards {
+		s.shards[i] = &memoryShard{
+			gauges:     make(map[string]float64),
+			counters:   make(map[string]int64),
+			histograms: make(map[string]*Histogram),
+			updated:    make(map[string]time.Time),
+			stale:      make(map[string]bool),
+		}
+	}
+	return s
+}
+
+// shard возвращает сегмент ряда метрики по хешу его ключа.
+func (s *ShardedStorage) shard(key string) *memoryShard {
+	return s.shards[shardIndex(key, len(s.shards))]
+}
+
+// shardIndex возвращает номер сегмента из n для ключа key по хешу FNV-1a.
+func shardIndex(key string, n int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
+
+// update применяет обновление к сегменту ряда метрики и записывает новое значение gauge и counter в историю.
+// Если replaceCounter, значение counter заменяется, а не накапливается. Гистограммы всегда складываются.
+// Временем обновления ряда становится ts.
+func (s *ShardedStorage) update(m *Metrics, replaceCounter bool, ts time.Time) error {
+	key := m.Key()
+	sh := s.shard(key)
+	switch m.MType {
+	case "gauge":
+		if m.Value == nil {
+			return errNoValue
+		}
+		sh.mutex.Lock()
+		defer sh.mutex.Unlock()
+		sh.gauges[key] = *m.Value
+		sh.touch(m.MType, key, ts)
+		s.recordHistory(m.MType, key, m.Value, nil, ts)
+	case "counter":
+		if m.Delta == nil {
+			return errNoValue
+		}
+		sh.mutex.Lock()
+		defer sh.mutex.Unlock()
+		if replaceCounter {
+			sh.counters[key] = *m.Delta
+		} else {
+			sh.counters[key] += *m.Delta
+		}
+		delta := sh.counters[key]
+		sh.touch(m.MType, key, ts)
+		s.recordHistory(m.MType, key, nil, &delta, ts)
+	case "histogram":
+		if m.Histogram == nil {
+			return errNoValue
+		}
+		err := m.Histogram.Validate()
+		if err != nil {
+			return err
+		}
+		sh.mutex.Lock()
+		defer sh.mutex.Unlock()
+		if existing, ok := sh.histograms[key]; ok {
+			err = existing.Merge(m.Histogram)
+			if err != nil {
+				return err
+			}
+		} else {
+			sh.histograms[key] = m.Histogram.Clone()
+		}
+		sh.touch(m.MType, key, ts)
+	default:
+		return errWrType
+	}
+	return nil
+}
+
+// recordHistory записывает значение метрики в историю, если она включена. Вызывается под блокировкой
+// сегмента, чтобы точки одной метрики записывались в порядке обновлений.
+func (s *ShardedStorage) recordHistory(mType, id string, value *float64, delta *int64, ts time.Time) {
+	if s.History == nil {
+		return
+	}
+	s.History.Record(mType, id, value, delta, ts)
+}
+
+// InsertMetric добавляет значение метрики из аргумента Metrics. Для gauge заменяет существующее,
+// для counter добавляет к уже существующему значению.
+func (s *ShardedStorage) InsertMetric(m *Metrics) error {
+	return s.update(m, false, time.Now())
+}
+
+// InsertBatchMetric в цикле выполняет InsertMetric из значений, полученных в качестве аргумента
+// в виде списка []Metrics.
+func (s *ShardedStorage) InsertBatchMetric(metrics []Metrics) error {
+	for i := range metrics {
+		err := s.update(&metrics[i], false, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParamsUpdate работает как и InsertMetric, за тем исключением, что параметры для обновления
+// будут получены из аргументов функции (строк). Возвращает код для передачи его в handler.
+func (s *ShardedStorage) ParamsUpdate(metricType, metricName, metricValue string) (int, error) {
+	m := Metrics{ID: metricName, MType: metricType}
+	switch metricType {
+	case "gauge":
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return 400, err
+		}
+		m.Value = &value
+	case "counter":
+		delta, err := strconv.Atoi(metricValue)
+		if err != nil {
+			return 400, err
+		}
+		d := int64(delta)
+		m.Delta = &d
+	default:
+		return 501, errors.New("wrong metric type - " + metricType)
+	}
+	err := s.update(&m, false, time.Now())
+	if err != nil {
+		return 500, err
+	}
+	return 200, nil
+}
+
+// DeleteMetrics удаляет ряды метрик по типу, названию и меткам вместе с их историей. Возвращает количество
+// удаленных рядов, отсутствующие ряды пропускаются.
+func (s *ShardedStorage) DeleteMetrics(metrics []Metrics) (int, error) {
+	deleted := 0
+	for _, m := range metrics {
+		key := m.Key()
+		sh := s.shard(key)
+		found := false
+		sh.mutex.Lock()
+		switch m.MType {
+		case "gauge":
+			_, found = sh.gauges[key]
+			delete(sh.gauges, key)
+		case "counter":
+			_, found = sh.counters[key]
+			delete(sh.counters, key)
+		case "histogram":
+			_, found = sh.histograms[key]
+			delete(sh.histograms, key)
+		}
+		if found {
+			s.forget(sh, m.MType, key)
+		}
+		sh.mutex.Unlock()
+		if found {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// forget удаляет время обновления, признак устаревания и историю удаленного ряда.
+// Вызывается под блокировкой сегмента.
+func (s *ShardedStorage) forget(sh *memoryShard, mType, key string) {
+	delete(sh.updated, historyKey(mType, key))
+	delete(sh.stale, historyKey(mType, key))
+	if s.History != nil {
+		s.History.Delete(mType, key)
+	}
+}
+
+// SweepStale помечает устаревшими ряды, не обновлявшиеся с момента before, а если evict, удаляет их вместе
+// с историей. Сегменты блокируются по одному. Возвращает ряды, которые были помечены или удалены этим вызовом.
+func (s *ShardedStorage) SweepStale(before time.Time, evict bool) ([]Metrics, error) {
+	swept := []Metrics{}
+	for _, sh := range s.shards {
+		sh.mutex.Lock()
+		for hk, ts := range sh.updated {
+			if !ts.Before(before) || (!evict && sh.stale[hk]) {
+				continue
+			}
+			mType, key := splitHistoryKey(hk)
+			id, labels := ParseSeriesKey(key)
+			swept = append(swept, Metrics{ID: id, MType: mType, Labels: labels})
+			if !evict {
+				sh.stale[hk] = true
+				continue
+			}
+			switch mType {
+			case "gauge":
+				delete(sh.gauges, key)
+			case "counter":
+				delete(sh.counters, key)
+			case "histogram":
+				delete(sh.histograms, key)
+			}
+			s.forget(sh, mType, key)
+		}
+		sh.mutex.Unlock()
+	}
+	return swept, nil
+}
+
+// ResetCounter обнуляет значение counter и записывает его в историю. Если ряда нет, возвращает false.
+func (s *ShardedStorage) ResetCounter(m *Metrics) (bool, error) {
+	key := m.Key()
+	sh := s.shard(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	if _, found := sh.counters[key]; !found {
+		return false, nil
+	}
+	sh.counters[key] = 0
+	now := time.Now()
+	sh.touch("counter", key, now)
+	var delta int64
+	s.recordHistory("counter", key, nil, &delta, now)
+	return true, nil
+}
+
+// ReadMetric получает значение искомой метрики и возвращает в виде структуры Metrics.
+// В случае если искомой метрики не существует, возвращает nil и ошибку.
+func (s *ShardedStorage) ReadMetric(rm *Metrics) (*Metrics, error) {
+	key := rm.Key()
+	sh := s.shard(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+	switch rm.MType {
+	case "gauge":
+		value, found := sh.gauges[key]
+		if !found {
+			return nil, errNotFound
+		}
+		rm.Value = &value
+	case "counter":
+		value, found := sh.counters[key]
+		if !found {
+			return nil, errNotFound
+		}
+		rm.Delta = &value
+	case "histogram":
+		h, found := sh.histograms[key]
+		if !found {
+			return nil, errNotFound
+		}
+		rm.Histogram = h.withQuantiles()
+	default:
+		return nil, errNotFound
+	}
+	return rm, nil
+}
+
+// snapshot собирает копию всех метрик с временем обновления и признаком устаревания, блокируя на чтение
+// по одному сегменту за раз. Если quantiles, к гистограммам добавляются оценки квантилей.
+func (s *ShardedStorage) snapshot(quantiles bool) []Metrics {
+	metricsSlice := []Metrics{}
+	for _, sh := range s.shards {
+		sh.mutex.RLock()
+		for key, value := range sh.gauges {
+			v := value
+			id, labels := ParseSeriesKey(key)
+			metric := Metrics{ID: id, MType: "gauge", Labels: labels, Value: &v}
+			sh.fillUpdated(&metric, key)
+			metricsSlice = append(metricsSlice, metric)
+		}
+		for key, value := range sh.counters {
+			v := value
+			id, labels := ParseSeriesKey(key)
+			metric := Metrics{ID: id, MType: "counter", Labels: labels, Delta: &v}
+			sh.fillUpdated(&metric, key)
+			metricsSlice = append(metricsSlice, metric)
+		}
+		for key, h := range sh.histograms {
+			id, labels := ParseSeriesKey(key)
+			metric := Metrics{ID: id, MType: "histogram", Labels: labels, Histogram: h.Clone()}
+			if quantiles {
+				metric.Histogram = h.withQuantiles()
+			}
+			sh.fillUpdated(&metric, key)
+			metricsSlice = append(metricsSlice, metric)
+		}
+		sh.mutex.RUnlock()
+	}
+	return metricsSlice
+}
+
+// ReadAllMetrics возвращает копию всех метрик. В случае если метрик нет, возвращает пустой список и nil.
+// Для каждой метрики заполняется время последнего обновления и признак устаревания.
+func (s *ShardedStorage) ReadAllMetrics() ([]Metrics, error) {
+	return s.snapshot(true), nil
+}
+
+// ReadHistory возвращает историю значений метрики в интервале [from, to] с шагом step.
+// Если история отключена, возвращает ошибку.
+func (s *ShardedStorage) ReadHistory(mType, id string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	if s.History == nil {
+		return nil, errNoHistory
+	}
+	return s.History.Query(mType, id, from, to, step), nil
+}
+
+// SaveToFile сохраняет копию всех метрик в файл. Сериализация выполняется без блокировок.
+// Если история включена, она сохраняется в соседний файл.
+func (s *ShardedStorage) SaveToFile(file *os.File) error {
+	return s.freeze().SaveToFile(file)
+}
+
+// freeze собирает копию метрик, блокируя шарды по очереди.
+func (s *ShardedStorage) freeze() *storageCopy {
+	return &storageCopy{metrics: s.snapshot(false), history: s.History}
+}
+
+// UploadFromFile заполняет хранилище метриками, полученными из файла по пути, так же как
+// MemoryStorage.UploadFromFile, вместе с временем обновления и признаком устаревания.
+// Если история включена, она загружается из соседнего файла.
+func (s *ShardedStorage) UploadFromFile(path string) error {
+	var metricsSlice []Metrics
+	data, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, &metricsSlice)
+	if err != nil {
+		return err
+	}
+	for i := range metricsSlice {
+		ts := time.Now()
+		if metricsSlice[i].Updated != nil {
+			ts = *metricsSlice[i].Updated
+		}
+		err = s.update(&metricsSlice[i], false, ts)
+		if err != nil {
+			return err
+		}
+		if metricsSlice[i].Stale {
+			key := metricsSlice[i].Key()
+			sh := s.shard(key)
+			sh.mutex.Lock()
+			sh.stale[historyKey(metricsSlice[i].MType, key)] = true
+			sh.mutex.Unlock()
+		}
+	}
+	if s.History != nil {
+		// Загрузка метрик уже добавила точки в историю, сохраненная история их заменяет.
+		return s.History.Load(path + historyFileSuffix)
+	}
+	return nil
+}
+
+// Ping всегда возвращает ошибку. Необходим для реализации интерфейса.
+func (s *ShardedStorage) Ping() error {
+	return errNoDB
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestShardedStorage_InsertBatchMetric(t *testing.T) {
+	var (
+		v float64 = 3.14
+		d int64   = 5
+	)
+	tests := []struct {
+		name    string
+		metrics []Metrics
+		want    []Metrics
+		wantErr bool
+	}{
+		{
+			name: "gauge and counter",
+			metrics: []Metrics{
+				{ID: "Alloc", MType: "gauge", Value: &v},
+				{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "PollCount", MType: "counter", Delta: &d},
+			},
+			want: []Metrics{
+				{ID: "Alloc", MType: "gauge", Value: &v},
+				{ID: "PollCount", MType: "counter", Delta: func() *int64 { s := 2 * d; return &s }()},
+			},
+		},
+		{
+			name:    "wrong type",
+			metrics: []Metrics{{ID: "Alloc", MType: "histogram", Value: &v}},
+			want:    []Metrics{},
+			wantErr: true,
+		},
+		{
+			name:    "no value",
+			metrics: []Metrics{{ID: "Alloc", MType: "gauge"}},
+			want:    []Metrics{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewShardedStorage(4, nil)
+			if err := s.InsertBatchMetric(tt.metrics); (err != nil) != tt.wantErr {
+				t.Errorf("ShardedStorage.InsertBatchMetric() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := s.ReadAllMetrics()
+			assert.Equal(t, err, nil)
+			sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+			for i := range got {
+				assert.NotEqual(t, got[i].Updated, nil)
+				got[i].Updated = nil
+			}
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestShardedStorage_ParamsUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		mType     string
+		mName     string
+		value     string
+		want      *Metrics
+		wantCode  int
+		wantError bool
+	}{
+		{name: "gauge", mType: "gauge", mName: "Alloc", value: "1.5", wantCode: 200,
+			want: &Metrics{ID: "Alloc", MType: "gauge", Value: func() *float64 { v := 1.5; return &v }()}},
+		{name: "counter", mType: "counter", mName: "PollCount", value: "7", wantCode: 200,
+			want: &Metrics{ID: "PollCount", MType: "counter", Delta: func() *int64 { d := int64(7); return &d }()}},
+		{name: "bad value", mType: "counter", mName: "PollCount", value: "seven", wantCode: 400, wantError: true},
+		{name: "wrong type", mType: "histogram", mName: "Alloc", value: "1", wantCode: 501, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewShardedStorage(4, nil)
+			code, err := s.ParamsUpdate(tt.mType, tt.mName, tt.value)
+			if (err != nil) != tt.wantError {
+				t.Errorf("ShardedStorage.ParamsUpdate() error = %v, wantErr %v", err, tt.wantError)
+			}
+			assert.Equal(t, code, tt.wantCode)
+			if tt.want != nil {
+				got, err := s.ReadMetric(&Metrics{ID: tt.mName, MType: tt.mType})
+				assert.Equal(t, err, nil)
+				assert.Equal(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardedStorage_Concurrent(t *testing.T) {
+	s := NewShardedStorage(8, NewHistory(time.Hour))
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := int64(1)
+			v := float64(i)
+			for j := 0; j < 100; j++ {
+				s.InsertBatchMetric([]Metrics{
+					{ID: "PollCount", MType: "counter", Delta: &d},
+					{ID: fmt.Sprintf("Gauge%d", j), MType: "gauge", Value: &v},
+				})
+			}
+		}(i)
+		// Списки метрик собираются параллельно с обновлениями.
+		go s.ReadAllMetrics()
+	}
+	wg.Wait()
+	got, err := s.ReadMetric(&Metrics{ID: "PollCount", MType: "counter"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *got.Delta, int64(10000))
+	all, _ := s.ReadAllMetrics()
+	assert.Equal(t, len(all), 101)
+}
+
+func TestShardedStorage_SaveAndUpload(t *testing.T) {
+	var (
+		v float64 = 3.14
+		d int64   = 5
+	)
+	s := NewShardedStorage(4, nil)
+	assert.Equal(t, s.InsertBatchMetric([]Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &v},
+		{ID: "PollCount", MType: "counter", Delta: &d},
+	}), nil)
+	fs := &FileStorage{FilePath: filepath.Join(t.TempDir(), "metrics.json")}
+	assert.Equal(t, fs.SaveStorageToFile(s), nil)
+	if _, err := os.Stat(fs.FilePath); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewShardedStorage(16, nil)
+	assert.Equal(t, restored.UploadFromFile(fs.FilePath), nil)
+	got, err := restored.ReadMetric(&Metrics{ID: "Alloc", MType: "gauge"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *got.Value, v)
+	got, err = restored.ReadMetric(&Metrics{ID: "PollCount", MType: "counter"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *got.Delta, d)
+}
+
+// benchmarkBatch набор метрик, который агент отправляет за один запрос.
+// benchmarkBatch список метрик одного агента: ряды разных агентов различаются меткой agent.
+func benchmarkBatch(agent int) []Metrics {
+	labels := map[string]string{"agent": strconv.Itoa(agent)}
+	metrics := make([]Metrics, 0, 30)
+	for i := 0; i < 29; i++ {
+		v := float64(i)
+		metrics = append(metrics, Metrics{ID: fmt.Sprintf("Gauge%d", i), MType: "gauge", Labels: labels, Value: &v})
+	}
+	d := int64(1)
+	return append(metrics, Metrics{ID: "PollCount", MType: "counter", Labels: labels, Delta: &d})
+}
+
+// BenchmarkInsertBatchMetric сравнивает хранилища в настройках сервера по умолчанию: история глубиной
+// сутки включена, у сегментированного хранилища DefaultShards сегментов.
+func BenchmarkInsertBatchMetric(b *testing.B) {
+	storages := []struct {
+		name string
+		st   func() IStorage
+	}{
+		{name: "memory", st: func() IStorage {
+			return &MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+				History:        NewHistory(24 * time.Hour),
+			}
+		}},
+		{name: "sharded", st: func() IStorage { return NewShardedStorage(DefaultShards, NewHistory(24*time.Hour)) }},
+	}
+	for _, bs := range storages {
+		b.Run(bs.name, func(b *testing.B) {
+			st := bs.st()
+			var agents int32
+			// Сотни агентов отправляют метрики одновременно.
+			b.SetParallelism(64)
+			b.RunParallel(func(pb *testing.PB) {
+				metrics := benchmarkBatch(int(atomic.AddInt32(&agents, 1)))
+				for pb.Next() {
+					st.InsertBatchMetric(metrics)
+				}
+			})
+		})
+	}
+}
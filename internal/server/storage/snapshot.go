@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotMagic начало заголовка снимка. Заголовок - строка фиксированной длины с sha256 содержимого
+// снимка после нее, что позволяет записать его после сохранения содержимого.
+const snapshotMagic = "RTMETRICS sha256="
+
+// snapshotHeaderLen длина заголовка снимка вместе с переводом строки.
+const snapshotHeaderLen = len(snapshotMagic) + sha256.Size*2 + 1
+
+// snapshotTimeFormat формат отметки времени в именах предыдущих снимков, упорядочиваемый как строка.
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+var errSnapshotChecksum = fmt.Errorf("snapshot checksum mismatch")
+
+// readSnapshot читает снимок и возвращает его содержимое без заголовка, проверив контрольную сумму.
+// Снимки без заголовка, записанные предыдущими версиями сервера, возвращаются как есть.
+func readSnapshot(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return data, nil
+	}
+	if len(data) < snapshotHeaderLen || data[snapshotHeaderLen-1] != '\n' {
+		return nil, errSnapshotChecksum
+	}
+	body := data[snapshotHeaderLen:]
+	sum := sha256.Sum256(body)
+	if string(data[len(snapshotMagic):snapshotHeaderLen-1]) != hex.EncodeToString(sum[:]) {
+		return nil, errSnapshotChecksum
+	}
+	return body, nil
+}
+
+// snapshotSource источник содержимого снимка: хранилище или его копия storageCopy.
+type snapshotSource interface {
+	SaveToFile(*os.File) error
+}
+
+// storageCopy копия метрик хранилища в памяти, снятая методом freeze. Checkpoint снимает ее под блокировкой
+// журнала, а сериализует и сбрасывает на диск уже без нее. История копией не охватывается и сохраняется
+// из хранилища на момент записи.
+type storageCopy struct {
+	metrics []Metrics
+	history *History
+}
+
+// freezer хранилище, умеющее снять копию storageCopy.
+type freezer interface {
+	freeze() *storageCopy
+}
+
+// SaveToFile сохраняет копию в файл так же, как MemoryStorage.SaveToFile.
+func (c *storageCopy) SaveToFile(file *os.File) error {
+	data, err := json.Marshal(c.metrics)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+	if c.history != nil {
+		return c.history.Save(file.Name() + historyFileSuffix)
+	}
+	return nil
+}
+
+// writeSnapshot атомарно сохраняет снимок хранилища s в FilePath: содержимое пишется во временный файл
+// с заголовком, сбрасывается на диск и переименовывается поверх прежнего снимка. Если Keep больше единицы,
+// прежний снимок сохраняется с отметкой времени, и остаются только Keep последних снимков.
+func (f *FileStorage) writeSnapshot(s snapshotSource) error {
+	tmp, err := f.prepareSnapshot(s)
+	if err != nil {
+		return err
+	}
+	defer removeSnapshot(tmp)
+	return f.installSnapshot(tmp)
+}
+
+// prepareSnapshot записывает снимок s во временный файл рядом с FilePath и сбрасывает его на диск.
+// Возвращает путь временного файла, который удаляется removeSnapshot.
+func (f *FileStorage) prepareSnapshot(s snapshotSource) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(f.FilePath), filepath.Base(f.FilePath)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	err = writeSnapshotFile(tmp, s)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeSnapshot(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// removeSnapshot удаляет временный файл снимка и его историю, если они остались.
+func removeSnapshot(tmp string) {
+	os.Remove(tmp)
+	os.Remove(tmp + historyFileSuffix)
+}
+
+// installSnapshot переименовывает подготовленный prepareSnapshot файл tmp поверх прежнего снимка.
+func (f *FileStorage) installSnapshot(tmp string) error {
+	if f.Keep > 1 {
+		err := f.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	err := os.Rename(tmp, f.FilePath)
+	if err != nil {
+		return err
+	}
+	// История MemoryStorage сохраняется рядом с файлом снимка и переносится вслед за ним.
+	err = os.Rename(tmp+historyFileSuffix, f.FilePath+historyFileSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = syncDir(filepath.Dir(f.FilePath))
+	if err != nil {
+		return err
+	}
+	if f.Keep > 1 {
+		return f.prune()
+	}
+	return nil
+}
+
+// writeSnapshotFile записывает в file место под заголовок, содержимое хранилища s и затем сам заголовок
+// с контрольной суммой содержимого, после чего сбрасывает файл на диск.
+func writeSnapshotFile(file *os.File, s snapshotSource) error {
+	_, err := file.Write(make([]byte, snapshotHeaderLen))
+	if err != nil {
+		return err
+	}
+	err = s.SaveToFile(file)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(int64(snapshotHeaderLen), io.SeekStart)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	header := snapshotMagic + hex.EncodeToString(h.Sum(nil)) + "\n"
+	_, err = file.WriteAt([]byte(header), 0)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// WriteFileAtomic атомарно записывает data в файл path так же, как сохраняются снимки: содержимое пишется
+// во временный файл в том же каталоге, сбрасывается на диск и переименовывается поверх прежнего файла.
+// При сбое на диске остается либо прежний, либо новый файл целиком.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименования в нем пережили сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// rotate переименовывает текущий снимок и его историю, добавляя к имени отметку времени.
+func (f *FileStorage) rotate() error {
+	name := f.FilePath + "." + time.Now().UTC().Format(snapshotTimeFormat)
+	err := os.Rename(f.FilePath, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.FilePath+historyFileSuffix, name+historyFileSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// rotated возвращает пути предыдущих снимков от новых к старым.
+func (f *FileStorage) rotated() ([]string, error) {
+	matches, err := filepath.Glob(f.FilePath + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, f.FilePath+".")
+		if _, err := time.Parse(snapshotTimeFormat, suffix); err == nil {
+			snapshots = append(snapshots, match)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(snapshots)))
+	return snapshots, nil
+}
+
+// prune удаляет предыдущие снимки сверх Keep - 1 самых новых.
+func (f *FileStorage) prune() error {
+	snapshots, err := f.rotated()
+	if err != nil {
+		return err
+	}
+	for i := f.Keep - 1; i < len(snapshots); i++ {
+		err = os.Remove(snapshots[i])
+		if err != nil {
+			return err
+		}
+		err = os.Remove(snapshots[i] + historyFileSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreSnapshot загружает в хранилище s самый новый целый снимок: текущий или, если он отсутствует или
+// поврежден, один из предыдущих. Возвращает путь загруженного снимка, или пустую строку, если снимков нет.
+func (f *FileStorage) restoreSnapshot(s IStorage) (string, error) {
+	snapshots, err := f.rotated()
+	if err != nil {
+		return "", err
+	}
+	var lastErr error
+	for _, path := range append([]string{f.FilePath}, snapshots...) {
+		_, err = readSnapshot(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = s.UploadFromFile(path)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("snapshot %s: %w", path, err)
+			continue
+		}
+		return path, nil
+	}
+	if lastErr != nil {
+		return "", lastErr
+	}
+	return "", os.ErrNotExist
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func Test_readSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	st := &MemoryStorage{
+		GaugeMetrics:   map[string]float64{"Alloc": 3.14},
+		CounterMetrics: map[string]int64{},
+	}
+	fs := &FileStorage{FilePath: filepath.Join(dir, "metrics.json")}
+	if err := fs.SaveStorageToFile(st); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := os.ReadFile(fs.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte{}, valid...)
+	corrupted[len(corrupted)-3] = '9'
+	body := `[{"id":"Alloc","type":"gauge","value":3.14}]`
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", data: string(valid), want: body},
+		{name: "legacy without header", data: body, want: body},
+		{name: "corrupted body", data: string(corrupted), wantErr: true},
+		{name: "torn header", data: string(valid[:20]), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "snapshot")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readSnapshot(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readSnapshot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, string(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStorage_RotateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileStorage{FilePath: filepath.Join(dir, "metrics.json"), Keep: 3}
+	st := &MemoryStorage{
+		GaugeMetrics:   map[string]float64{},
+		CounterMetrics: map[string]int64{},
+		History:        NewHistory(time.Hour),
+	}
+	for _, v := range []float64{1, 2, 3, 4} {
+		assert.Equal(t, st.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+		assert.Equal(t, fs.SaveStorageToFile(st), nil)
+		// Отметки времени снимков должны различаться.
+		time.Sleep(time.Millisecond)
+	}
+	rotated, err := fs.rotated()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rotated), 2)
+	for _, path := range append([]string{fs.FilePath}, rotated...) {
+		if _, err := os.Stat(path + historyFileSuffix); err != nil {
+			t.Errorf("snapshot %s has no history: %v", path, err)
+		}
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	assert.Equal(t, len(leftovers), 0)
+
+	tests := []struct {
+		name    string
+		damage  func()
+		want    float64
+		wantErr bool
+	}{
+		{name: "latest", damage: func() {}, want: 4},
+		{name: "corrupted latest", damage: func() {
+			os.WriteFile(fs.FilePath, []byte(snapshotMagic+"00\n[]"), 0644)
+		}, want: 3},
+		{name: "missing latest", damage: func() { os.Remove(fs.FilePath) }, want: 3},
+		{name: "all corrupted", damage: func() {
+			for _, path := range rotated {
+				os.WriteFile(path, []byte("{"), 0644)
+			}
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.damage()
+			restored := &MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+			}
+			err := fs.Restore(restored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FileStorage.Restore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, restored.GaugeMetrics["Alloc"], tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	for _, data := range []string{"first", "second"} {
+		assert.Equal(t, WriteFileAtomic(path, []byte(data)), nil)
+		got, err := os.ReadFile(path)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, string(got), data)
+	}
+	// Временные файлы не остаются в каталоге.
+	entries, err := os.ReadDir(dir)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(entries), 1)
+	assert.NotEqual(t, WriteFileAtomic(filepath.Join(dir, "missing", "data.json"), []byte("x")), nil)
+}
@@ -0,0 +1,583 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Драйвер database/sql для SQLite.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteScheme префикс строки подключения, по которому выбирается хранилище SQLite, например
+// sqlite:///var/lib/rt-metrics.db.
+const SQLiteScheme = "sqlite://"
+
+// sqlitePruneInterval как часто из истории удаляются устаревшие точки.
+const sqlitePruneInterval = time.Minute
+
+var errNoValue = fmt.Errorf("insert data: no value")
+
+// SQLiteStorage хранилище метрик в файле базы SQLite, реализующее интерфейсный тип IStorage с той же
+// семантикой, что и MemoryStorage: gauge заменяется, к counter добавляется прирост, гистограммы складываются. Если Retention больше
+// нуля, каждое обновление записывается в историю, точки старше Retention удаляются.
+type SQLiteStorage struct {
+	Context   context.Context
+	DB        *sql.DB
+	Path      string
+	Retention time.Duration
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+// IsSQLiteDSN проверяет, указывает ли строка подключения на базу SQLite.
+func IsSQLiteDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, SQLiteScheme)
+}
+
+// NewSQLiteStorage функция-конструктор, открывающая файл базы из строки подключения dsn и создающая
+// в нем таблицы rt_metrics и rt_metrics_history, если они не существуют. Таблицы, созданные без
+// столбцов меток, гистограммы и признака устаревания, дополняются ими.
+func NewSQLiteStorage(ctx context.Context, dsn string, retention time.Duration) (*SQLiteStorage, error) {
+	if !IsSQLiteDSN(dsn) {
+		return nil, fmt.Errorf("sqlite dsn must start with %s", SQLiteScheme)
+	}
+	path := strings.TrimPrefix(dsn, SQLiteScheme)
+	if path == "" {
+		return nil, errors.New("sqlite dsn has no file path")
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает одного писателя, поэтому все запросы идут через одно подключение.
+	db.SetMaxOpenConns(1)
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS rt_metrics (
+			mtype TEXT NOT NULL,
+			id TEXT NOT NULL,
+			labels TEXT NOT NULL DEFAULT '',
+			delta INTEGER,
+			value REAL,
+			histogram TEXT,
+			updated_at INTEGER NOT NULL,
+			stale INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (mtype, id, labels)
+		);
+		CREATE TABLE IF NOT EXISTS rt_metrics_history (
+			mtype TEXT NOT NULL,
+			id TEXT NOT NULL,
+			labels TEXT NOT NULL DEFAULT '',
+			delta INTEGER,
+			value REAL,
+			ts INTEGER NOT NULL
+		);`)
+	if err == nil {
+		err = addSQLiteLabels(ctx, db)
+	}
+	if err == nil {
+		err = addSQLiteHistogram(ctx, db)
+	}
+	if err == nil {
+		err = addSQLiteStale(ctx, db)
+	}
+	if err == nil {
+		_, err = db.ExecContext(ctx,
+			"CREATE INDEX IF NOT EXISTS rt_metrics_history_series_idx ON rt_metrics_history (mtype, id, labels, ts);")
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStorage{
+		Context:   ctx,
+		DB:        db,
+		Path:      path,
+		Retention: retention,
+	}, nil
+}
+
+// sqliteHasColumn проверяет, есть ли в таблице столбец с названием column.
+func sqliteHasColumn(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	var found int
+	err := db.QueryRowContext(ctx,
+		"SELECT count(*) FROM pragma_table_info(?) WHERE name = ?;", table, column).Scan(&found)
+	return found > 0, err
+}
+
+// addSQLiteHistogram добавляет столбец histogram в таблицу rt_metrics, созданную до появления гистограмм.
+func addSQLiteHistogram(ctx context.Context, db *sql.DB) error {
+	found, err := sqliteHasColumn(ctx, db, "rt_metrics", "histogram")
+	if err != nil || found {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "ALTER TABLE rt_metrics ADD COLUMN histogram TEXT;")
+	return err
+}
+
+// addSQLiteStale добавляет столбец stale в таблицу rt_metrics, созданную до появления признака устаревания.
+func addSQLiteStale(ctx context.Context, db *sql.DB) error {
+	found, err := sqliteHasColumn(ctx, db, "rt_metrics", "stale")
+	if err != nil || found {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "ALTER TABLE rt_metrics ADD COLUMN stale INTEGER NOT NULL DEFAULT 0;")
+	return err
+}
+
+// addSQLiteLabels добавляет столбец labels в таблицы, созданные до появления меток. Так как первичный ключ
+// в SQLite изменить нельзя, таблица rt_metrics пересоздается с переносом данных.
+func addSQLiteLabels(ctx context.Context, db *sql.DB) error {
+	found, err := sqliteHasColumn(ctx, db, "rt_metrics", "labels")
+	if err != nil || found {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx,
+		`ALTER TABLE rt_metrics RENAME TO rt_metrics_unlabeled;
+		CREATE TABLE rt_metrics (
+			mtype TEXT NOT NULL,
+			id TEXT NOT NULL,
+			labels TEXT NOT NULL DEFAULT '',
+			delta INTEGER,
+			value REAL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (mtype, id, labels)
+		);
+		INSERT INTO rt_metrics (mtype, id, delta, value, updated_at)
+			SELECT mtype, id, delta, value, updated_at FROM rt_metrics_unlabeled;
+		DROP TABLE rt_metrics_unlabeled;
+		ALTER TABLE rt_metrics_history ADD COLUMN labels TEXT NOT NULL DEFAULT '';
+		DROP INDEX IF EXISTS rt_metrics_history_idx;`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// update применяет список метрик в одной транзакции. Если replaceCounters, значения counter и гистограммы
+// заменяются, а не накапливаются, а время обновления и признак устаревания берутся из метрики, если они
+// в ней есть, как при загрузке снимка. При любой ошибке база остается без изменений.
+func (s *SQLiteStorage) update(metrics []Metrics, replaceCounters bool) error {
+	tx, err := s.DB.BeginTx(s.Context, nil)
+	if err != nil {
+		return err
+	}
+	// После успешного Commit откат ничего не делает.
+	defer tx.Rollback()
+	now := time.Now()
+	for _, m := range metrics {
+		ts := now
+		if replaceCounters && m.Updated != nil {
+			ts = *m.Updated
+		}
+		err = s.write(tx, m, ts, replaceCounters)
+		if err == nil && replaceCounters && m.Stale {
+			_, err = tx.ExecContext(s.Context,
+				"UPDATE rt_metrics SET stale = 1 WHERE mtype = ? AND id = ? AND labels = ?;",
+				m.MType, m.ID, FormatLabels(m.Labels))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = s.prune(tx, now)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// write обновляет один ряд метрики и записывает новое значение gauge и counter в историю.
+func (s *SQLiteStorage) write(tx *sql.Tx, m Metrics, now time.Time, replaceCounters bool) error {
+	var err error
+	labels := FormatLabels(m.Labels)
+	switch m.MType {
+	case "gauge":
+		if m.Value == nil {
+			return errNoValue
+		}
+		_, err = tx.ExecContext(s.Context,
+			`INSERT INTO rt_metrics (mtype, id, labels, value, updated_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (mtype, id, labels) DO UPDATE
+				SET value = excluded.value, updated_at = excluded.updated_at, stale = 0;`,
+			m.MType, m.ID, labels, *m.Value, now.UnixNano())
+	case "counter":
+		if m.Delta == nil {
+			return errNoValue
+		}
+		query := `INSERT INTO rt_metrics (mtype, id, labels, delta, updated_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (mtype, id, labels) DO UPDATE
+				SET delta = rt_metrics.delta + excluded.delta, updated_at = excluded.updated_at, stale = 0;`
+		if replaceCounters {
+			query = `INSERT INTO rt_metrics (mtype, id, labels, delta, updated_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (mtype, id, labels) DO UPDATE
+				SET delta = excluded.delta, updated_at = excluded.updated_at, stale = 0;`
+		}
+		_, err = tx.ExecContext(s.Context, query, m.MType, m.ID, labels, *m.Delta, now.UnixNano())
+	case "histogram":
+		return s.writeHistogram(tx, m, now, replaceCounters)
+	default:
+		return errWrType
+	}
+	if err != nil {
+		return err
+	}
+	if s.Retention <= 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(s.Context,
+		`INSERT INTO rt_metrics_history (mtype, id, labels, delta, value, ts)
+			SELECT mtype, id, labels, delta, value, updated_at FROM rt_metrics
+				WHERE mtype = ? AND id = ? AND labels = ?;`,
+		m.MType, m.ID, labels)
+	return err
+}
+
+// writeHistogram складывает гистограмму метрики m с сохраненной в базе, а если replace - заменяет ее.
+func (s *SQLiteStorage) writeHistogram(tx *sql.Tx, m Metrics, now time.Time, replace bool) error {
+	if m.Histogram == nil {
+		return errNoValue
+	}
+	err := m.Histogram.Validate()
+	if err != nil {
+		return err
+	}
+	labels := FormatLabels(m.Labels)
+	merged := m.Histogram.Clone()
+	if !replace {
+		var stored sql.NullString
+		err = tx.QueryRowContext(s.Context,
+			"SELECT histogram FROM rt_metrics WHERE mtype = ? AND id = ? AND labels = ?;",
+			m.MType, m.ID, labels).Scan(&stored)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if stored.Valid {
+			merged = &Histogram{}
+			err = json.Unmarshal([]byte(stored.String), merged)
+			if err != nil {
+				return err
+			}
+			err = merged.Merge(m.Histogram)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(s.Context,
+		`INSERT INTO rt_metrics (mtype, id, labels, histogram, updated_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (mtype, id, labels) DO UPDATE
+			SET histogram = excluded.histogram, updated_at = excluded.updated_at, stale = 0;`,
+		m.MType, m.ID, labels, string(data), now.UnixNano())
+	return err
+}
+
+// prune удаляет из истории точки старше Retention, но не чаще раза в sqlitePruneInterval.
+func (s *SQLiteStorage) prune(tx *sql.Tx, now time.Time) error {
+	if s.Retention <= 0 {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.lastPrune) < sqlitePruneInterval {
+		return nil
+	}
+	_, err := tx.ExecContext(s.Context, "DELETE FROM rt_metrics_history WHERE ts < ?;",
+		now.Add(-s.Retention).UnixNano())
+	if err != nil {
+		return err
+	}
+	s.lastPrune = now
+	return nil
+}
+
+// InsertMetric добавляет значение метрики из аргумента Metrics. Для gauge заменяет существующее,
+// для counter добавляет к уже существующему значению в базе.
+func (s *SQLiteStorage) InsertMetric(m *Metrics) error {
+	return s.update([]Metrics{*m}, false)
+}
+
+// InsertBatchMetric применяет список метрик в одной транзакции с той же семантикой, что и InsertMetric.
+func (s *SQLiteStorage) InsertBatchMetric(metrics []Metrics) error {
+	return s.update(metrics, false)
+}
+
+// ParamsUpdate работает как и InsertMetric, за тем исключением, что параметры для обновления
+// будут получены из аргументов функции (строк). Возвращает код для передачи его в handler.
+func (s *SQLiteStorage) ParamsUpdate(metricType, metricName, metricValue string) (int, error) {
+	m := Metrics{ID: metricName, MType: metricType}
+	switch metricType {
+	case "gauge":
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return 400, err
+		}
+		m.Value = &value
+	case "counter":
+		delta, err := strconv.ParseInt(metricValue, 10, 64)
+		if err != nil {
+			return 400, err
+		}
+		m.Delta = &delta
+	default:
+		return 501, errors.New("wrong metric type - " + metricType)
+	}
+	err := s.update([]Metrics{m}, false)
+	if err != nil {
+		return 500, err
+	}
+	return 200, nil
+}
+
+// DeleteMetrics в одной транзакции удаляет ряды метрик по типу, названию и меткам вместе с их историей.
+// Возвращает количество удаленных рядов.
+func (s *SQLiteStorage) DeleteMetrics(metrics []Metrics) (int, error) {
+	tx, err := s.DB.BeginTx(s.Context, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	deleted := 0
+	for _, m := range metrics {
+		labels := FormatLabels(m.Labels)
+		res, err := tx.ExecContext(s.Context,
+			"DELETE FROM rt_metrics WHERE mtype = ? AND id = ? AND labels = ?;", m.MType, m.ID, labels)
+		if err != nil {
+			return 0, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if affected == 0 {
+			continue
+		}
+		deleted++
+		_, err = tx.ExecContext(s.Context,
+			"DELETE FROM rt_metrics_history WHERE mtype = ? AND id = ? AND labels = ?;", m.MType, m.ID, labels)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return deleted, tx.Commit()
+}
+
+// ResetCounter обнуляет значение counter и записывает его в историю. Если ряда нет, возвращает false.
+func (s *SQLiteStorage) ResetCounter(m *Metrics) (bool, error) {
+	var found int
+	err := s.DB.QueryRowContext(s.Context,
+		"SELECT count(*) FROM rt_metrics WHERE mtype = 'counter' AND id = ? AND labels = ?;",
+		m.ID, FormatLabels(m.Labels)).Scan(&found)
+	if err != nil || found == 0 {
+		return false, err
+	}
+	var zero int64
+	err = s.update([]Metrics{{ID: m.ID, MType: "counter", Labels: m.Labels, Delta: &zero}}, true)
+	return err == nil, err
+}
+
+// SweepStale в одной транзакции помечает устаревшими ряды, не обновлявшиеся с момента before, а если evict,
+// удаляет их вместе с историей. Возвращает ряды, которые были помечены или удалены этим вызовом.
+func (s *SQLiteStorage) SweepStale(before time.Time, evict bool) ([]Metrics, error) {
+	tx, err := s.DB.BeginTx(s.Context, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := "SELECT mtype, id, labels FROM rt_metrics WHERE updated_at < ? AND stale = 0;"
+	if evict {
+		query = "SELECT mtype, id, labels FROM rt_metrics WHERE updated_at < ?;"
+	}
+	rows, err := tx.QueryContext(s.Context, query, before.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	swept := []Metrics{}
+	for rows.Next() {
+		var (
+			metric Metrics
+			labels string
+		)
+		err = rows.Scan(&metric.MType, &metric.ID, &labels)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		metric.Labels = decodeLabels(labels)
+		swept = append(swept, metric)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range swept {
+		labels := FormatLabels(m.Labels)
+		if !evict {
+			_, err = tx.ExecContext(s.Context,
+				"UPDATE rt_metrics SET stale = 1 WHERE mtype = ? AND id = ? AND labels = ?;", m.MType, m.ID, labels)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		_, err = tx.ExecContext(s.Context,
+			"DELETE FROM rt_metrics WHERE mtype = ? AND id = ? AND labels = ?;", m.MType, m.ID, labels)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(s.Context,
+			"DELETE FROM rt_metrics_history WHERE mtype = ? AND id = ? AND labels = ?;", m.MType, m.ID, labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return swept, tx.Commit()
+}
+
+// ReadMetric получает значение метрики, тип и название которой получены из структуры Metrics.
+// В случае если искомой метрики не существует, возвращает nil и ошибку.
+func (s *SQLiteStorage) ReadMetric(rm *Metrics) (*Metrics, error) {
+	var histogram sql.NullString
+	err := s.DB.QueryRowContext(s.Context,
+		"SELECT delta, value, histogram FROM rt_metrics WHERE mtype = ? AND id = ? AND labels = ?;",
+		rm.MType, rm.ID, FormatLabels(rm.Labels)).
+		Scan(&rm.Delta, &rm.Value, &histogram)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rm.Histogram, err = decodeHistogram(histogram.String)
+	if err != nil {
+		return nil, err
+	}
+	return rm, nil
+}
+
+// ReadAllMetrics возвращает все метрики вместе со временем их последнего обновления и признаком
+// устаревания. В случае если метрик нет, возвращает пустой список и nil.
+func (s *SQLiteStorage) ReadAllMetrics() ([]Metrics, error) {
+	rows, err := s.DB.QueryContext(s.Context,
+		"SELECT mtype, id, labels, delta, value, histogram, updated_at, stale FROM rt_metrics ORDER BY mtype, id, labels;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	metricsSlice := []Metrics{}
+	for rows.Next() {
+		var (
+			metric    Metrics
+			labels    string
+			histogram sql.NullString
+			updated   int64
+		)
+		err = rows.Scan(&metric.MType, &metric.ID, &labels, &metric.Delta, &metric.Value, &histogram, &updated,
+			&metric.Stale)
+		if err != nil {
+			return nil, err
+		}
+		metric.Labels = decodeLabels(labels)
+		metric.Histogram, err = decodeHistogram(histogram.String)
+		if err != nil {
+			return nil, err
+		}
+		ts := time.Unix(0, updated)
+		metric.Updated = &ts
+		metricsSlice = append(metricsSlice, metric)
+	}
+	return metricsSlice, rows.Err()
+}
+
+// ReadHistory возвращает историю значений ряда метрики с ключом key в интервале [from, to] с шагом step.
+// Если история отключена, возвращает ошибку.
+func (s *SQLiteStorage) ReadHistory(mType, key string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	if s.Retention <= 0 {
+		return nil, errNoHistory
+	}
+	id, labels := ParseSeriesKey(key)
+	rows, err := s.DB.QueryContext(s.Context,
+		`SELECT ts, delta, value FROM rt_metrics_history
+			WHERE mtype = ? AND id = ? AND labels = ? AND ts BETWEEN ? AND ?
+			ORDER BY ts;`,
+		mType, id, FormatLabels(labels), from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	samples := []Sample{}
+	for rows.Next() {
+		var (
+			sample Sample
+			ts     int64
+		)
+		err = rows.Scan(&ts, &sample.Delta, &sample.Value)
+		if err != nil {
+			return nil, err
+		}
+		sample.Time = time.Unix(0, ts)
+		samples = append(samples, sample)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return downsample(samples, from, step), nil
+}
+
+// SaveToFile читает все метрики из базы, а затем сохраняет их в файл (аргумент функции).
+func (s *SQLiteStorage) SaveToFile(f *os.File) error {
+	metrics, err := s.ReadAllMetrics()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// UploadFromFile заполняет базу метрик значениями, полученными из файла, в одной транзакции вместе со временем
+// обновления и признаком устаревания. Так как база сама переживает перезапуск, значения counter и гистограммы
+// из файла заменяют сохраненные, а не добавляются к ним.
+func (s *SQLiteStorage) UploadFromFile(path string) error {
+	var metricsSlice []Metrics
+	data, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, &metricsSlice)
+	if err != nil {
+		return err
+	}
+	return s.update(metricsSlice, true)
+}
+
+// Ping проверка доступности файла базы.
+func (s *SQLiteStorage) Ping() error {
+	return s.DB.PingContext(s.Context)
+}
+
+// Close закрывает базу.
+func (s *SQLiteStorage) Close() error {
+	return s.DB.Close()
+}
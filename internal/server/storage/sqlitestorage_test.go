@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func newTestSQLiteStorage(t *testing.T, retention time.Duration) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(context.Background(), SQLiteScheme+filepath.Join(t.TempDir(), "metrics.db"), retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestNewSQLiteStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		wantErr bool
+	}{
+		{name: "file", dsn: SQLiteScheme + filepath.Join(t.TempDir(), "metrics.db")},
+		{name: "postgres dsn", dsn: "postgres://localhost:5432", wantErr: true},
+		{name: "no path", dsn: SQLiteScheme, wantErr: true},
+		{name: "missing dir", dsn: SQLiteScheme + filepath.Join(t.TempDir(), "missing", "metrics.db"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSQLiteStorage(context.Background(), tt.dsn, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSQLiteStorage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				assert.Equal(t, s.Ping(), nil)
+				s.Close()
+			}
+		})
+	}
+}
+
+func TestSQLiteStorage_Semantics(t *testing.T) {
+	s := newTestSQLiteStorage(t, 0)
+	v1, v2 := 1.5, 2.5
+	d1, d2 := int64(3), int64(4)
+	err := s.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v1})
+	assert.Equal(t, err, nil)
+	err = s.InsertBatchMetric([]Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &v2},
+		{ID: "PollCount", MType: "counter", Delta: &d1},
+		{ID: "PollCount", MType: "counter", Delta: &d2},
+		// Метрики разных типов с одинаковым названием хранятся раздельно, как в MemoryStorage.
+		{ID: "Alloc", MType: "counter", Delta: &d1},
+	})
+	assert.Equal(t, err, nil)
+	code, err := s.ParamsUpdate("counter", "PollCount", "3")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, code, 200)
+
+	tests := []struct {
+		name      string
+		mType     string
+		id        string
+		wantDelta int64
+		wantValue float64
+		wantErr   bool
+	}{
+		{name: "gauge replaced", mType: "gauge", id: "Alloc", wantValue: 2.5},
+		{name: "counter accumulated", mType: "counter", id: "PollCount", wantDelta: 10},
+		{name: "same name counter", mType: "counter", id: "Alloc", wantDelta: 3},
+		{name: "not found", mType: "gauge", id: "PollCount", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ReadMetric(&Metrics{ID: tt.id, MType: tt.mType})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SQLiteStorage.ReadMetric() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.mType == "gauge" {
+				assert.Equal(t, *got.Value, tt.wantValue)
+			} else {
+				assert.Equal(t, *got.Delta, tt.wantDelta)
+			}
+		})
+	}
+	all, err := s.ReadAllMetrics()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(all), 3)
+	for _, m := range all {
+		if m.Updated == nil {
+			t.Errorf("SQLiteStorage.ReadAllMetrics() %s has no update time", m.ID)
+		}
+	}
+}
+
+func TestSQLiteStorage_Errors(t *testing.T) {
+	s := newTestSQLiteStorage(t, 0)
+	v := 1.0
+	tests := []struct {
+		name     string
+		metrics  []Metrics
+		wantCode int
+		params   [3]string
+	}{
+		{name: "wrong type", metrics: []Metrics{{ID: "Alloc", MType: "histogram", Value: &v}},
+			params: [3]string{"histogram", "Alloc", "1"}, wantCode: 501},
+		{name: "no value", metrics: []Metrics{{ID: "Alloc", MType: "gauge"}},
+			params: [3]string{"gauge", "Alloc", "none"}, wantCode: 400},
+		{name: "no delta", metrics: []Metrics{{ID: "PollCount", MType: "counter"}},
+			params: [3]string{"counter", "PollCount", "1.5"}, wantCode: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.InsertBatchMetric(tt.metrics); err == nil {
+				t.Error("SQLiteStorage.InsertBatchMetric() expected error")
+			}
+			code, err := s.ParamsUpdate(tt.params[0], tt.params[1], tt.params[2])
+			if err == nil {
+				t.Error("SQLiteStorage.ParamsUpdate() expected error")
+			}
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+	// Ошибка в середине списка не оставляет частично примененных обновлений.
+	err := s.InsertBatchMetric([]Metrics{{ID: "Alloc", MType: "gauge", Value: &v}, {ID: "Bad", MType: "gauge"}})
+	if err == nil {
+		t.Error("SQLiteStorage.InsertBatchMetric() expected error")
+	}
+	all, err := s.ReadAllMetrics()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(all), 0)
+}
+
+func TestSQLiteStorage_History(t *testing.T) {
+	s := newTestSQLiteStorage(t, time.Hour)
+	from := time.Now().Add(-time.Minute)
+	for _, value := range []string{"1", "2", "3"} {
+		_, err := s.ParamsUpdate("counter", "PollCount", value)
+		assert.Equal(t, err, nil)
+	}
+	samples, err := s.ReadHistory("counter", "PollCount", from, time.Now(), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(samples), 3)
+	assert.Equal(t, *samples[2].Delta, int64(6))
+
+	samples, err = s.ReadHistory("counter", "PollCount", from, time.Now(), time.Hour)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(samples), 1)
+
+	disabled := newTestSQLiteStorage(t, 0)
+	_, err = disabled.ReadHistory("counter", "PollCount", from, time.Now(), 0)
+	assert.Equal(t, err, errNoHistory)
+}
+
+func TestSQLiteStorage_File(t *testing.T) {
+	s := newTestSQLiteStorage(t, 0)
+	v, d := 3.14, int64(5)
+	err := s.InsertBatchMetric([]Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &v},
+		{ID: "PollCount", MType: "counter", Delta: &d},
+	})
+	assert.Equal(t, err, nil)
+	f, err := os.Create(filepath.Join(t.TempDir(), "metrics.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.SaveToFile(f), nil)
+	f.Close()
+
+	// Восстановление из файла заменяет counter, а не добавляет к нему.
+	assert.Equal(t, s.UploadFromFile(f.Name()), nil)
+	got, err := s.ReadMetric(&Metrics{ID: "PollCount", MType: "counter"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *got.Delta, d)
+
+	// Время обновления и признак устаревания берутся из снимка.
+	updated := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	data, err := json.Marshal([]Metrics{{ID: "Alloc", MType: "gauge", Value: &v, Updated: &updated, Stale: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.WriteFile(f.Name(), data, 0644), nil)
+	assert.Equal(t, s.UploadFromFile(f.Name()), nil)
+	all, err := s.ReadAllMetrics()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, all[1].ID, "Alloc")
+	assert.Equal(t, all[1].Updated.Equal(updated), true)
+	assert.Equal(t, all[1].Stale, true)
+
+	if err := s.UploadFromFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("SQLiteStorage.UploadFromFile() expected error")
+	}
+}
+
+func TestNewSQLiteStorage_AddLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Схема, созданная до появления меток.
+	_, err = db.Exec(`CREATE TABLE rt_metrics (
+			mtype TEXT NOT NULL,
+			id TEXT NOT NULL,
+			delta INTEGER,
+			value REAL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (mtype, id)
+		);
+		CREATE TABLE rt_metrics_history (
+			mtype TEXT NOT NULL,
+			id TEXT NOT NULL,
+			delta INTEGER,
+			value REAL,
+			ts INTEGER NOT NULL
+		);
+		CREATE INDEX rt_metrics_history_idx ON rt_metrics_history (mtype, id, ts);
+		INSERT INTO rt_metrics (mtype, id, delta, updated_at) VALUES ('counter', 'PollCount', 5, 1);`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSQLiteStorage(context.Background(), SQLiteScheme+path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	d := int64(2)
+	assert.Equal(t, s.InsertMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+	assert.Equal(t, s.InsertMetric(&Metrics{ID: "PollCount", MType: "counter",
+		Labels: map[string]string{"host": "a"}, Delta: &d}), nil)
+	got, err := s.ReadMetric(&Metrics{ID: "PollCount", MType: "counter"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *got.Delta, int64(7))
+	all, err := s.ReadAllMetrics()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(all), 2)
+}
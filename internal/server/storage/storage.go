@@ -2,16 +2,23 @@ package storage
 
 import (
 	"os"
+	"time"
 )
 
 // Metrics преобразуемая в json структура, которая может содержать
-// тип метрики, её название, значение и хеш
+// тип метрики, её название, метки, значение, хеш, время последнего обновления и признак устаревания.
+// Метрики с одним названием и разными метками хранятся как разные ряды.
+// Значение gauge передается в Value, counter - в Delta, histogram - в Histogram.
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Histogram *Histogram        `json:"histogram,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+	Updated   *time.Time        `json:"updated,omitempty"`
+	Stale     bool              `json:"stale,omitempty"`
 }
 
 // IStorage интерфейс описывающий хранище метрик и методы для работы с ним.
@@ -21,9 +28,20 @@ type IStorage interface {
 	InsertBatchMetric([]Metrics) error
 	ParamsUpdate(string, string, string) (int, error)
 
+	// Delete/Reset methods.
+	// DeleteMetrics удаляет ряды метрик вместе с их историей и возвращает количество удаленных рядов.
+	DeleteMetrics([]Metrics) (int, error)
+	// ResetCounter обнуляет значение counter. Если ряда нет, возвращает false.
+	ResetCounter(*Metrics) (bool, error)
+	// SweepStale помечает устаревшими ряды, не обновлявшиеся с указанного момента, или удаляет их,
+	// если передан true. Возвращает ряды, затронутые этим вызовом. Обновление ряда снимает признак.
+	SweepStale(time.Time, bool) ([]Metrics, error)
+
 	// Read methods.
 	ReadMetric(*Metrics) (*Metrics, error)
 	ReadAllMetrics() ([]Metrics, error)
+	// ReadHistory принимает тип метрики и ключ ряда, полученный из SeriesKey.
+	ReadHistory(string, string, time.Time, time.Time, time.Duration) ([]Sample, error)
 	
 	// File storage methods.
 	SaveToFile(*os.File) error
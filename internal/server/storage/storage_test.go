@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestStorage_DeleteAndReset(t *testing.T) {
+	storages := []struct {
+		name string
+		st   func(t *testing.T) IStorage
+	}{
+		{name: "memory", st: func(t *testing.T) IStorage {
+			return &MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+				History:        NewHistory(time.Hour),
+			}
+		}},
+		{name: "sharded", st: func(t *testing.T) IStorage { return NewShardedStorage(4, NewHistory(time.Hour)) }},
+		{name: "sqlite", st: func(t *testing.T) IStorage { return newTestSQLiteStorage(t, time.Hour) }},
+	}
+	hostA := map[string]string{"host": "a"}
+	for _, ts := range storages {
+		t.Run(ts.name, func(t *testing.T) {
+			st := ts.st(t)
+			v, d := 1.5, int64(3)
+			err := st.InsertBatchMetric([]Metrics{
+				{ID: "CPUutilization7", MType: "gauge", Labels: hostA, Value: &v},
+				{ID: "CPUutilization7", MType: "gauge", Value: &v},
+				{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "GCPauseSeconds", MType: "histogram",
+					Histogram: &Histogram{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5}},
+			})
+			assert.Equal(t, err, nil)
+
+			deleted, err := st.DeleteMetrics([]Metrics{
+				{ID: "CPUutilization7", MType: "gauge", Labels: hostA},
+				{ID: "CPUutilization7", MType: "counter", Labels: hostA},
+				{ID: "GCPauseSeconds", MType: "histogram"},
+			})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, deleted, 2)
+			_, err = st.ReadMetric(&Metrics{ID: "CPUutilization7", MType: "gauge", Labels: hostA})
+			assert.NotEqual(t, err, nil)
+			_, err = st.ReadMetric(&Metrics{ID: "CPUutilization7", MType: "gauge"})
+			assert.Equal(t, err, nil)
+			samples, err := st.ReadHistory("gauge", SeriesKey("CPUutilization7", hostA),
+				time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 0)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(samples), 0)
+
+			found, err := st.ResetCounter(&Metrics{ID: "PollCount", MType: "counter"})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, found, true)
+			got, err := st.ReadMetric(&Metrics{ID: "PollCount", MType: "counter"})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, *got.Delta, int64(0))
+			assert.Equal(t, st.InsertMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+			got, err = st.ReadMetric(&Metrics{ID: "PollCount", MType: "counter"})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, *got.Delta, d)
+			found, err = st.ResetCounter(&Metrics{ID: "PollCount", MType: "counter", Labels: hostA})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, found, false)
+		})
+	}
+}
+
+func TestStorage_SweepStale(t *testing.T) {
+	storages := []struct {
+		name string
+		st   func(t *testing.T) IStorage
+	}{
+		{name: "memory", st: func(t *testing.T) IStorage {
+			return &MemoryStorage{
+				GaugeMetrics:   map[string]float64{},
+				CounterMetrics: map[string]int64{},
+				History:        NewHistory(time.Hour),
+			}
+		}},
+		{name: "sharded", st: func(t *testing.T) IStorage { return NewShardedStorage(4, NewHistory(time.Hour)) }},
+		{name: "sqlite", st: func(t *testing.T) IStorage { return newTestSQLiteStorage(t, time.Hour) }},
+	}
+	hostA := map[string]string{"host": "a"}
+	staleIDs := func(t *testing.T, st IStorage) map[string]bool {
+		all, err := st.ReadAllMetrics()
+		assert.Equal(t, err, nil)
+		result := make(map[string]bool)
+		for _, m := range all {
+			assert.NotEqual(t, m.Updated, nil)
+			result[m.MType+":"+m.Key()] = m.Stale
+		}
+		return result
+	}
+	for _, ts := range storages {
+		t.Run(ts.name, func(t *testing.T) {
+			st := ts.st(t)
+			v, d := 1.5, int64(3)
+			err := st.InsertBatchMetric([]Metrics{
+				{ID: "CPUutilization7", MType: "gauge", Labels: hostA, Value: &v},
+				{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "GCPauseSeconds", MType: "histogram",
+					Histogram: &Histogram{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5}},
+			})
+			assert.Equal(t, err, nil)
+			time.Sleep(2 * time.Millisecond)
+			before := time.Now()
+			time.Sleep(2 * time.Millisecond)
+			assert.Equal(t, st.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+
+			swept, err := st.SweepStale(before, false)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(swept), 3)
+			assert.Equal(t, staleIDs(t, st), map[string]bool{
+				`gauge:CPUutilization7{host="a"}`: true,
+				"counter:PollCount":               true,
+				"histogram:GCPauseSeconds":        true,
+				"gauge:Alloc":                     false,
+			})
+			swept, err = st.SweepStale(before, false)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(swept), 0)
+
+			// Обновление снимает признак устаревания.
+			assert.Equal(t, st.InsertMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+			assert.Equal(t, staleIDs(t, st)["counter:PollCount"], false)
+
+			swept, err = st.SweepStale(before, true)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(swept), 2)
+			assert.Equal(t, staleIDs(t, st), map[string]bool{"counter:PollCount": false, "gauge:Alloc": false})
+			_, err = st.ReadMetric(&Metrics{ID: "CPUutilization7", MType: "gauge", Labels: hostA})
+			assert.NotEqual(t, err, nil)
+			samples, err := st.ReadHistory("gauge", SeriesKey("CPUutilization7", hostA),
+				time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 0)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(samples), 0)
+		})
+	}
+}
+
+func TestStorage_SnapshotKeepsStale(t *testing.T) {
+	storages := []struct {
+		name string
+		st   func() IStorage
+	}{
+		{name: "memory", st: func() IStorage {
+			return &MemoryStorage{GaugeMetrics: map[string]float64{}, CounterMetrics: map[string]int64{}}
+		}},
+		{name: "sharded", st: func() IStorage { return NewShardedStorage(4, nil) }},
+	}
+	for _, ts := range storages {
+		t.Run(ts.name, func(t *testing.T) {
+			st := ts.st()
+			v := 1.5
+			assert.Equal(t, st.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+			_, err := st.SweepStale(time.Now().Add(time.Second), false)
+			assert.Equal(t, err, nil)
+			all, err := st.ReadAllMetrics()
+			assert.Equal(t, err, nil)
+			updated := *all[0].Updated
+
+			fs := &FileStorage{FilePath: filepath.Join(t.TempDir(), "metrics.json")}
+			assert.Equal(t, fs.SaveStorageToFile(st), nil)
+			restored := ts.st()
+			assert.Equal(t, restored.UploadFromFile(fs.FilePath), nil)
+			all, err = restored.ReadAllMetrics()
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(all), 1)
+			assert.Equal(t, all[0].Stale, true)
+			assert.Equal(t, all[0].Updated.Equal(updated), true)
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Операции записей журнала.
+const (
+	walOpCheckpoint = "checkpoint"
+	walOpUpdate     = "update"
+	walOpDelete     = "delete"
+	walOpReset      = "reset"
+)
+
+// walNoSnapshot идентификатор отсутствующего снимка в заголовке журнала.
+const walNoSnapshot = "none"
+
+// walNextSuffix суффикс нового журнала, подготовленного Checkpoint до переименования поверх текущего.
+const walNextSuffix = ".next"
+
+var errWALCorrupted = fmt.Errorf("wal record corrupted")
+
+// walRecord строка журнала. Первая строка журнала - заголовок checkpoint с идентификатором Base снимка,
+// поверх которого записаны последующие обновления update, удаления рядов delete и обнуления counter reset.
+type walRecord struct {
+	Op      string    `json:"op"`
+	Base    string    `json:"base,omitempty"`
+	Metrics []Metrics `json:"metrics,omitempty"`
+}
+
+// WAL журнал предзаписи обновлений хранилища. Каждая запись - строка вида "<crc32> <json>\n", поэтому
+// оборванная при сбое последняя запись отбрасывается при воспроизведении. Если SyncInterval равен нулю,
+// журнал сбрасывается на диск после каждой записи, иначе - методом SyncLoop не реже раза в SyncInterval.
+type WAL struct {
+	Path         string
+	SyncInterval time.Duration
+	file         *os.File
+	dirty        bool
+	mutex        sync.Mutex
+	// gate разделяет запись обновлений (RLock) и снятие снимка с обрезкой журнала (Lock), чтобы
+	// обновление не попало одновременно и в снимок, и в журнал после него.
+	gate sync.RWMutex
+}
+
+// OpenWAL функция-конструктор, открывающая файл журнала на дозапись. Существующие записи не изменяются
+// до первого вызова Reset.
+func OpenWAL(path string, syncInterval time.Duration) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{
+		Path:         path,
+		SyncInterval: syncInterval,
+		file:         file,
+	}, nil
+}
+
+// encodeWALRecord кодирует запись журнала в строку с контрольной суммой.
+func encodeWALRecord(rec walRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x ", crc32.ChecksumIEEE(data))
+	return append(append([]byte(line), data...), '\n'), nil
+}
+
+// decodeWALRecord разбирает строку журнала без завершающего перевода строки и проверяет ее контрольную сумму.
+func decodeWALRecord(line string) (walRecord, error) {
+	var rec walRecord
+	sum, data, ok := strings.Cut(line, " ")
+	if !ok {
+		return rec, errWALCorrupted
+	}
+	crc, err := strconv.ParseUint(sum, 16, 32)
+	if err != nil || uint32(crc) != crc32.ChecksumIEEE([]byte(data)) {
+		return rec, errWALCorrupted
+	}
+	err = json.Unmarshal([]byte(data), &rec)
+	if err != nil {
+		return rec, errWALCorrupted
+	}
+	return rec, nil
+}
+
+// Append дописывает в журнал обновление metrics и, если SyncInterval равен нулю, сбрасывает его на диск.
+func (w *WAL) Append(metrics []Metrics) error {
+	return w.append(walRecord{Op: walOpUpdate, Metrics: metrics})
+}
+
+// append дописывает в журнал запись rec так же, как Append.
+func (w *WAL) append(rec walRecord) error {
+	line, err := encodeWALRecord(rec)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err = w.file.Write(line)
+	if err != nil {
+		return err
+	}
+	if w.SyncInterval > 0 {
+		w.dirty = true
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Sync сбрасывает на диск записи, добавленные после предыдущего сброса.
+func (w *WAL) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+// SyncLoop сбрасывает журнал на диск каждые SyncInterval до отмены ctx.
+func (w *WAL) SyncLoop(ctx context.Context) {
+	if w.SyncInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(w.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := w.Sync()
+			if err != nil {
+				log.Println("WAL sync failed:", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reset обрезает журнал и записывает заголовок со снимком base, поверх которого будут записаны
+// последующие обновления. Вызывается под gate.Lock после сохранения снимка.
+func (w *WAL) Reset(base string) error {
+	header, err := encodeWALRecord(walRecord{Op: walOpCheckpoint, Base: base})
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err = w.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(header)
+	if err != nil {
+		return err
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+// offset возвращает текущий размер журнала. Вызывается под gate.Lock, чтобы отметить место, после
+// которого записи не вошли в снимок.
+func (w *WAL) offset() (int64, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// prepareNext записывает рядом с журналом новый журнал с заголовком снимка base и записями текущего
+// журнала после смещения from и сбрасывает его на диск. Вызывается под gate.Lock.
+func (w *WAL) prepareNext(base string, from int64) error {
+	header, err := encodeWALRecord(walRecord{Op: walOpCheckpoint, Base: base})
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	src, err := os.Open(w.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = src.Seek(from, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	next, err := os.OpenFile(w.Path+walNextSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = next.Write(header)
+	if err == nil {
+		_, err = io.Copy(next, src)
+	}
+	if err == nil {
+		err = next.Sync()
+	}
+	if closeErr := next.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// switchNext переименовывает журнал, подготовленный prepareNext, поверх текущего и продолжает запись в него.
+// Вызывается под gate.Lock после сохранения снимка.
+func (w *WAL) switchNext() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	// Файл открывается до переименования, чтобы при ошибке запись продолжилась в текущий журнал.
+	file, err := os.OpenFile(w.Path+walNextSuffix, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(w.Path+walNextSuffix, w.Path)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file.Close()
+	w.file = file
+	w.dirty = false
+	return syncDir(filepath.Dir(w.Path))
+}
+
+// Close сбрасывает журнал на диск и закрывает его.
+func (w *WAL) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err := w.file.Sync()
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// ReplayWAL воспроизводит записи журнала path в хранилище st, если журнал записан поверх снимка base.
+// Журнал другого снимка уже целиком вошел в более новый снимок и пропускается. Воспроизведение
+// останавливается на первой поврежденной записи, так как это оборванный при сбое хвост журнала.
+// Ошибка применения списка обновлений не останавливает воспроизведение: такой список и до сбоя был
+// применен лишь частично, с той же ошибкой. Возвращает количество воспроизведенных записей.
+func ReplayWAL(path, base string, st IStorage) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	replayed := 0
+	for first := true; ; first = false {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// Строка без перевода строки не была дописана до конца.
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+		rec, err := decodeWALRecord(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			log.Println("WAL replay stopped at corrupted record after", replayed, "updates")
+			return replayed, nil
+		}
+		if first {
+			if rec.Op != walOpCheckpoint {
+				return 0, errors.New("wal has no checkpoint header")
+			}
+			if rec.Base != base {
+				log.Println("WAL belongs to another snapshot, skipped")
+				return 0, nil
+			}
+			continue
+		}
+		switch rec.Op {
+		case walOpUpdate:
+			err = st.InsertBatchMetric(rec.Metrics)
+			if err != nil {
+				log.Println("WAL replay: update partially applied:", err)
+				err = nil
+			}
+		case walOpDelete:
+			_, err = st.DeleteMetrics(rec.Metrics)
+		case walOpReset:
+			for i := 0; i < len(rec.Metrics) && err == nil; i++ {
+				_, err = st.ResetCounter(&rec.Metrics[i])
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+}
+
+// snapshotID идентификатор снимка - sha256 его содержимого, или walNoSnapshot, если снимка нет.
+func snapshotID(path string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return walNoSnapshot, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WALStorage обертка над хранилищем, дописывающая обновления в журнал WAL до их применения, а удаления
+// и обнуления - после.
+// Остальные методы IStorage выполняются хранилищем напрямую.
+type WALStorage struct {
+	IStorage
+	WAL *WAL
+}
+
+// NewWALStorage функция-конструктор для WALStorage.
+func NewWALStorage(st IStorage, wal *WAL) *WALStorage {
+	return &WALStorage{
+		IStorage: st,
+		WAL:      wal,
+	}
+}
+
+// InsertMetric дописывает обновление в журнал и затем применяет его к хранилищу, как InsertBatchMetric.
+func (w *WALStorage) InsertMetric(m *Metrics) error {
+	w.WAL.gate.RLock()
+	defer w.WAL.gate.RUnlock()
+	err := w.WAL.Append([]Metrics{*m})
+	if err != nil {
+		return err
+	}
+	return w.IStorage.InsertMetric(m)
+}
+
+// InsertBatchMetric дописывает список обновлений в журнал одной записью и затем применяет его к хранилищу.
+// Хранилища применяют список по одной метрике и на ошибке останавливаются, оставляя начало списка
+// примененным, поэтому запись делается до применения: воспроизведение той же записи поверх того же
+// состояния применяет то же начало списка и завершается той же ошибкой.
+func (w *WALStorage) InsertBatchMetric(metrics []Metrics) error {
+	w.WAL.gate.RLock()
+	defer w.WAL.gate.RUnlock()
+	err := w.WAL.Append(metrics)
+	if err != nil {
+		return err
+	}
+	return w.IStorage.InsertBatchMetric(metrics)
+}
+
+// ParamsUpdate разбирает обновление из строковых параметров, дописывает его в журнал и затем применяет
+// к хранилищу. Неверное значение и неизвестный тип отклоняются с теми же кодами, что и в хранилищах,
+// без записи в журнал.
+func (w *WALStorage) ParamsUpdate(metricType, metricName, metricValue string) (int, error) {
+	m := Metrics{ID: metricName, MType: metricType}
+	switch metricType {
+	case "gauge":
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return 400, err
+		}
+		m.Value = &value
+	case "counter":
+		delta, err := strconv.ParseInt(metricValue, 10, 64)
+		if err != nil {
+			return 400, err
+		}
+		m.Delta = &delta
+	default:
+		return w.IStorage.ParamsUpdate(metricType, metricName, metricValue)
+	}
+	w.WAL.gate.RLock()
+	defer w.WAL.gate.RUnlock()
+	err := w.WAL.Append([]Metrics{m})
+	if err != nil {
+		return 500, err
+	}
+	return w.IStorage.ParamsUpdate(metricType, metricName, metricValue)
+}
+
+// DeleteMetrics удаляет ряды метрик из хранилища и, если что-то удалено, дописывает удаление в журнал.
+func (w *WALStorage) DeleteMetrics(metrics []Metrics) (int, error) {
+	w.WAL.gate.RLock()
+	defer w.WAL.gate.RUnlock()
+	deleted, err := w.IStorage.DeleteMetrics(metrics)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	return deleted, w.WAL.append(walRecord{Op: walOpDelete, Metrics: metrics})
+}
+
+// ResetCounter обнуляет counter в хранилище и, если ряд найден, дописывает обнуление в журнал.
+func (w *WALStorage) ResetCounter(m *Metrics) (bool, error) {
+	w.WAL.gate.RLock()
+	defer w.WAL.gate.RUnlock()
+	found, err := w.IStorage.ResetCounter(m)
+	if err != nil || !found {
+		return found, err
+	}
+	reset := []Metrics{{ID: m.ID, MType: "counter", Labels: m.Labels}}
+	return found, w.WAL.append(walRecord{Op: walOpReset, Metrics: reset})
+}
+
+// SweepStale помечает или удаляет устаревшие ряды в хранилище. Удаленные ряды дописываются в журнал как
+// удаление, пометка устаревания в журнал не пишется: после восстановления ее снова выставит очистка.
+func (w *WALStorage) SweepStale(before time.Time, evict bool) ([]Metrics, error) {
+	w.WAL.gate.RLock()
+	defer w.WAL.gate.RUnlock()
+	swept, err := w.IStorage.SweepStale(before, evict)
+	if err != nil || !evict || len(swept) == 0 {
+		return swept, err
+	}
+	return swept, w.WAL.append(walRecord{Op: walOpDelete, Metrics: swept})
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func newWALTestStorage(t *testing.T, dir string) (*MemoryStorage, *FileStorage) {
+	t.Helper()
+	st := &MemoryStorage{
+		GaugeMetrics:   make(map[string]float64),
+		CounterMetrics: make(map[string]int64),
+	}
+	fs := &FileStorage{
+		FilePath:  filepath.Join(dir, "metrics.json"),
+		StoreData: true,
+		WALPath:   filepath.Join(dir, "metrics.wal"),
+	}
+	return st, fs
+}
+
+func Test_decodeWALRecord(t *testing.T) {
+	v := 1.5
+	line, err := encodeWALRecord(walRecord{Op: walOpUpdate, Metrics: []Metrics{{ID: "Alloc", MType: "gauge", Value: &v}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := string(line[:len(line)-1])
+	tests := []struct {
+		name    string
+		line    string
+		wantErr bool
+	}{
+		{name: "valid", line: valid},
+		{name: "no checksum", line: valid[9:], wantErr: true},
+		{name: "bad checksum", line: "00000000" + valid[8:], wantErr: true},
+		{name: "torn", line: valid[:len(valid)-3], wantErr: true},
+		{name: "not json", line: "00000000 {", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, err := decodeWALRecord(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeWALRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, *rec.Metrics[0].Value, v)
+			}
+		})
+	}
+}
+
+func TestFileStorage_RestoreWAL(t *testing.T) {
+	dir := t.TempDir()
+	st, fs := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.OpenWAL(), nil)
+	assert.Equal(t, fs.Checkpoint(st), nil)
+	ws := NewWALStorage(st, fs.WAL)
+
+	v, d := 2.5, int64(3)
+	assert.Equal(t, ws.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+	assert.Equal(t, fs.Checkpoint(ws), nil)
+	assert.Equal(t, ws.InsertBatchMetric([]Metrics{{ID: "PollCount", MType: "counter", Delta: &d}}), nil)
+	code, err := ws.ParamsUpdate("counter", "PollCount", "4")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, code, 200)
+	code, _ = ws.ParamsUpdate("counter", "PollCount", "bad")
+	assert.Equal(t, code, 400)
+	assert.Equal(t, fs.WAL.Close(), nil)
+
+	// Оборванная при сбое запись в конце журнала отбрасывается.
+	wal, err := os.OpenFile(fs.WALPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.WriteString(`0badc0de {"op":"update","metr`)
+	wal.Close()
+
+	want := map[string]int64{"PollCount": 7}
+	restored, _ := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.Restore(restored), nil)
+	assert.Equal(t, restored.GaugeMetrics, map[string]float64{"Alloc": 2.5})
+	assert.Equal(t, restored.CounterMetrics, want)
+
+	// Снимок сохранен, но журнал не успел обрезаться: его обновления уже в снимке и не применяются повторно.
+	f, err := os.Create(fs.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, restored.SaveToFile(f), nil)
+	f.Close()
+	again, _ := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.Restore(again), nil)
+	assert.Equal(t, again.CounterMetrics, want)
+}
+
+func TestFileStorage_RestoreWALDeletes(t *testing.T) {
+	dir := t.TempDir()
+	st, fs := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.OpenWAL(), nil)
+	assert.Equal(t, fs.Checkpoint(st), nil)
+	ws := NewWALStorage(st, fs.WAL)
+
+	v, d := 2.5, int64(3)
+	assert.Equal(t, ws.InsertBatchMetric([]Metrics{
+		{ID: "CPUutilization7", MType: "gauge", Value: &v},
+		{ID: "Alloc", MType: "gauge", Value: &v},
+		{ID: "PollCount", MType: "counter", Delta: &d},
+	}), nil)
+	// Удаления и обнуления из снимка тоже должны пережить перезапуск.
+	assert.Equal(t, fs.Checkpoint(ws), nil)
+	deleted, err := ws.DeleteMetrics([]Metrics{{ID: "CPUutilization7", MType: "gauge"}, {ID: "Missing", MType: "gauge"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, 1)
+	found, err := ws.ResetCounter(&Metrics{ID: "PollCount", MType: "counter"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found, true)
+	assert.Equal(t, ws.InsertMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+	found, err = ws.ResetCounter(&Metrics{ID: "Missing", MType: "counter"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found, false)
+	assert.Equal(t, fs.WAL.Close(), nil)
+
+	restored, _ := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.Restore(restored), nil)
+	assert.Equal(t, restored.GaugeMetrics, map[string]float64{"Alloc": 2.5})
+	assert.Equal(t, restored.CounterMetrics, map[string]int64{"PollCount": 3})
+}
+
+func TestFileStorage_RestoreWALPartialBatch(t *testing.T) {
+	dir := t.TempDir()
+	st, fs := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.OpenWAL(), nil)
+	assert.Equal(t, fs.Checkpoint(st), nil)
+	ws := NewWALStorage(st, fs.WAL)
+
+	v, d := 2.5, int64(3)
+	// Хранилище останавливается на метрике неизвестного типа, приняв только прирост counter перед ней.
+	assert.NotEqual(t, ws.InsertBatchMetric([]Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &d},
+		{ID: "Summary", MType: "summary"},
+		{ID: "Lost", MType: "gauge", Value: &v},
+	}), nil)
+	assert.Equal(t, st.CounterMetrics, map[string]int64{"PollCount": 3})
+	assert.Equal(t, ws.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+	assert.Equal(t, fs.WAL.Close(), nil)
+
+	restored, _ := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.Restore(restored), nil)
+	assert.Equal(t, restored.CounterMetrics, st.CounterMetrics)
+	assert.Equal(t, restored.GaugeMetrics, st.GaugeMetrics)
+}
+
+func TestFileStorage_RestoreWALStaleEvictions(t *testing.T) {
+	dir := t.TempDir()
+	st, fs := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.OpenWAL(), nil)
+	assert.Equal(t, fs.Checkpoint(st), nil)
+	ws := NewWALStorage(st, fs.WAL)
+
+	v := 2.5
+	assert.Equal(t, ws.InsertBatchMetric([]Metrics{
+		{ID: "CPUutilization7", MType: "gauge", Labels: map[string]string{"host": "a"}, Value: &v},
+		{ID: "Alloc", MType: "gauge", Value: &v},
+	}), nil)
+	assert.Equal(t, fs.Checkpoint(ws), nil)
+	// Пометка устаревания в журнал не пишется, удаление - пишется.
+	swept, err := ws.SweepStale(time.Now().Add(time.Second), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(swept), 2)
+	assert.Equal(t, ws.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+	swept, err = ws.SweepStale(time.Now().Add(-time.Second), true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(swept), 0)
+	swept, err = ws.SweepStale(time.Now().Add(time.Second), true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(swept), 2)
+	assert.Equal(t, ws.InsertMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &v}), nil)
+	assert.Equal(t, fs.WAL.Close(), nil)
+
+	restored, _ := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.Restore(restored), nil)
+	assert.Equal(t, restored.GaugeMetrics, map[string]float64{"Alloc": 2.5})
+}
+
+func TestFileStorage_CheckpointConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	st, fs := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.OpenWAL(), nil)
+	assert.Equal(t, fs.Checkpoint(st), nil)
+	ws := NewWALStorage(st, fs.WAL)
+
+	// Обновления, пришедшие во время записи снимка, должны попасть либо в снимок, либо в новый журнал.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d := int64(1)
+		for i := 0; i < 2000; i++ {
+			ws.InsertMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &d})
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			assert.Equal(t, fs.Checkpoint(ws), nil)
+		}
+	}
+	assert.Equal(t, fs.WAL.Close(), nil)
+
+	restored, _ := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.Restore(restored), nil)
+	assert.Equal(t, restored.CounterMetrics, map[string]int64{"PollCount": 2000})
+}
+
+func TestFileStorage_RestoreWALNext(t *testing.T) {
+	dir := t.TempDir()
+	st, fs := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.OpenWAL(), nil)
+	assert.Equal(t, fs.Checkpoint(st), nil)
+	ws := NewWALStorage(st, fs.WAL)
+
+	d := int64(3)
+	assert.Equal(t, ws.InsertMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+	// Шаги Checkpoint со сбоем после переименования снимка, но до переименования нового журнала.
+	frozen := st.freeze()
+	from, err := fs.WAL.offset()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ws.InsertMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &d}), nil)
+	tmp, err := fs.prepareSnapshot(frozen)
+	assert.Equal(t, err, nil)
+	base, err := snapshotID(tmp)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, fs.WAL.prepareNext(base, from), nil)
+	assert.Equal(t, fs.installSnapshot(tmp), nil)
+	assert.Equal(t, fs.WAL.Close(), nil)
+
+	restored, _ := newWALTestStorage(t, dir)
+	assert.Equal(t, fs.Restore(restored), nil)
+	assert.Equal(t, restored.CounterMetrics, map[string]int64{"PollCount": 6})
+}
+
+func TestWAL_SyncInterval(t *testing.T) {
+	wal, err := OpenWAL(filepath.Join(t.TempDir(), "metrics.wal"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	assert.Equal(t, wal.Reset(walNoSnapshot), nil)
+	d := int64(1)
+	assert.Equal(t, wal.Append([]Metrics{{ID: "PollCount", MType: "counter", Delta: &d}}), nil)
+	assert.Equal(t, wal.dirty, true)
+	assert.Equal(t, wal.Sync(), nil)
+	assert.Equal(t, wal.dirty, false)
+
+	replayed, err := ReplayWAL(wal.Path, walNoSnapshot, NewShardedStorage(1, nil))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, replayed, 1)
+	replayed, err = ReplayWAL(filepath.Join(t.TempDir(), "missing.wal"), walNoSnapshot, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, replayed, 0)
+}
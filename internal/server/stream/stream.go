@@ -0,0 +1,138 @@
+// Package stream реализует рассылку обновлений метрик подписчикам, например клиентам Server-Sent Events
+// или WebSocket. Публикация никогда не блокирует запись метрик: у каждого подписчика есть буфер, и если
+// подписчик не успевает его разбирать, он отключается.
+package stream
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+// DefaultBufferSize размер буфера подписчика по умолчанию.
+const DefaultBufferSize = 256
+
+// Filter условие отбора метрик для подписчика. Пустые поля не ограничивают выборку. Name считается
+// шаблоном в формате path.Match, если содержит символы *, ? или [, иначе - префиксом названия.
+type Filter struct {
+	Type string
+	Name string
+}
+
+// Match проверяет, подходит ли метрика под условие.
+func (f Filter) Match(m storage.Metrics) bool {
+	if f.Type != "" && f.Type != m.MType {
+		return false
+	}
+	if f.Name == "" {
+		return true
+	}
+	if strings.ContainsAny(f.Name, "*?[") {
+		ok, err := path.Match(f.Name, m.ID)
+		return err == nil && ok
+	}
+	return strings.HasPrefix(m.ID, f.Name)
+}
+
+// Subscriber подписка на обновления. Канал C закрывается при отписке, при переполнении буфера
+// и при закрытии Broker.
+type Subscriber struct {
+	C      <-chan storage.Metrics
+	ch     chan storage.Metrics
+	filter Filter
+}
+
+// Broker хранит подписчиков и рассылает им опубликованные метрики.
+type Broker struct {
+	BufferSize  int
+	subscribers map[*Subscriber]struct{}
+	closed      bool
+	mutex       sync.RWMutex
+}
+
+// NewBroker функция-конструктор для Broker с заданным размером буфера подписчика.
+func NewBroker(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Broker{
+		BufferSize:  bufferSize,
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+}
+
+// Subscribe создает подписку с условием отбора f. Если Broker уже закрыт, канал подписки сразу закрыт.
+func (b *Broker) Subscribe(f Filter) *Subscriber {
+	ch := make(chan storage.Metrics, b.BufferSize)
+	s := &Subscriber{C: ch, ch: ch, filter: f}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		close(ch)
+		return s
+	}
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe удаляет подписку и закрывает ее канал. Повторный вызов безопасен.
+func (b *Broker) Unsubscribe(s *Subscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.remove(s)
+}
+
+// remove удаляет подписчика, вызывается под блокировкой.
+func (b *Broker) remove(s *Subscriber) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+	delete(b.subscribers, s)
+	close(s.ch)
+}
+
+// Active сообщает, есть ли у Broker подписчики. Безопасен для nil.
+func (b *Broker) Active() bool {
+	if b == nil {
+		return false
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.subscribers) > 0
+}
+
+// Publish рассылает метрики подходящим подписчикам без блокировки. Подписчики с заполненным буфером
+// отключаются. Безопасен для nil, чтобы обработчики могли работать без рассылки.
+func (b *Broker) Publish(metrics ...storage.Metrics) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for s := range b.subscribers {
+	send:
+		for _, m := range metrics {
+			if !s.filter.Match(m) {
+				continue
+			}
+			select {
+			case s.ch <- m:
+			default:
+				b.remove(s)
+				break send
+			}
+		}
+	}
+}
+
+// Close отключает всех подписчиков. Новые подписки после закрытия сразу завершаются.
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		b.remove(s)
+	}
+}
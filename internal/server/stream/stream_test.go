@@ -0,0 +1,115 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert"
+
+	"github.com/dsft54/rt-metrics/internal/server/storage"
+)
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		metric storage.Metrics
+		want   bool
+	}{
+		{
+			name:   "empty filter",
+			metric: storage.Metrics{ID: "Alloc", MType: "gauge"},
+			want:   true,
+		},
+		{
+			name:   "type match",
+			filter: Filter{Type: "gauge"},
+			metric: storage.Metrics{ID: "Alloc", MType: "gauge"},
+			want:   true,
+		},
+		{
+			name:   "type mismatch",
+			filter: Filter{Type: "counter"},
+			metric: storage.Metrics{ID: "Alloc", MType: "gauge"},
+			want:   false,
+		},
+		{
+			name:   "prefix match",
+			filter: Filter{Name: "Heap"},
+			metric: storage.Metrics{ID: "HeapAlloc", MType: "gauge"},
+			want:   true,
+		},
+		{
+			name:   "prefix mismatch",
+			filter: Filter{Name: "Heap"},
+			metric: storage.Metrics{ID: "Alloc", MType: "gauge"},
+			want:   false,
+		},
+		{
+			name:   "glob match",
+			filter: Filter{Name: "*Alloc"},
+			metric: storage.Metrics{ID: "HeapAlloc", MType: "gauge"},
+			want:   true,
+		},
+		{
+			name:   "glob mismatch",
+			filter: Filter{Name: "Heap?"},
+			metric: storage.Metrics{ID: "HeapAlloc", MType: "gauge"},
+			want:   false,
+		},
+		{
+			name:   "bad glob",
+			filter: Filter{Name: "[Heap"},
+			metric: storage.Metrics{ID: "HeapAlloc", MType: "gauge"},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.filter.Match(tt.metric), tt.want)
+		})
+	}
+}
+
+func TestBroker_Publish(t *testing.T) {
+	b := NewBroker(2)
+	gauges := b.Subscribe(Filter{Type: "gauge"})
+	slow := b.Subscribe(Filter{})
+	assert.Equal(t, b.Active(), true)
+
+	b.Publish(
+		storage.Metrics{ID: "Alloc", MType: "gauge"},
+		storage.Metrics{ID: "PollCount", MType: "counter"},
+	)
+	assert.Equal(t, len(gauges.C), 1)
+	assert.Equal(t, (<-gauges.C).ID, "Alloc")
+
+	// Буфер slow заполнен, следующая подходящая метрика отключает подписчика.
+	b.Publish(storage.Metrics{ID: "Alloc", MType: "gauge"})
+	assert.Equal(t, len(gauges.C), 1)
+	received := 0
+	for range slow.C {
+		received++
+	}
+	assert.Equal(t, received, 2)
+
+	b.Unsubscribe(gauges)
+	b.Unsubscribe(gauges)
+	assert.Equal(t, b.Active(), false)
+}
+
+func TestBroker_Close(t *testing.T) {
+	var nilBroker *Broker
+	assert.Equal(t, nilBroker.Active(), false)
+	nilBroker.Publish(storage.Metrics{ID: "Alloc", MType: "gauge"})
+
+	b := NewBroker(0)
+	assert.Equal(t, b.BufferSize, DefaultBufferSize)
+	sub := b.Subscribe(Filter{})
+	b.Close()
+	_, ok := <-sub.C
+	assert.Equal(t, ok, false)
+	late := b.Subscribe(Filter{})
+	_, ok = <-late.C
+	assert.Equal(t, ok, false)
+	assert.Equal(t, b.Active(), false)
+}